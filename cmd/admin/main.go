@@ -0,0 +1,234 @@
+// Command admin performs administrative tasks against the receipt processor's database.
+//
+// Usage:
+//
+//	admin apikey create -tenant <tenant> [-admin] <name>
+//	                                              Issue an API key to the named client of a tenant.
+//	admin apikey list                             List issued API keys.
+//	admin apikey revoke <id>                      Revoke an API key.
+//	admin merchant create [-tenant <tenant>] <name> [<alias>...]
+//	                                              Add a merchant and its retailer names to the catalog.
+//	admin merchant alias [-tenant <tenant>] <id> <alias>...
+//	                                              Map more retailer names to a merchant.
+//	admin merchant list [-tenant <tenant>]        List the merchants of the catalog.
+//	admin rescore [-tenant <tenant>] [-from <date>] [-to <date>] <version>
+//	                                              Report receipts the rule version scores differently.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/database"
+	"receipt_processor/pkg/repository"
+	"receipt_processor/pkg/service"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage:
+  admin apikey create -tenant <tenant> [-admin] <name>
+                                                Issue an API key to the named client of a tenant.
+  admin apikey list                             List issued API keys.
+  admin apikey revoke <id>                      Revoke an API key.
+  admin merchant create [-tenant <tenant>] <name> [<alias>...]
+                                                Add a merchant and its retailer names to the catalog.
+  admin merchant alias [-tenant <tenant>] <id> <alias>...
+                                                Map more retailer names to a merchant.
+  admin merchant list [-tenant <tenant>]        List the merchants of the catalog.
+  admin rescore [-tenant <tenant>] [-from <date>] [-to <date>] <version>
+                                                Report receipts the rule version scores differently.
+`
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args.
+func run(ctx context.Context, args []string) error {
+	if len(args) < 2 || (args[0] != "apikey" && args[0] != "merchant" && args[0] != "rescore") {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("unknown command")
+	}
+
+	initViper()
+	dbPath := viper.GetString("database.path")
+	if dbPath == "" {
+		return errors.New("database.path is not set in configuration")
+	}
+	db, err := database.New(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the database: %v", err)
+	}
+	if args[0] == "apikey" {
+		return runAPIKey(ctx, service.NewAPIKeyService(repository.NewAPIKeyRepository(db)), args[1], args[2:])
+	}
+	if args[0] == "merchant" {
+		merchants := service.NewMerchantService(repository.NewMerchantRepository(db), repository.NewReceiptRepository(db))
+		return runMerchant(ctx, merchants, args[1], args[2:])
+	}
+	rules, err := service.LoadRuleVersions()
+	if err != nil {
+		return fmt.Errorf("failed to load points rules: %v", err)
+	}
+	return runRescore(ctx, service.NewRescoreService(repository.NewReceiptRepository(db), rules), args[1:])
+}
+
+// runAPIKey executes an "apikey" subcommand.
+func runAPIKey(ctx context.Context, apiKeys service.IAPIKeyService, command string, args []string) error {
+	switch {
+	case command == "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		tenantID := flags.String("tenant", "", "the tenant whose receipts the client may access")
+		admin := flags.Bool("admin", false, "allow the client to use the admin API, such as managing campaigns")
+		if err := flags.Parse(args); err != nil || *tenantID == "" || flags.NArg() == 0 {
+			break
+		}
+		key, info, err := apiKeys.Create(ctx, strings.Join(flags.Args(), " "), *tenantID, *admin)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %s for %q of tenant %q.\n", info.ID, info.Name, info.TenantID)
+		fmt.Println("Store it now; it cannot be shown again:")
+		fmt.Println(key)
+		return nil
+	case command == "list" && len(args) == 0:
+		keys, err := apiKeys.List(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tTENANT\tADMIN\tPREFIX\tCREATED\tREVOKED")
+		for _, key := range keys {
+			revoked := "-"
+			if key.RevokedAt != nil {
+				revoked = key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", key.ID, key.Name, key.TenantID, key.Admin, key.Prefix, key.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return w.Flush()
+	case command == "revoke" && len(args) == 1:
+		if err := apiKeys.Revoke(ctx, args[0]); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("no API key with ID %s", args[0])
+			}
+			return err
+		}
+		fmt.Printf("Revoked API key %s.\n", args[0])
+		return nil
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("invalid apikey command")
+}
+
+// runMerchant executes a "merchant" subcommand. Creating a merchant or adding aliases maps
+// the stored receipts of its retailer names that were not mapped to a merchant yet.
+func runMerchant(ctx context.Context, merchants service.IMerchantService, command string, args []string) error {
+	flags := flag.NewFlagSet("merchant "+command, flag.ContinueOnError)
+	tenantID := flags.String("tenant", auth.DefaultTenant, "the tenant whose catalog is managed")
+	if err := flags.Parse(args); err != nil {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("invalid merchant command")
+	}
+	args = flags.Args()
+
+	var merchant service.MerchantDTO
+	var err error
+	switch {
+	case command == "create" && len(args) >= 1:
+		merchant, err = merchants.Create(ctx, *tenantID, args[0], args[1:])
+	case command == "alias" && len(args) >= 2:
+		merchant, err = merchants.AddAliases(ctx, *tenantID, args[0], args[1:])
+	case command == "list" && len(args) == 0:
+		list, err := merchants.List(ctx, *tenantID)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tALIASES\tCREATED")
+		for _, merchant := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", merchant.ID, merchant.Name, strings.Join(merchant.Aliases, ", "), merchant.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("invalid merchant command")
+	}
+	switch {
+	case errors.Is(err, service.ErrMerchantNotFound):
+		return fmt.Errorf("no merchant with ID %s in tenant %q", args[0], *tenantID)
+	case errors.Is(err, service.ErrAliasTaken):
+		return fmt.Errorf("an alias is already mapped to another merchant; see admin merchant list")
+	case err != nil:
+		return err
+	}
+	fmt.Printf("Merchant %s %q of tenant %q has the aliases: %s.\n", merchant.ID, merchant.Name, *tenantID, strings.Join(merchant.Aliases, ", "))
+
+	mapped, err := merchants.MapReceipts(ctx, *tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to map stored receipts: %v", err)
+	}
+	fmt.Printf("Mapped %d stored receipts to merchants.\n", mapped)
+	return nil
+}
+
+// runRescore executes the "rescore" command. The stored points are left unchanged.
+func runRescore(ctx context.Context, rescore service.IRescoreService, args []string) error {
+	flags := flag.NewFlagSet("rescore", flag.ContinueOnError)
+	tenantID := flags.String("tenant", auth.DefaultTenant, "the tenant whose receipts are rescored")
+	from := flags.String("from", "", "the first purchase date rescored (YYYY-MM-DD)")
+	to := flags.String("to", "", "the last purchase date rescored (YYYY-MM-DD)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("invalid rescore command")
+	}
+	for _, date := range []string{*from, *to} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return fmt.Errorf("invalid date %q: expected YYYY-MM-DD", date)
+		}
+	}
+
+	report, err := rescore.Rescore(ctx, *tenantID, flags.Arg(0), *from, *to)
+	if err != nil {
+		return err
+	}
+	if len(report.Diffs) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RECEIPT\tPURCHASED\tVERSION\tPOINTS\tNEW POINTS\tDIFFERENCE")
+		for _, diff := range report.Diffs {
+			version := diff.RuleVersion
+			if version == "" {
+				version = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%+d\n", diff.ReceiptID, diff.PurchaseDate, version, diff.Points, diff.NewPoints, diff.NewPoints-diff.Points)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Rescored %d receipts of tenant %q with rule version %s: %d scored differently. No points were changed.\n",
+		report.Receipts, *tenantID, report.RuleVersion, len(report.Diffs))
+	return nil
+}
+
+func initViper() {
+	// Use the same configuration as the server.
+	viper.SetConfigName("config")
+	viper.AddConfigPath("config")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	if err := viper.ReadInConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "admin: no configuration file loaded; defaults will be used")
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"net/http"
+	"strings"
+	"time"
 
 	"receipt_processor/pkg/api"
+	"receipt_processor/pkg/auth"
 	"receipt_processor/pkg/database"
 	"receipt_processor/pkg/middleware"
 	"receipt_processor/pkg/redis"
@@ -22,12 +27,6 @@ func main() {
 	// Initialize configuration using Viper.
 	initViper()
 
-	// Initialize Redis client.
-	redisClient, err := redis.New()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize Redis")
-	}
-
 	// Set up the SQLite database.
 	dbPath := viper.GetString("database.path")
 	if dbPath == "" {
@@ -38,22 +37,96 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to connect to the database")
 	}
 
-	// Initialize the receipt repository and service.
+	// Load the versions of the points rules from configuration.
+	rules, err := service.LoadRuleVersions()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load points rules")
+	}
+	activeVersion, _ := rules.Active()
+	log.Info().Str("rule_version", activeVersion).Strs("rule_versions", rules.IDs()).Msg("Loaded points rules")
+
+	// Load the total consistency policy from configuration.
+	consistency, err := service.LoadConsistencyPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load consistency policy")
+	}
+
+	// Initialize the campaign repository and service, for the promotions applied at ingest.
+	campaignRepo := repository.NewCampaignRepository(db)
+	campaignService := service.NewCampaignService(campaignRepo)
+
+	// Initialize the receipt repository and service, mapping receipts to the merchant catalog.
 	receiptRepo := repository.NewReceiptRepository(db)
-	receiptService := service.NewReceiptService(receiptRepo)
+	receiptService := service.NewReceiptService(receiptRepo, campaignRepo, repository.NewMerchantRepository(db), rules, consistency)
+
+	// Initialize the points ledger repository and service, for the users' balances and redemptions.
+	pointsService := service.NewPointsService(repository.NewLedgerRepository(db))
+
+	// Expire unspent points in the background, if configured.
+	expirationPolicy, err := service.LoadExpirationPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load points expiration")
+	}
+	service.NewExpirationService(repository.NewExpirationRepository(db), expirationPolicy).Start(context.Background())
+
+	// Initialize the API key repository and service, and load the authentication settings.
+	apiKeyService := service.NewAPIKeyService(repository.NewAPIKeyRepository(db))
+	apiKeySettings, err := middleware.LoadAPIKeySettings()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load API key settings")
+	}
+
+	// Verify JWT bearer tokens, if enabled, against the configured signing keys.
+	jwtSettings, err := auth.LoadJWTSettings()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load JWT settings")
+	}
+
+	// Load the rate limit policies from configuration.
+	rateLimitPolicies, err := middleware.LoadRateLimitPolicies()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load rate limit policies")
+	}
+
+	// Resolve client IPs behind the configured trusted proxies.
+	clientIPResolver, err := middleware.LoadClientIPResolver()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load trusted proxies")
+	}
 
 	// Initialize the rate limiter repository and middleware.
-	rateLimiterRepo := repository.NewRateLimiterRepository(redisClient.Rdb)
-	rateLimiterMiddleware := middleware.RateLimitMiddleware(rateLimiterRepo)
+	rateLimiterRepo := newRateLimiterRepository()
+	rateLimiterMiddleware := middleware.RateLimitMiddleware(rateLimiterRepo, rateLimitPolicies)
 
-	// Combine middleware: e.g., request ID and rate limiter.
+	// Combine middleware, in the order it runs: request ID, client IP, bearer token,
+	// API key and rate limiter.
 	middlewares := []middleware.Middleware{
 		middleware.RequestIDMiddleware(),
-		rateLimiterMiddleware,
+		middleware.ClientIPMiddleware(clientIPResolver),
+	}
+	if jwtSettings.Enabled {
+		verifier, err := auth.NewJWTVerifier(jwtSettings)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid JWT settings")
+		}
+		middlewares = append(middlewares, middleware.JWTMiddleware(verifier, jwtSettings.TenantClaim))
 	}
+	middlewares = append(middlewares,
+		middleware.APIKeyMiddleware(apiKeyService, apiKeySettings),
+		rateLimiterMiddleware,
+	)
 
 	// Set up the API router with handlers and the middleware chain.
-	router := api.NewRouter(receiptService, middlewares)
+	router := api.NewRouter(receiptService, pointsService, campaignService, middlewares)
+
+	// Serve the metrics, such as the state of the rate limiter's circuit breaker, on their own port.
+	if metricsPort := viper.GetString("server.metrics_port"); metricsPort != "" {
+		go func() {
+			if err := http.ListenAndServe(":"+metricsPort, expvar.Handler()); err != nil {
+				log.Error().Err(err).Msg("Metrics server failed")
+			}
+		}()
+	}
 
 	// Determine the server port.
 	port := viper.GetString("server.port")
@@ -68,6 +141,67 @@ func main() {
 	}
 }
 
+// newRateLimiterRepository creates the rate limiter backend selected by "rate_limit.backend":
+// "redis" (the default) or "memory", which keeps its state in process and needs no Redis server.
+// The Redis backend is wrapped in a circuit breaker, and handles outages with "rate_limit.failure_mode".
+func newRateLimiterRepository() repository.IRateLimiterRepository {
+	switch backend := viper.GetString("rate_limit.backend"); backend {
+	case "memory":
+		log.Info().Msg("Using the in-memory rate limiter")
+		return newMemoryRateLimiterRepository()
+	case "", "redis":
+		// Initialize Redis client.
+		redisClient, err := redis.New()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize Redis")
+		}
+		return withCircuitBreaker(repository.NewRateLimiterRepository(redisClient.Rdb))
+	default:
+		log.Fatal().Msgf("Unknown rate limiter backend %q", backend)
+		return nil
+	}
+}
+
+// newMemoryRateLimiterRepository creates an in-memory rate limiter configured by "rate_limit.memory".
+func newMemoryRateLimiterRepository() repository.IRateLimiterRepository {
+	maxKeys := viper.GetInt("rate_limit.memory.max_keys")
+	if maxKeys <= 0 {
+		maxKeys = 100000
+	}
+	evictionInterval := viper.GetDuration("rate_limit.memory.eviction_interval")
+	if evictionInterval <= 0 {
+		evictionInterval = time.Minute
+	}
+	return repository.NewMemoryRateLimiterRepository(context.Background(), maxKeys, evictionInterval)
+}
+
+// withCircuitBreaker wraps the rate limiter in a circuit breaker configured by
+// "rate_limit.failure_mode" and "rate_limit.circuit_breaker".
+func withCircuitBreaker(limiter repository.IRateLimiterRepository) repository.IRateLimiterRepository {
+	settings := repository.CircuitBreakerSettings{
+		Mode:             repository.FailLocal,
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		Timeout:          250 * time.Millisecond,
+	}
+	if mode := viper.GetString("rate_limit.failure_mode"); mode != "" {
+		settings.Mode = repository.FailureMode(mode)
+	}
+	if err := viper.UnmarshalKey("rate_limit.circuit_breaker", &settings); err != nil {
+		log.Fatal().Err(err).Msg("Invalid rate limiter circuit breaker settings")
+	}
+	if err := settings.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid rate limiter circuit breaker settings")
+	}
+
+	var fallback repository.IRateLimiterRepository
+	if settings.Mode == repository.FailLocal {
+		fallback = newMemoryRateLimiterRepository()
+	}
+	log.Info().Str("failureMode", string(settings.Mode)).Msg("Using the Redis rate limiter")
+	return repository.NewCircuitBreakerRateLimiterRepository(limiter, fallback, settings)
+}
+
 func initLogger() {
 	// Use Unix time for timestamps.
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -81,7 +215,9 @@ func initViper() {
 	// Set the config file name and path.
 	viper.SetConfigName("config")
 	viper.AddConfigPath("config")
-	viper.AutomaticEnv() // Override config with environment variables if set.
+	// Override config with environment variables if set, e.g. RATE_LIMIT_BACKEND for rate_limit.backend.
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
 	if err := viper.ReadInConfig(); err != nil {
 		log.Warn().Msg("No configuration file loaded; defaults will be used")
 	}
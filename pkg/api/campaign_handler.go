@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/openapi"
+	"receipt_processor/pkg/problem"
+	"receipt_processor/pkg/service"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CampaignsHandler handles GET and POST /admin/campaigns.
+// GET lists the campaigns of the client's tenant; POST creates one from the JSON body.
+func (r *Router) CampaignsHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/admin/campaigns")
+	if !ok || !requireAdmin(w, req) {
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		campaigns, err := r.campaignService.List(req.Context())
+		if err != nil {
+			log.Ctx(req.Context()).Error().Err(err).Msg("Failed to list campaigns")
+			problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+			return
+		}
+		writeCampaign(w, req, http.StatusOK, map[string][]service.CampaignDTO{"campaigns": campaigns})
+		return
+	}
+
+	campaign, ok := decodeCampaign(w, req, op)
+	if !ok {
+		return
+	}
+	created, err := r.campaignService.Create(req.Context(), campaign)
+	if err != nil {
+		writeCampaignError(w, req, err)
+		return
+	}
+	writeCampaign(w, req, http.StatusCreated, created)
+}
+
+// CampaignHandler handles GET, PUT and DELETE /admin/campaigns/{id}.
+// GET returns the campaign, PUT replaces it with the JSON body and DELETE deletes it.
+func (r *Router) CampaignHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/admin/campaigns/{id}")
+	if !ok || !requireAdmin(w, req) {
+		return
+	}
+
+	// Expecting URL format: /admin/campaigns/{id}.
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "admin" || parts[1] != "campaigns" {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	campaignID := parts[2]
+	if !validateParameters(w, req, op, map[string]string{"id": campaignID}) {
+		return
+	}
+
+	var campaign service.CampaignDTO
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		campaign, err = r.campaignService.Get(req.Context(), campaignID)
+	case http.MethodPut:
+		update, ok := decodeCampaign(w, req, op)
+		if !ok {
+			return
+		}
+		campaign, err = r.campaignService.Update(req.Context(), campaignID, update)
+	case http.MethodDelete:
+		err = r.campaignService.Delete(req.Context(), campaignID)
+	}
+	switch {
+	case err != nil:
+		writeCampaignError(w, req, err)
+	case req.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeCampaign(w, req, http.StatusOK, campaign)
+	}
+}
+
+// requireAdmin checks that the client may use the admin API. If not, it writes a 403
+// response and returns false.
+func requireAdmin(w http.ResponseWriter, req *http.Request) bool {
+	if identity, ok := auth.FromContext(req.Context()); ok && identity.Admin {
+		return true
+	}
+	problem.Write(w, req, problem.New(http.StatusForbidden, "The client may not use the admin API."))
+	return false
+}
+
+// decodeCampaign validates the JSON body against the operation and decodes it. If it is
+// invalid, it writes a 400 response and returns false.
+func decodeCampaign(w http.ResponseWriter, req *http.Request, op *openapi.Operation) (service.CampaignDTO, bool) {
+	var campaign service.CampaignDTO
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to read request body")
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The campaign is invalid."))
+		return campaign, false
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The campaign is invalid."))
+		return campaign, false
+	}
+	if errs := op.BodySchema("application/json").Validate(value, ""); len(errs) > 0 {
+		problem.Write(w, req, problem.Validation("The campaign is invalid.", errs))
+		return campaign, false
+	}
+	if err := json.Unmarshal(body, &campaign); err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The campaign is invalid."))
+		return campaign, false
+	}
+	return campaign, true
+}
+
+// writeCampaignError writes the response for an error of the campaign service.
+func writeCampaignError(w http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrCampaignNotFound):
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No campaign found for that ID."))
+	case errors.Is(err, service.ErrInvalidCampaign):
+		reason := strings.TrimPrefix(err.Error(), service.ErrInvalidCampaign.Error()+": ")
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The campaign is invalid: "+reason+"."))
+	default:
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to manage campaign")
+		problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+	}
+}
+
+// writeCampaign writes a campaign response with the given status.
+func writeCampaign(w http.ResponseWriter, req *http.Request, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/service"
+)
+
+// fakeCampaignService is a fake implementation of service.ICampaignService for testing.
+// It returns ErrCampaignNotFound for "missing-id" and another error for "error-id".
+type fakeCampaignService struct{}
+
+func (f *fakeCampaignService) Create(ctx context.Context, campaign service.CampaignDTO) (service.CampaignDTO, error) {
+	if campaign.Multiplier <= 1 && campaign.BonusPoints == 0 {
+		return service.CampaignDTO{}, fmt.Errorf("%w: a multiplier above 1 or bonusPoints is required", service.ErrInvalidCampaign)
+	}
+	campaign.ID = "campaign-1"
+	return campaign, nil
+}
+
+func (f *fakeCampaignService) Get(ctx context.Context, id string) (service.CampaignDTO, error) {
+	if err := campaignError(id); err != nil {
+		return service.CampaignDTO{}, err
+	}
+	return service.CampaignDTO{ID: id, Name: "Double points", StartDate: "2022-12-01", EndDate: "2022-12-31", Multiplier: 2}, nil
+}
+
+func (f *fakeCampaignService) List(ctx context.Context) ([]service.CampaignDTO, error) {
+	return []service.CampaignDTO{{ID: "campaign-1", Name: "Double points", StartDate: "2022-12-01", EndDate: "2022-12-31", Multiplier: 2}}, nil
+}
+
+func (f *fakeCampaignService) Update(ctx context.Context, id string, campaign service.CampaignDTO) (service.CampaignDTO, error) {
+	if err := campaignError(id); err != nil {
+		return service.CampaignDTO{}, err
+	}
+	campaign.ID = id
+	return campaign, nil
+}
+
+func (f *fakeCampaignService) Delete(ctx context.Context, id string) error {
+	return campaignError(id)
+}
+
+// campaignError returns the error the fake service returns for the campaign.
+func campaignError(id string) error {
+	switch id {
+	case "missing-id":
+		return service.ErrCampaignNotFound
+	case "error-id":
+		return errors.New("database error")
+	}
+	return nil
+}
+
+// adminRequest returns a request made with an admin API key.
+func adminRequest(method, url, body string) *http.Request {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	return req.WithContext(auth.NewContext(req.Context(), auth.Identity{ClientID: "admin-key", Method: auth.MethodAPIKey, TenantID: "tenant-a", Admin: true}))
+}
+
+func TestCampaignsHandler(t *testing.T) {
+	router := &Router{campaignService: &fakeCampaignService{}}
+
+	testCases := []struct {
+		name                      string
+		method                    string
+		body                      string
+		notAdmin                  bool
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "List",
+			method:                    http.MethodGet,
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"campaigns":[{"id":"campaign-1","name":"Double points"`,
+		},
+		{
+			name:                      "Create",
+			method:                    http.MethodPost,
+			body:                      `{"name": "Gatorade bonus", "startDate": "2022-12-01", "endDate": "2022-12-31", "itemMatchers": ["gatorade"], "bonusPoints": 50}`,
+			expectedStatus:            http.StatusCreated,
+			expectedResponseSubstring: `"id":"campaign-1","name":"Gatorade bonus"`,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodDelete,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:                      "Not Admin",
+			method:                    http.MethodGet,
+			notAdmin:                  true,
+			expectedStatus:            http.StatusForbidden,
+			expectedResponseSubstring: `"detail":"The client may not use the admin API."`,
+		},
+		{
+			name:           "Invalid JSON",
+			method:         http.MethodPost,
+			body:           `{"name":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:                      "Invalid Weekday",
+			method:                    http.MethodPost,
+			body:                      `{"name": "Weekends", "startDate": "2022-12-01", "endDate": "2022-12-31", "weekdays": ["saturday"], "multiplier": 2}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"pointer":"/weekdays/0"`,
+		},
+		{
+			name:                      "Invalid Time",
+			method:                    http.MethodPost,
+			body:                      `{"name": "Afternoons", "startDate": "2022-12-01", "endDate": "2022-12-31", "startTime": "2pm", "endTime": "16:00", "multiplier": 2}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"pointer":"/startTime"`,
+		},
+		{
+			name:                      "No Points",
+			method:                    http.MethodPost,
+			body:                      `{"name": "Nothing", "startDate": "2022-12-01", "endDate": "2022-12-31"}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"detail":"The campaign is invalid: a multiplier above 1 or bonusPoints is required."`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := adminRequest(tc.method, "/admin/campaigns", tc.body)
+			if tc.notAdmin {
+				req = httptest.NewRequest(tc.method, "/admin/campaigns", strings.NewReader(tc.body))
+			}
+			w := httptest.NewRecorder()
+			// Call the CampaignsHandler directly.
+			router.CampaignsHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
+
+func TestCampaignHandler(t *testing.T) {
+	router := &Router{campaignService: &fakeCampaignService{}}
+
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		body                      string
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Get",
+			method:                    http.MethodGet,
+			url:                       "/admin/campaigns/campaign-1",
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"id":"campaign-1","name":"Double points"`,
+		},
+		{
+			name:                      "Update",
+			method:                    http.MethodPut,
+			url:                       "/admin/campaigns/campaign-1",
+			body:                      `{"name": "Triple points", "startDate": "2022-12-01", "endDate": "2022-12-31", "multiplier": 3}`,
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"id":"campaign-1","name":"Triple points"`,
+		},
+		{
+			name:           "Delete",
+			method:         http.MethodDelete,
+			url:            "/admin/campaigns/campaign-1",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodPost,
+			url:            "/admin/campaigns/campaign-1",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid URL",
+			method:         http.MethodGet,
+			url:            "/invalid/campaigns/campaign-1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:                      "Invalid Body",
+			method:                    http.MethodPut,
+			url:                       "/admin/campaigns/campaign-1",
+			body:                      `{"name": "Triple points", "startDate": "2022-12-01", "endDate": "2022-12-31", "multiplier": 0.5}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"pointer":"/multiplier"`,
+		},
+		{
+			name:                      "Campaign Not Found",
+			method:                    http.MethodGet,
+			url:                       "/admin/campaigns/missing-id",
+			expectedStatus:            http.StatusNotFound,
+			expectedResponseSubstring: `"detail":"No campaign found for that ID."`,
+		},
+		{
+			name:           "Delete Not Found",
+			method:         http.MethodDelete,
+			url:            "/admin/campaigns/missing-id",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodGet,
+			url:            "/admin/campaigns/error-id",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := adminRequest(tc.method, tc.url, tc.body)
+			w := httptest.NewRecorder()
+			// Call the CampaignHandler directly.
+			router.CampaignHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/problem"
+	"receipt_processor/pkg/service"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GetUserPointsHandler handles GET /users/{id}/points.
+// It returns the user's points balance, with the most recent ledger entries, as JSON.
+// Only the user and admins may see the balance.
+func (r *Router) GetUserPointsHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/users/{id}/points")
+	if !ok {
+		return
+	}
+
+	// Expecting URL format: /users/{id}/points.
+	userID, ok := userIDFromPath(req.URL.Path, "points")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	if !validateParameters(w, req, op, map[string]string{"id": userID}) || !requireUser(w, req, userID) {
+		return
+	}
+
+	// Retrieve the balance via the service layer.
+	points, err := r.pointsService.GetUserPoints(req.Context(), userID)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to get user points")
+		problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(points); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// redemptionRequest is the body of a redemption.
+type redemptionRequest struct {
+	Points int `json:"points"`
+}
+
+// RedeemPointsHandler handles POST /users/{id}/redemptions.
+// It debits the points in the JSON body from the user's balance. The Idempotency-Key header
+// identifies the redemption: a retry with the same key returns the original redemption,
+// marked by an Idempotent-Replayed header, instead of debiting the points again. Only the
+// user and admins may redeem the user's points.
+func (r *Router) RedeemPointsHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/users/{id}/redemptions")
+	if !ok {
+		return
+	}
+
+	// Expecting URL format: /users/{id}/redemptions.
+	userID, ok := userIDFromPath(req.URL.Path, "redemptions")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	if !validateParameters(w, req, op, map[string]string{"id": userID}) || !requireUser(w, req, userID) {
+		return
+	}
+
+	// Validate the body against the spec and unmarshal it.
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to read request body")
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The redemption is invalid."))
+		return
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The redemption is invalid."))
+		return
+	}
+	if errs := op.BodySchema("application/json").Validate(value, ""); len(errs) > 0 {
+		problem.Write(w, req, problem.Validation("The redemption is invalid.", errs))
+		return
+	}
+	var redemption redemptionRequest
+	if err := json.Unmarshal(body, &redemption); err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The redemption is invalid."))
+		return
+	}
+
+	// Redeem the points via the service layer.
+	result, err := r.pointsService.Redeem(req.Context(), userID, req.Header.Get("Idempotency-Key"), redemption.Points)
+	if errors.Is(err, service.ErrInsufficientPoints) {
+		problem.Write(w, req, problem.New(http.StatusConflict, "The user does not have enough points."))
+		return
+	}
+	if errors.Is(err, service.ErrIdempotencyKeyReused) {
+		problem.Write(w, req, problem.New(http.StatusUnprocessableEntity, "The idempotency key was already used for a different redemption."))
+		return
+	}
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to redeem points")
+		problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// requireUser checks that the client may act for the user: the user's own token, or an
+// admin API key. If not, it writes a 403 response and returns false.
+func requireUser(w http.ResponseWriter, req *http.Request, userID string) bool {
+	if auth.CanActFor(req.Context(), userID) {
+		return true
+	}
+	problem.Write(w, req, problem.New(http.StatusForbidden, "The client may not act for the user."))
+	return false
+}
+
+// userIDFromPath extracts the user ID from a path of the form /users/{id}/{suffix}.
+func userIDFromPath(path, suffix string) (string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "users" || parts[2] != suffix {
+		return "", false
+	}
+	return parts[1], true
+}
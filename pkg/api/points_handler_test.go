@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/service"
+)
+
+// fakeUserPointsService is a fake implementation of service.IPointsService for testing the handlers.
+type fakeUserPointsService struct{}
+
+func (f *fakeUserPointsService) GetUserPoints(ctx context.Context, userID string) (service.UserPointsDTO, error) {
+	if userID == "error-user" {
+		return service.UserPointsDTO{}, errors.New("database unavailable")
+	}
+	return service.UserPointsDTO{
+		UserID:  userID,
+		Points:  35,
+		Entries: []service.LedgerEntryDTO{{Kind: "earn", Points: 35, ReceiptID: "receipt-1"}},
+	}, nil
+}
+
+func (f *fakeUserPointsService) Redeem(ctx context.Context, userID, idempotencyKey string, points int) (service.RedemptionDTO, error) {
+	switch idempotencyKey {
+	case "reused-key":
+		return service.RedemptionDTO{}, service.ErrIdempotencyKeyReused
+	case "error-key":
+		return service.RedemptionDTO{}, errors.New("database unavailable")
+	}
+	if points > 35 {
+		return service.RedemptionDTO{}, service.ErrInsufficientPoints
+	}
+	return service.RedemptionDTO{ID: "7", UserID: userID, Points: points, Balance: 35 - points, Replayed: idempotencyKey == "replayed-key"}, nil
+}
+
+// userRequest returns a request made with a bearer token for the user.
+func userRequest(method, url, body, userID string) *http.Request {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	ctx := auth.WithClaims(req.Context(), auth.Claims{Subject: userID})
+	return req.WithContext(auth.NewContext(ctx, auth.Identity{ClientID: userID, Name: userID, Method: auth.MethodJWT, TenantID: auth.DefaultTenant}))
+}
+
+func TestGetUserPointsHandler(t *testing.T) {
+	router := &Router{pointsService: &fakeUserPointsService{}}
+
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		user                      string // Subject of the bearer token, if any.
+		admin                     bool   // Whether the request is made with an admin API key instead.
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid User",
+			method:                    http.MethodGet,
+			url:                       "/users/user-1/points",
+			user:                      "user-1",
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"userId":"user-1","points":35,"entries":[{"kind":"earn","points":35,"receiptId":"receipt-1"`,
+		},
+		{
+			name:           "Admin",
+			method:         http.MethodGet,
+			url:            "/users/user-1/points",
+			admin:          true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Other User",
+			method:         http.MethodGet,
+			url:            "/users/user-1/points",
+			user:           "user-2",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Anonymous",
+			method:         http.MethodGet,
+			url:            "/users/user-1/points",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodPost,
+			url:            "/users/user-1/points",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid URL",
+			method:         http.MethodGet,
+			url:            "/users/user-1/points/extra",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "User ID Too Long",
+			method:         http.MethodGet,
+			url:            "/users/" + strings.Repeat("u", 65) + "/points",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodGet,
+			url:            "/users/error-user/points",
+			user:           "error-user",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := userRequest(tc.method, tc.url, "", tc.user)
+			if tc.user == "" {
+				req = httptest.NewRequest(tc.method, tc.url, nil)
+			}
+			if tc.admin {
+				req = adminRequest(tc.method, tc.url, "")
+			}
+			w := httptest.NewRecorder()
+			// Call the GetUserPointsHandler directly.
+			router.GetUserPointsHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
+
+func TestRedeemPointsHandler(t *testing.T) {
+	router := &Router{pointsService: &fakeUserPointsService{}}
+
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		idempotencyKey            string
+		body                      string
+		user                      string // Subject of the bearer token; defaults to user-1.
+		admin                     bool   // Whether the request is made with an admin API key instead.
+		expectedStatus            int
+		expectedReplayed          bool
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid Redemption",
+			method:                    http.MethodPost,
+			url:                       "/users/user-1/redemptions",
+			idempotencyKey:            "key-1",
+			body:                      `{"points": 10}`,
+			expectedStatus:            http.StatusCreated,
+			expectedResponseSubstring: `"id":"7","userId":"user-1","points":10,"balance":25`,
+		},
+		{
+			name:             "Replayed Redemption",
+			method:           http.MethodPost,
+			url:              "/users/user-1/redemptions",
+			idempotencyKey:   "replayed-key",
+			body:             `{"points": 10}`,
+			expectedStatus:   http.StatusCreated,
+			expectedReplayed: true,
+		},
+		{
+			name:           "Admin",
+			method:         http.MethodPost,
+			url:            "/users/user-1/redemptions",
+			idempotencyKey: "key-1",
+			body:           `{"points": 10}`,
+			admin:          true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Other User",
+			method:         http.MethodPost,
+			url:            "/users/user-1/redemptions",
+			idempotencyKey: "key-1",
+			body:           `{"points": 10}`,
+			user:           "user-2",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodGet,
+			url:            "/users/user-1/redemptions",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:                      "Missing Idempotency Key",
+			method:                    http.MethodPost,
+			url:                       "/users/user-1/redemptions",
+			body:                      `{"points": 10}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `{"parameter":"Idempotency-Key","detail":"is required"}`,
+		},
+		{
+			name:           "Invalid JSON",
+			method:         http.MethodPost,
+			url:            "/users/user-1/redemptions",
+			idempotencyKey: "key-1",
+			body:           `{"points":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:                      "Points Not Positive",
+			method:                    http.MethodPost,
+			url:                       "/users/user-1/redemptions",
+			idempotencyKey:            "key-1",
+			body:                      `{"points": 0}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"pointer":"/points"`,
+		},
+		{
+			name:           "Insufficient Points",
+			method:         http.MethodPost,
+			url:            "/users/user-1/redemptions",
+			idempotencyKey: "key-1",
+			body:           `{"points": 100}`,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Idempotency Key Reused",
+			method:         http.MethodPost,
+			url:            "/users/user-1/redemptions",
+			idempotencyKey: "reused-key",
+			body:           `{"points": 10}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodPost,
+			url:            "/users/user-1/redemptions",
+			idempotencyKey: "error-key",
+			body:           `{"points": 10}`,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := tc.user
+			if user == "" {
+				user = "user-1"
+			}
+			req := userRequest(tc.method, tc.url, tc.body, user)
+			if tc.admin {
+				req = adminRequest(tc.method, tc.url, tc.body)
+			}
+			if tc.idempotencyKey != "" {
+				req.Header.Set("Idempotency-Key", tc.idempotencyKey)
+			}
+			w := httptest.NewRecorder()
+			// Call the RedeemPointsHandler directly.
+			router.RedeemPointsHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			if replayed := resp.Header.Get("Idempotent-Replayed") == "true"; replayed != tc.expectedReplayed {
+				t.Errorf("expected Idempotent-Replayed %v, got %v", tc.expectedReplayed, replayed)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
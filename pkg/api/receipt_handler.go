@@ -1,13 +1,18 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"regexp"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"receipt_processor/pkg/money"
+	"receipt_processor/pkg/problem"
 	"receipt_processor/pkg/service"
 
 	"github.com/rs/zerolog/log"
@@ -17,8 +22,8 @@ import (
 // It reads and validates the incoming JSON, delegates processing to the service layer,
 // and returns a JSON response with the generated receipt ID.
 func (r *Router) ProcessReceiptHandler(w http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	op, ok := operation(w, req, "/receipts/process")
+	if !ok {
 		return
 	}
 
@@ -26,30 +31,38 @@ func (r *Router) ProcessReceiptHandler(w http.ResponseWriter, req *http.Request)
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to read request body")
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The receipt is invalid."))
 		return
 	}
 
-	// Unmarshal the JSON into a ReceiptDTO.
-	var receipt service.ReceiptDTO
-	if err := json.Unmarshal(body, &receipt); err != nil {
+	// Validate the receipt against the spec and unmarshal it into a ReceiptDTO.
+	receipt, errs, err := decodeReceipt(op.BodySchema("application/json"), body)
+	if err != nil {
 		log.Ctx(req.Context()).Error().Err(err).Msg("Invalid JSON in request body")
-		http.Error(w, "The receipt is invalid.", http.StatusBadRequest)
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The receipt is invalid."))
 		return
 	}
-
-	// Validate the receipt fields using regex rules.
-	if err := validateReceipt(receipt); err != nil {
-		log.Ctx(req.Context()).Error().Err(err).Msg("Validation failed")
-		http.Error(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+	if len(errs) > 0 {
+		log.Ctx(req.Context()).Error().Interface("errors", errs).Msg("Validation failed")
+		problem.Write(w, req, problem.Validation("The receipt is invalid.", errs))
 		return
 	}
 
 	// Delegate to the service layer to process the receipt.
 	id, err := r.receiptService.ProcessReceipt(req.Context(), receipt)
+	var mismatch *service.TotalMismatchError
+	if errors.As(err, &mismatch) {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Receipt rejected by consistency policy")
+		writeMismatchError(w, req, mismatch, "")
+		return
+	}
+	if errors.Is(err, service.ErrUserMismatch) {
+		writeUserMismatchError(w, req, "")
+		return
+	}
 	if err != nil {
 		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to process receipt")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
 		return
 	}
 
@@ -61,26 +74,212 @@ func (r *Router) ProcessReceiptHandler(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+// batchItemResult is the outcome of a single receipt in a batch response.
+type batchItemResult struct {
+	Index int `json:"index"`
+	service.BatchResult
+	// Errors lists the invalid fields of the receipt. Pointers are relative to the receipt.
+	Errors []problem.FieldError `json:"errors,omitempty"`
+}
+
+// ProcessBatchHandler handles POST /receipts/batch.
+// The body is either a JSON array of receipts or a stream of newline-delimited JSON
+// receipts. Each receipt is validated against the Receipt schema. With ?mode=transaction,
+// the batch is rejected if any receipt is invalid and the rest are saved atomically;
+// otherwise (the default, ?mode=per_item) each receipt succeeds or fails on its own.
+func (r *Router) ProcessBatchHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/receipts/batch")
+	if !ok {
+		return
+	}
+	if !validateParameters(w, req, op, nil) {
+		return
+	}
+	atomic := req.URL.Query().Get("mode") == "transaction"
+
+	// Decode the receipts from the request body. The batch size limit comes from the spec.
+	maxBatchSize := 0
+	if schema := op.BodySchema("application/json"); schema != nil && schema.MaxItems != nil {
+		maxBatchSize = *schema.MaxItems
+	}
+	raw, mediaType, err := decodeBatch(req.Body, maxBatchSize)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Invalid batch request body")
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The batch is invalid: "+err.Error()))
+		return
+	}
+	schema := op.BodySchema(mediaType)
+	if mediaType == "application/json" {
+		schema = schema.Items
+	}
+
+	// Validate every receipt, keeping track of which ones are valid.
+	results := make([]batchItemResult, len(raw))
+	var valid []service.ReceiptDTO
+	var validIndexes []int
+	for i, data := range raw {
+		results[i].Index = i
+		receipt, errs, err := decodeReceipt(schema, data)
+		if err != nil {
+			errs = []problem.FieldError{{Detail: err.Error()}}
+		}
+		if len(errs) > 0 {
+			results[i].Error = "The receipt is invalid."
+			results[i].Errors = errs
+			continue
+		}
+		valid = append(valid, receipt)
+		validIndexes = append(validIndexes, i)
+	}
+
+	status := http.StatusOK
+	if atomic && len(valid) != len(raw) {
+		// Reject the whole batch without processing anything.
+		status = http.StatusBadRequest
+	} else {
+		// Delegate to the service layer to process the valid receipts.
+		processed, err := r.receiptService.ProcessBatch(req.Context(), valid, atomic)
+		var mismatch *service.TotalMismatchError
+		if errors.As(err, &mismatch) {
+			log.Ctx(req.Context()).Error().Err(err).Msg("Batch rejected by consistency policy")
+			// Point at the total of the receipt that failed, by its index in the request.
+			prefix := ""
+			var itemErr *service.BatchItemError
+			if errors.As(err, &itemErr) {
+				prefix = "/" + strconv.Itoa(validIndexes[itemErr.Index])
+			}
+			writeMismatchError(w, req, mismatch, prefix)
+			return
+		}
+		var itemErr *service.BatchItemError
+		if errors.As(err, &itemErr) && errors.Is(err, service.ErrUserMismatch) {
+			writeUserMismatchError(w, req, "/"+strconv.Itoa(validIndexes[itemErr.Index]))
+			return
+		}
+		if err != nil {
+			log.Ctx(req.Context()).Error().Err(err).Msg("Failed to process batch")
+			problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+			return
+		}
+		for i, result := range processed {
+			results[validIndexes[i]].BatchResult = result
+		}
+	}
+
+	response := map[string][]batchItemResult{"results": results}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// writeMismatchError writes a 400 problem describing a receipt whose item prices
+// do not add up to its total, including the amounts involved. The pointer to the total
+// is prefixed with prefix, e.g. "/2" for the third receipt of a batch.
+func writeMismatchError(w http.ResponseWriter, req *http.Request, mismatch *service.TotalMismatchError, prefix string) {
+	problem.Write(w, req, problem.Problem{
+		Type:   problem.TypeTotalMismatch,
+		Title:  "Total does not match items",
+		Status: http.StatusBadRequest,
+		Detail: "The receipt is invalid.",
+		Errors: []problem.FieldError{{Pointer: prefix + "/total", Detail: mismatch.Error()}},
+		Extensions: map[string]interface{}{
+			"total":      mismatch.Total,
+			"itemsTotal": mismatch.ItemsTotal,
+			"difference": mismatch.Difference,
+			"tolerance":  mismatch.Tolerance,
+		},
+	})
+}
+
+// writeUserMismatchError writes a 403 problem for a receipt submitted for a user the client
+// may not act for. The pointer to the user is prefixed with prefix, as in writeMismatchError.
+func writeUserMismatchError(w http.ResponseWriter, req *http.Request, prefix string) {
+	p := problem.New(http.StatusForbidden, "Receipts can only be submitted for the authenticated user.")
+	p.Errors = []problem.FieldError{{Pointer: prefix + "/userId", Detail: service.ErrUserMismatch.Error()}}
+	problem.Write(w, req, p)
+}
+
+// decodeBatch reads receipts from either a JSON array or newline-delimited JSON, returning
+// each receipt undecoded along with the media type of the body. If maxReceipts is positive,
+// larger batches are rejected.
+func decodeBatch(body io.Reader, maxReceipts int) ([]json.RawMessage, string, error) {
+	reader := bufio.NewReader(body)
+
+	// Peek at the first non-whitespace byte to detect a JSON array.
+	var isArray bool
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return nil, "", fmt.Errorf("no receipts provided")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			reader.ReadByte()
+			continue
+		}
+		isArray = b[0] == '['
+		break
+	}
+	mediaType := "application/x-ndjson"
+	if isArray {
+		mediaType = "application/json"
+	}
+
+	decoder := json.NewDecoder(reader)
+	if isArray {
+		// Consume the opening bracket so elements can be decoded one at a time.
+		if _, err := decoder.Token(); err != nil {
+			return nil, "", err
+		}
+	}
+	var receipts []json.RawMessage
+	for {
+		if isArray && !decoder.More() {
+			// Consume the closing bracket.
+			if _, err := decoder.Token(); err != nil {
+				return nil, "", err
+			}
+			break
+		}
+		var receipt json.RawMessage
+		err := decoder.Decode(&receipt)
+		if err == io.EOF && !isArray {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("receipt %d: %v", len(receipts), err)
+		}
+		receipts = append(receipts, receipt)
+		if maxReceipts > 0 && len(receipts) > maxReceipts {
+			return nil, "", fmt.Errorf("at most %d receipts are allowed per batch", maxReceipts)
+		}
+	}
+	if len(receipts) == 0 {
+		return nil, "", fmt.Errorf("no receipts provided")
+	}
+	return receipts, mediaType, nil
+}
+
 // GetPointsHandler handles GET /receipts/{id}/points.
-// It extracts the receipt ID from the URL, validates it, and returns the points awarded.
+// It extracts the receipt ID from the URL, validates it, and returns the points awarded,
+// net of any voids and refunds, which are listed.
 func (r *Router) GetPointsHandler(w http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	op, ok := operation(w, req, "/receipts/{id}/points")
+	if !ok {
 		return
 	}
 
 	// Expecting URL format: /receipts/{id}/points.
-	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
-	if len(parts) != 3 || parts[0] != "receipts" || parts[2] != "points" {
-		http.NotFound(w, req)
+	receiptID, ok := receiptIDFromPath(req.URL.Path, "points")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
 		return
 	}
-	receiptID := parts[1]
-
-	// Validate receiptID using the regex pattern: "^\S+$".
-	idRegex := regexp.MustCompile(`^\S+$`)
-	if !idRegex.MatchString(receiptID) {
-		http.Error(w, "No receipt found for that ID.", http.StatusBadRequest)
+	if !validateParameters(w, req, op, map[string]string{"id": receiptID}) {
 		return
 	}
 
@@ -88,54 +287,178 @@ func (r *Router) GetPointsHandler(w http.ResponseWriter, req *http.Request) {
 	points, err := r.receiptService.GetPoints(req.Context(), receiptID)
 	if err != nil {
 		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to get points")
-		http.Error(w, "No receipt found for that ID.", http.StatusNotFound)
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No receipt found for that ID."))
 		return
 	}
 
 	// Return the points in JSON format.
-	response := map[string]int{"points": points}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := json.NewEncoder(w).Encode(points); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// GetReceiptHandler handles GET /receipts/{id}.
+// It returns the stored receipt, with its items and awarded points, as JSON.
+func (r *Router) GetReceiptHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/receipts/{id}")
+	if !ok {
+		return
+	}
+
+	// Expecting URL format: /receipts/{id}.
+	receiptID, ok := receiptIDFromPath(req.URL.Path, "")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	if !validateParameters(w, req, op, map[string]string{"id": receiptID}) {
+		return
+	}
+
+	// Retrieve the receipt via the service layer.
+	receipt, err := r.receiptService.GetReceipt(req.Context(), receiptID)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to get receipt")
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No receipt found for that ID."))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(receipt); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// ListReceiptsHandler handles GET /receipts.
+// It returns a page of stored receipts filtered by the query parameters retailer, merchantId,
+// purchaseDateFrom, purchaseDateTo, minTotal, maxTotal, minPoints and flagged. Pagination
+// uses the limit parameter and the cursor returned as nextCursor by the previous page.
+func (r *Router) ListReceiptsHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/receipts")
+	if !ok {
+		return
+	}
+	if !validateParameters(w, req, op, nil) {
+		return
+	}
+
+	query, err := parseReceiptQuery(req.URL.Query())
+	if err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "Invalid query: "+err.Error()))
+		return
+	}
+
+	// Retrieve the page via the service layer.
+	page, err := r.receiptService.ListReceipts(req.Context(), query)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "Invalid query: invalid cursor"))
+		return
+	}
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to list receipts")
+		problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
 	}
 }
 
-// validateReceipt checks the receipt fields against the regex patterns from the OpenAPI spec.
-func validateReceipt(receipt service.ReceiptDTO) error {
-	// Validate "retailer": pattern "^[\w\s\-\&]+$".
-	retailerRegex := regexp.MustCompile(`^[\w\s\-\&]+$`)
-	if !retailerRegex.MatchString(receipt.Retailer) {
-		return fmt.Errorf("invalid retailer format")
+// parseReceiptQuery converts the listing query parameters, which have been validated
+// against the spec, into a service.ReceiptQuery.
+func parseReceiptQuery(values url.Values) (service.ReceiptQuery, error) {
+	query := service.ReceiptQuery{
+		Retailer:         values.Get("retailer"),
+		MerchantID:       values.Get("merchantId"),
+		PurchaseDateFrom: values.Get("purchaseDateFrom"),
+		PurchaseDateTo:   values.Get("purchaseDateTo"),
+		Cursor:           values.Get("cursor"),
 	}
-	// Validate "purchaseDate": basic pattern for YYYY-MM-DD.
-	dateRegex := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
-	if !dateRegex.MatchString(receipt.PurchaseDate) {
-		return fmt.Errorf("invalid purchaseDate format")
+	if v := values.Get("minTotal"); v != "" {
+		minTotal, err := money.Parse(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid minTotal: %v", err)
+		}
+		query.MinTotal = &minTotal
 	}
-	// Validate "purchaseTime": expecting HH:MM in 24-hour format.
-	timeRegex := regexp.MustCompile(`^\d{2}:\d{2}$`)
-	if !timeRegex.MatchString(receipt.PurchaseTime) {
-		return fmt.Errorf("invalid purchaseTime format")
+	if v := values.Get("maxTotal"); v != "" {
+		maxTotal, err := money.Parse(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid maxTotal: %v", err)
+		}
+		query.MaxTotal = &maxTotal
 	}
-	// Validate "total": pattern "^\d+\.\d{2}$".
-	totalRegex := regexp.MustCompile(`^\d+\.\d{2}$`)
-	if !totalRegex.MatchString(receipt.Total) {
-		return fmt.Errorf("invalid total format")
+	if v := values.Get("minPoints"); v != "" {
+		minPoints, err := strconv.Atoi(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid minPoints: %v", err)
+		}
+		query.MinPoints = &minPoints
 	}
-	// Ensure there is at least one item.
-	if len(receipt.Items) == 0 {
-		return fmt.Errorf("at least one item is required")
+	if v := values.Get("flagged"); v != "" {
+		flagged, err := strconv.ParseBool(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid flagged: %v", err)
+		}
+		query.FlaggedOnly = flagged
 	}
-	// Validate each item.
-	itemDescRegex := regexp.MustCompile(`^[\w\s\-]+$`)
-	priceRegex := regexp.MustCompile(`^\d+\.\d{2}$`)
-	for _, item := range receipt.Items {
-		if !itemDescRegex.MatchString(item.ShortDescription) {
-			return fmt.Errorf("invalid item shortDescription format")
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return query, fmt.Errorf("invalid limit: %v", err)
 		}
-		if !priceRegex.MatchString(item.Price) {
-			return fmt.Errorf("invalid item price format")
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// GetBreakdownHandler handles GET /receipts/{id}/breakdown.
+// It returns the per-rule points breakdown recorded when the receipt was scored.
+func (r *Router) GetBreakdownHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/receipts/{id}/breakdown")
+	if !ok {
+		return
+	}
+
+	// Expecting URL format: /receipts/{id}/breakdown.
+	receiptID, ok := receiptIDFromPath(req.URL.Path, "breakdown")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	if !validateParameters(w, req, op, map[string]string{"id": receiptID}) {
+		return
+	}
+
+	// Retrieve the breakdown via the service layer.
+	breakdown, err := r.receiptService.GetBreakdown(req.Context(), receiptID)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to get points breakdown")
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No receipt found for that ID."))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(breakdown); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
+// receiptIDFromPath extracts the receipt ID from a path of the form /receipts/{id}/{suffix},
+// or /receipts/{id} when suffix is empty.
+func receiptIDFromPath(path, suffix string) (string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if suffix == "" {
+		if len(parts) != 2 || parts[0] != "receipts" {
+			return "", false
 		}
+		return parts[1], true
+	}
+	if len(parts) != 3 || parts[0] != "receipts" || parts[2] != suffix {
+		return "", false
 	}
-	return nil
+	return parts[1], true
 }
@@ -3,7 +3,9 @@ package api
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -16,20 +18,86 @@ import (
 // fakeReceiptService is a fake implementation of service.IReceiptService for testing.
 type fakeReceiptService struct{}
 
-// ProcessReceipt returns "test-id" unless the retailer is "error", in which case it returns an error.
+// ProcessReceipt returns "test-id" unless the retailer is "error" or "mismatch", or the user
+// is "other-user", in which case it returns an error.
 func (f *fakeReceiptService) ProcessReceipt(ctx context.Context, receipt service.ReceiptDTO) (string, error) {
 	if receipt.Retailer == "error" {
 		return "", errors.New("processing error")
 	}
+	if receipt.UserID == "other-user" {
+		return "", service.ErrUserMismatch
+	}
+	if receipt.Retailer == "mismatch" {
+		return "", &service.TotalMismatchError{Total: 3535, ItemsTotal: 1000, Difference: 2535}
+	}
 	return "test-id", nil
 }
 
+// ProcessBatch returns an ID per receipt, marking receipts from retailer "dup" as duplicates.
+// It returns an error if any retailer is "error" or "mismatch", or any user is "other-user".
+func (f *fakeReceiptService) ProcessBatch(ctx context.Context, receipts []service.ReceiptDTO, atomic bool) ([]service.BatchResult, error) {
+	results := make([]service.BatchResult, len(receipts))
+	for i, receipt := range receipts {
+		if receipt.Retailer == "error" {
+			return nil, errors.New("processing error")
+		}
+		if receipt.Retailer == "mismatch" {
+			return nil, &service.BatchItemError{Index: i, Err: &service.TotalMismatchError{Total: 3535, ItemsTotal: 1000, Difference: 2535}}
+		}
+		if receipt.UserID == "other-user" {
+			return nil, &service.BatchItemError{Index: i, Err: service.ErrUserMismatch}
+		}
+		results[i] = service.BatchResult{ID: fmt.Sprintf("id-%d", i), Duplicate: receipt.Retailer == "dup"}
+	}
+	return results, nil
+}
+
 // GetPoints returns 42 points unless the receiptID is "error-id", in which case it returns an error.
-func (f *fakeReceiptService) GetPoints(ctx context.Context, receiptID string) (int, error) {
+func (f *fakeReceiptService) GetPoints(ctx context.Context, receiptID string) (service.ReceiptPointsDTO, error) {
+	if receiptID == "error-id" {
+		return service.ReceiptPointsDTO{}, errors.New("receipt not found")
+	}
+	return service.ReceiptPointsDTO{Points: 42, AwardedPoints: 42}, nil
+}
+
+// GetReceipt returns a stored receipt unless the receiptID is "error-id", in which case it returns an error.
+func (f *fakeReceiptService) GetReceipt(ctx context.Context, receiptID string) (service.StoredReceiptDTO, error) {
 	if receiptID == "error-id" {
-		return 0, errors.New("receipt not found")
+		return service.StoredReceiptDTO{}, errors.New("receipt not found")
 	}
-	return 42, nil
+	return service.StoredReceiptDTO{
+		ID:     receiptID,
+		Points: 42,
+		ReceiptDTO: service.ReceiptDTO{
+			Retailer:     "Target",
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "10.00",
+			Items:        []service.ItemDTO{{ShortDescription: "Item A", Price: "10.00"}},
+		},
+	}, nil
+}
+
+// ListReceipts returns a single receipt and a next cursor unless the cursor is "bad", in which case it returns ErrInvalidCursor.
+func (f *fakeReceiptService) ListReceipts(ctx context.Context, query service.ReceiptQuery) (service.ReceiptPage, error) {
+	if query.Cursor == "bad" {
+		return service.ReceiptPage{}, service.ErrInvalidCursor
+	}
+	return service.ReceiptPage{
+		Receipts:   []service.StoredReceiptDTO{{ID: "test-id", Points: 42}},
+		NextCursor: "next",
+	}, nil
+}
+
+// GetBreakdown returns a single-rule breakdown unless the receiptID is "error-id", in which case it returns an error.
+func (f *fakeReceiptService) GetBreakdown(ctx context.Context, receiptID string) (service.PointsBreakdown, error) {
+	if receiptID == "error-id" {
+		return service.PointsBreakdown{}, errors.New("receipt not found")
+	}
+	return service.PointsBreakdown{
+		Points: 42,
+		Rules:  []service.RuleContribution{{Rule: "round_total", Points: 42}},
+	}, nil
 }
 
 func TestProcessReceiptHandler(t *testing.T) {
@@ -55,6 +123,14 @@ func TestProcessReceiptHandler(t *testing.T) {
 			// We expect our fake service to return "test-id".
 			expectedResponseSubstring: `"id":"test-id"`,
 		},
+		{
+			name:                      "Store Number And Accents",
+			method:                    http.MethodPost,
+			url:                       "/receipts/process",
+			body:                      `{"retailer": "Café Ole Store #123", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`,
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"id":"test-id"`,
+		},
 		{
 			name:           "Invalid Method",
 			method:         http.MethodGet,
@@ -62,6 +138,22 @@ func TestProcessReceiptHandler(t *testing.T) {
 			body:           ``,
 			expectedStatus: http.StatusMethodNotAllowed,
 		},
+		{
+			name:                      "Every Violation Reported",
+			method:                    http.MethodPost,
+			url:                       "/receipts/process",
+			body:                      `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "1pm", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}, {"shortDescription": "Item B", "price": "ten"}]}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"errors":[{"pointer":"/purchaseTime","detail":"must match the pattern ^([01]\\d|2[0-3]):[0-5]\\d$"},{"pointer":"/items/1/price","detail":"must match the pattern ^\\d+\\.\\d{2}$"}]`,
+		},
+		{
+			name:                      "Wrong Type And Missing Field",
+			method:                    http.MethodPost,
+			url:                       "/receipts/process",
+			body:                      `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": 35.35}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"errors":[{"pointer":"/total","detail":"must be a string"},{"pointer":"/items","detail":"is required"}]`,
+		},
 		{
 			name:           "Invalid JSON",
 			method:         http.MethodPost,
@@ -69,6 +161,22 @@ func TestProcessReceiptHandler(t *testing.T) {
 			body:           `invalid-json`,
 			expectedStatus: http.StatusBadRequest,
 		},
+		{
+			name:                      "Total Mismatch",
+			method:                    http.MethodPost,
+			url:                       "/receipts/process",
+			body:                      `{"retailer": "mismatch", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`,
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"difference":"25.35","errors":[{"pointer":"/total"`,
+		},
+		{
+			name:                      "Other User",
+			method:                    http.MethodPost,
+			url:                       "/receipts/process",
+			body:                      `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}], "userId": "other-user"}`,
+			expectedStatus:            http.StatusForbidden,
+			expectedResponseSubstring: `"errors":[{"pointer":"/userId"`,
+		},
 		{
 			name:           "Service Error",
 			method:         http.MethodPost,
@@ -98,6 +206,196 @@ func TestProcessReceiptHandler(t *testing.T) {
 	}
 }
 
+func TestDecodeReceiptPurchaseTime(t *testing.T) {
+	schema := spec.Operation(http.MethodPost, "/receipts/process").BodySchema("application/json")
+
+	testCases := []struct {
+		name            string
+		date, time, tz  string
+		expectedPointer string // Empty if the receipt is valid.
+	}{
+		{name: "Valid", date: "2022-01-01", time: "13:01"},
+		{name: "Leap Day", date: "2024-02-29", time: "00:00"},
+		{name: "With Timezone", date: "2022-01-01", time: "13:01", tz: "America/Chicago"},
+		{name: "With Offset", date: "2022-01-01", time: "13:01", tz: "+05:30"},
+		{name: "Invalid Month", date: "2022-13-45", time: "13:01", expectedPointer: "/purchaseDate"},
+		{name: "Not A Leap Year", date: "2023-02-29", time: "13:01", expectedPointer: "/purchaseDate"},
+		{name: "Invalid Time", date: "2022-01-01", time: "99:99", expectedPointer: "/purchaseTime"},
+		{name: "Invalid Minute", date: "2022-01-01", time: "23:60", expectedPointer: "/purchaseTime"},
+		{name: "Unknown Timezone", date: "2022-01-01", time: "13:01", tz: "Mars/Olympus", expectedPointer: "/timezone"},
+		{name: "Invalid Offset", date: "2022-01-01", time: "13:01", tz: "+25:00", expectedPointer: "/timezone"},
+		{name: "Far Future", date: "2999-01-01", time: "13:01", expectedPointer: "/purchaseDate"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, _ := json.Marshal(service.ReceiptDTO{
+				Retailer:     "Target",
+				PurchaseDate: tc.date,
+				PurchaseTime: tc.time,
+				Timezone:     tc.tz,
+				Total:        "10.00",
+				Items:        []service.ItemDTO{{ShortDescription: "Item A", Price: "10.00"}},
+			})
+			_, errs, err := decodeReceipt(schema, data)
+			if err != nil {
+				t.Fatalf("failed to decode receipt: %v", err)
+			}
+			if tc.expectedPointer == "" {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Pointer != tc.expectedPointer {
+				t.Errorf("expected a single error at %s, got %v", tc.expectedPointer, errs)
+			}
+		})
+	}
+}
+
+func TestProcessBatchHandler(t *testing.T) {
+	// Create a fake service and a Router that uses it.
+	fakeService := &fakeReceiptService{}
+	router := &Router{receiptService: fakeService}
+
+	valid := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`
+	duplicate := `{"retailer": "dup", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`
+	invalid := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`
+	mismatched := `{"retailer": "mismatch", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`
+	otherUser := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}], "userId": "other-user"}`
+	failing := `{"retailer": "error", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "35.35", "items": [{"shortDescription": "Item A", "price": "10.00"}]}`
+
+	// Define table test cases for the ProcessBatchHandler.
+	testCases := []struct {
+		name                       string
+		method                     string
+		url                        string
+		body                       string
+		expectedStatus             int
+		expectedResponseSubstrings []string
+	}{
+		{
+			name:           "JSON Array",
+			method:         http.MethodPost,
+			url:            "/receipts/batch",
+			body:           "[" + valid + "," + duplicate + "]",
+			expectedStatus: http.StatusOK,
+			expectedResponseSubstrings: []string{
+				`{"index":0,"id":"id-0"}`,
+				`{"index":1,"id":"id-1","duplicate":true}`,
+			},
+		},
+		{
+			name:           "NDJSON Stream",
+			method:         http.MethodPost,
+			url:            "/receipts/batch",
+			body:           valid + "\n" + valid + "\n",
+			expectedStatus: http.StatusOK,
+			expectedResponseSubstrings: []string{
+				`{"index":0,"id":"id-0"}`,
+				`{"index":1,"id":"id-1"}`,
+			},
+		},
+		{
+			name:           "Per Item Validation Error",
+			method:         http.MethodPost,
+			url:            "/receipts/batch",
+			body:           "[" + invalid + "," + valid + "]",
+			expectedStatus: http.StatusOK,
+			expectedResponseSubstrings: []string{
+				`{"index":0,"error":"The receipt is invalid.","errors":[{"pointer":"/total","detail":"must match the pattern ^\\d+\\.\\d{2}$"}]}`,
+				`{"index":1,"id":"id-0"}`,
+			},
+		},
+		{
+			name:           "Transaction Validation Error",
+			method:         http.MethodPost,
+			url:            "/receipts/batch?mode=transaction",
+			body:           "[" + invalid + "," + valid + "]",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseSubstrings: []string{
+				`{"index":0,"error":"The receipt is invalid.","errors":[{"pointer":"/total","detail":"must match the pattern ^\\d+\\.\\d{2}$"}]}`,
+				`{"index":1}`,
+			},
+		},
+		{
+			name:           "Transaction Total Mismatch",
+			method:         http.MethodPost,
+			url:            "/receipts/batch?mode=transaction",
+			body:           "[" + valid + "," + mismatched + "]",
+			expectedStatus: http.StatusBadRequest,
+			expectedResponseSubstrings: []string{
+				`"difference":"25.35","errors":[{"pointer":"/1/total"`,
+			},
+		},
+		{
+			name:           "Transaction Other User",
+			method:         http.MethodPost,
+			url:            "/receipts/batch?mode=transaction",
+			body:           "[" + valid + "," + otherUser + "]",
+			expectedStatus: http.StatusForbidden,
+			expectedResponseSubstrings: []string{
+				`"errors":[{"pointer":"/1/userId"`,
+			},
+		},
+		{
+			name:           "Invalid Mode",
+			method:         http.MethodPost,
+			url:            "/receipts/batch?mode=sometimes",
+			body:           "[" + valid + "]",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid JSON",
+			method:         http.MethodPost,
+			url:            "/receipts/batch",
+			body:           "[" + valid + ",",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Empty Batch",
+			method:         http.MethodPost,
+			url:            "/receipts/batch",
+			body:           "[]",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodGet,
+			url:            "/receipts/batch",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodPost,
+			url:            "/receipts/batch",
+			body:           "[" + failing + "]",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+			w := httptest.NewRecorder()
+			// Call the ProcessBatchHandler directly.
+			router.ProcessBatchHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			for _, substring := range tc.expectedResponseSubstrings {
+				if !strings.Contains(bodyStr, substring) {
+					t.Errorf("expected response to contain %q, got %q", substring, bodyStr)
+				}
+			}
+		})
+	}
+}
+
 func TestGetPointsHandler(t *testing.T) {
 	// Create a fake service and a Router that uses it.
 	fakeService := &fakeReceiptService{}
@@ -135,7 +433,7 @@ func TestGetPointsHandler(t *testing.T) {
 			method:                    http.MethodGet,
 			url:                       "/receipts/error-id/points",
 			expectedStatus:            http.StatusNotFound,
-			expectedResponseSubstring: "", // We don't expect a valid JSON body in this error case.
+			expectedResponseSubstring: `"status":404,"detail":"No receipt found for that ID.","instance":"/receipts/error-id/points"`,
 		},
 	}
 
@@ -157,3 +455,206 @@ func TestGetPointsHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestGetReceiptHandler(t *testing.T) {
+	// Create a fake service and a Router that uses it.
+	fakeService := &fakeReceiptService{}
+	router := &Router{receiptService: fakeService}
+
+	// Define table test cases for the GetReceiptHandler.
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid Get",
+			method:                    http.MethodGet,
+			url:                       "/receipts/test-id",
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"id":"test-id","points":42,"retailer":"Target"`,
+		},
+		{
+			name:                      "Items Included",
+			method:                    http.MethodGet,
+			url:                       "/receipts/test-id",
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"items":[{"shortDescription":"Item A","price":"10.00"}]`,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid URL",
+			method:         http.MethodGet,
+			url:            "/receipts/test-id/extra",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodGet,
+			url:            "/receipts/error-id",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			w := httptest.NewRecorder()
+			// Call the GetReceiptHandler directly.
+			router.GetReceiptHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
+
+func TestListReceiptsHandler(t *testing.T) {
+	// Create a fake service and a Router that uses it.
+	fakeService := &fakeReceiptService{}
+	router := &Router{receiptService: fakeService}
+
+	// Define table test cases for the ListReceiptsHandler.
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid List",
+			method:                    http.MethodGet,
+			url:                       "/receipts?retailer=Target&merchantId=merchant-1&purchaseDateFrom=2022-01-01&purchaseDateTo=2022-12-31&minTotal=1.00&maxTotal=99.99&minPoints=10&flagged=true&limit=5",
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"nextCursor":"next"`,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodPost,
+			url:            "/receipts",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:                      "Invalid Date",
+			method:                    http.MethodGet,
+			url:                       "/receipts?purchaseDateFrom=yesterday",
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"errors":[{"parameter":"purchaseDateFrom","detail":"must be a valid date in YYYY-MM-DD format"}]`,
+		},
+		{
+			name:           "Invalid Total",
+			method:         http.MethodGet,
+			url:            "/receipts?minTotal=10",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Flagged",
+			method:         http.MethodGet,
+			url:            "/receipts?flagged=maybe",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Limit",
+			method:         http.MethodGet,
+			url:            "/receipts?limit=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Cursor",
+			method:         http.MethodGet,
+			url:            "/receipts?cursor=bad",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			w := httptest.NewRecorder()
+			// Call the ListReceiptsHandler directly.
+			router.ListReceiptsHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
+
+func TestGetBreakdownHandler(t *testing.T) {
+	// Create a fake service and a Router that uses it.
+	fakeService := &fakeReceiptService{}
+	router := &Router{receiptService: fakeService}
+
+	// Define table test cases for the GetBreakdownHandler.
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid Get",
+			method:                    http.MethodGet,
+			url:                       "/receipts/test-id/breakdown",
+			expectedStatus:            http.StatusOK,
+			expectedResponseSubstring: `"rules":[{"rule":"round_total","points":42}]`,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id/breakdown",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid URL",
+			method:         http.MethodGet,
+			url:            "/receipts/test-id/points",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodGet,
+			url:            "/receipts/error-id/breakdown",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			w := httptest.NewRecorder()
+			// Call the GetBreakdownHandler directly.
+			router.GetBreakdownHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"receipt_processor/pkg/problem"
+	"receipt_processor/pkg/service"
+
+	"github.com/rs/zerolog/log"
+)
+
+// VoidReceiptHandler handles POST /receipts/{id}/void.
+// It voids the receipt, clawing back its remaining points, and returns the reversal as JSON.
+func (r *Router) VoidReceiptHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/receipts/{id}/void")
+	if !ok {
+		return
+	}
+
+	// Expecting URL format: /receipts/{id}/void.
+	receiptID, ok := receiptIDFromPath(req.URL.Path, "void")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	if !validateParameters(w, req, op, map[string]string{"id": receiptID}) {
+		return
+	}
+
+	// Void the receipt via the service layer.
+	reversal, err := r.receiptService.VoidReceipt(req.Context(), receiptID)
+	writeReversal(w, req, reversal, err)
+}
+
+// refundRequest is the body of a refund.
+type refundRequest struct {
+	Items []int `json:"items"`
+}
+
+// RefundItemsHandler handles POST /receipts/{id}/refunds.
+// It refunds the items of the receipt whose indexes are in the JSON body, clawing back the
+// points the receipt no longer earns without them, and returns the reversal as JSON.
+func (r *Router) RefundItemsHandler(w http.ResponseWriter, req *http.Request) {
+	op, ok := operation(w, req, "/receipts/{id}/refunds")
+	if !ok {
+		return
+	}
+
+	// Expecting URL format: /receipts/{id}/refunds.
+	receiptID, ok := receiptIDFromPath(req.URL.Path, "refunds")
+	if !ok {
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No route matches the request path."))
+		return
+	}
+	if !validateParameters(w, req, op, map[string]string{"id": receiptID}) {
+		return
+	}
+
+	// Validate the body against the spec and unmarshal it.
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to read request body")
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The refund is invalid."))
+		return
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The refund is invalid."))
+		return
+	}
+	if errs := op.BodySchema("application/json").Validate(value, ""); len(errs) > 0 {
+		problem.Write(w, req, problem.Validation("The refund is invalid.", errs))
+		return
+	}
+	var refund refundRequest
+	if err := json.Unmarshal(body, &refund); err != nil {
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The refund is invalid."))
+		return
+	}
+
+	// Refund the items via the service layer.
+	reversal, err := r.receiptService.RefundItems(req.Context(), receiptID, refund.Items)
+	writeReversal(w, req, reversal, err)
+}
+
+// writeReversal writes the outcome of a void or refund.
+func writeReversal(w http.ResponseWriter, req *http.Request, reversal service.ReversalDTO, err error) {
+	switch {
+	case errors.Is(err, service.ErrReceiptNotFound):
+		problem.Write(w, req, problem.New(http.StatusNotFound, "No receipt found for that ID."))
+		return
+	case errors.Is(err, service.ErrNotReceiptUser):
+		problem.Write(w, req, problem.New(http.StatusForbidden, "The client may not act for the receipt's user."))
+		return
+	case errors.Is(err, service.ErrAlreadyReversed):
+		problem.Write(w, req, problem.New(http.StatusConflict, "The receipt or some of its items were already reversed."))
+		return
+	case errors.Is(err, service.ErrReversalConflict):
+		problem.Write(w, req, problem.New(http.StatusConflict, "The receipt is being reversed by other requests; try again."))
+		return
+	case errors.Is(err, service.ErrInvalidItem):
+		problem.Write(w, req, problem.New(http.StatusBadRequest, "The refund is invalid: "+err.Error()+"."))
+		return
+	case err != nil:
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to reverse receipt")
+		problem.Write(w, req, problem.New(http.StatusInternalServerError, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(reversal); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
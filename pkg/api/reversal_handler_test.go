@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/service"
+)
+
+// VoidReceipt returns a void of the receipt's single item. Every receipt belongs to user-1.
+// It returns ErrReceiptNotFound for "missing-id", ErrAlreadyReversed for "voided-id",
+// ErrReversalConflict for "conflict-id" and another error for "error-id".
+func (f *fakeReceiptService) VoidReceipt(ctx context.Context, receiptID string) (service.ReversalDTO, error) {
+	if err := reversalError(ctx, receiptID); err != nil {
+		return service.ReversalDTO{}, err
+	}
+	return service.ReversalDTO{ID: "reversal-1", Kind: "void", Items: []int{0}, Points: 42}, nil
+}
+
+// RefundItems returns a refund of the items, or ErrInvalidItem for an index above 2. It
+// returns the same errors as VoidReceipt for the same receipt IDs.
+func (f *fakeReceiptService) RefundItems(ctx context.Context, receiptID string, items []int) (service.ReversalDTO, error) {
+	if err := reversalError(ctx, receiptID); err != nil {
+		return service.ReversalDTO{}, err
+	}
+	for _, item := range items {
+		if item > 2 {
+			return service.ReversalDTO{}, service.ErrInvalidItem
+		}
+	}
+	return service.ReversalDTO{ID: "reversal-1", Kind: "refund", Items: items, Points: 6}, nil
+}
+
+// reversalError returns the error the fake service returns for voiding or refunding the receipt,
+// or ErrNotReceiptUser if the client may not act for user-1.
+func reversalError(ctx context.Context, receiptID string) error {
+	if !auth.CanActFor(ctx, "user-1") {
+		return service.ErrNotReceiptUser
+	}
+	switch receiptID {
+	case "missing-id":
+		return service.ErrReceiptNotFound
+	case "voided-id":
+		return service.ErrAlreadyReversed
+	case "conflict-id":
+		return service.ErrReversalConflict
+	case "error-id":
+		return errors.New("database error")
+	}
+	return nil
+}
+
+func TestVoidReceiptHandler(t *testing.T) {
+	router := &Router{receiptService: &fakeReceiptService{}}
+
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		user                      string // Subject of the bearer token, if any.
+		admin                     bool   // Whether the request is made with an admin API key instead.
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid Void",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/void",
+			user:                      "user-1",
+			expectedStatus:            http.StatusCreated,
+			expectedResponseSubstring: `"id":"reversal-1","kind":"void","items":[0],"points":42`,
+		},
+		{
+			name:           "Admin",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id/void",
+			admin:          true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:                      "Other User",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/void",
+			user:                      "user-2",
+			expectedStatus:            http.StatusForbidden,
+			expectedResponseSubstring: `"detail":"The client may not act for the receipt's user."`,
+		},
+		{
+			name:           "Anonymous",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id/void",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodGet,
+			url:            "/receipts/test-id/void",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid URL",
+			method:         http.MethodPost,
+			url:            "/invalid/test-id/void",
+			user:           "user-1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:                      "Receipt Not Found",
+			method:                    http.MethodPost,
+			url:                       "/receipts/missing-id/void",
+			user:                      "user-1",
+			expectedStatus:            http.StatusNotFound,
+			expectedResponseSubstring: `"detail":"No receipt found for that ID."`,
+		},
+		{
+			name:           "Already Voided",
+			method:         http.MethodPost,
+			url:            "/receipts/voided-id/void",
+			user:           "user-1",
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:                      "Concurrent Reversals",
+			method:                    http.MethodPost,
+			url:                       "/receipts/conflict-id/void",
+			user:                      "user-1",
+			expectedStatus:            http.StatusConflict,
+			expectedResponseSubstring: `"detail":"The receipt is being reversed by other requests; try again."`,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodPost,
+			url:            "/receipts/error-id/void",
+			user:           "user-1",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := userRequest(tc.method, tc.url, "", tc.user)
+			if tc.user == "" {
+				req = httptest.NewRequest(tc.method, tc.url, nil)
+			}
+			if tc.admin {
+				req = adminRequest(tc.method, tc.url, "")
+			}
+			w := httptest.NewRecorder()
+			// Call the VoidReceiptHandler directly.
+			router.VoidReceiptHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
+
+func TestRefundItemsHandler(t *testing.T) {
+	router := &Router{receiptService: &fakeReceiptService{}}
+
+	testCases := []struct {
+		name                      string
+		method                    string
+		url                       string
+		body                      string
+		user                      string // Subject of the bearer token, if any.
+		admin                     bool   // Whether the request is made with an admin API key instead.
+		expectedStatus            int
+		expectedResponseSubstring string
+	}{
+		{
+			name:                      "Valid Refund",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/refunds",
+			body:                      `{"items": [0, 2]}`,
+			user:                      "user-1",
+			expectedStatus:            http.StatusCreated,
+			expectedResponseSubstring: `"id":"reversal-1","kind":"refund","items":[0,2],"points":6`,
+		},
+		{
+			name:           "Admin",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id/refunds",
+			body:           `{"items": [0]}`,
+			admin:          true,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:                      "Other User",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/refunds",
+			body:                      `{"items": [0]}`,
+			user:                      "user-2",
+			expectedStatus:            http.StatusForbidden,
+			expectedResponseSubstring: `"detail":"The client may not act for the receipt's user."`,
+		},
+		{
+			name:           "Anonymous",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id/refunds",
+			body:           `{"items": [0]}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Invalid Method",
+			method:         http.MethodGet,
+			url:            "/receipts/test-id/refunds",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "Invalid JSON",
+			method:         http.MethodPost,
+			url:            "/receipts/test-id/refunds",
+			body:           `{"items":`,
+			user:           "user-1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:                      "No Items",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/refunds",
+			body:                      `{"items": []}`,
+			user:                      "user-1",
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"pointer":"/items"`,
+		},
+		{
+			name:                      "Negative Item",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/refunds",
+			body:                      `{"items": [-1]}`,
+			user:                      "user-1",
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"pointer":"/items/0"`,
+		},
+		{
+			name:                      "Unknown Item",
+			method:                    http.MethodPost,
+			url:                       "/receipts/test-id/refunds",
+			body:                      `{"items": [3]}`,
+			user:                      "user-1",
+			expectedStatus:            http.StatusBadRequest,
+			expectedResponseSubstring: `"detail":"The refund is invalid: receipt has no such item."`,
+		},
+		{
+			name:           "Receipt Not Found",
+			method:         http.MethodPost,
+			url:            "/receipts/missing-id/refunds",
+			body:           `{"items": [0]}`,
+			user:           "user-1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Already Refunded",
+			method:         http.MethodPost,
+			url:            "/receipts/voided-id/refunds",
+			body:           `{"items": [0]}`,
+			user:           "user-1",
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Service Error",
+			method:         http.MethodPost,
+			url:            "/receipts/error-id/refunds",
+			body:           `{"items": [0]}`,
+			user:           "user-1",
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := userRequest(tc.method, tc.url, tc.body, tc.user)
+			if tc.user == "" {
+				req = httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			}
+			if tc.admin {
+				req = adminRequest(tc.method, tc.url, tc.body)
+			}
+			w := httptest.NewRecorder()
+			// Call the RefundItemsHandler directly.
+			router.RefundItemsHandler(w, req)
+			resp := w.Result()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status %d, got %d", tc.expectedStatus, resp.StatusCode)
+			}
+			responseData, _ := io.ReadAll(resp.Body)
+			bodyStr := string(responseData)
+			if tc.expectedResponseSubstring != "" && !strings.Contains(bodyStr, tc.expectedResponseSubstring) {
+				t.Errorf("expected response to contain %q, got %q", tc.expectedResponseSubstring, bodyStr)
+			}
+		})
+	}
+}
@@ -1,40 +1,97 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 
 	"receipt_processor/pkg/middleware"
+	"receipt_processor/pkg/openapi"
 	"receipt_processor/pkg/service"
+
+	"github.com/rs/zerolog/log"
 )
 
 // Router is the API router that ties the HTTP endpoints to the service layer.
 type Router struct {
-	receiptService service.IReceiptService
-	middlewares    []middleware.Middleware
+	receiptService  service.IReceiptService
+	pointsService   service.IPointsService
+	campaignService service.ICampaignService
+	middlewares     []middleware.Middleware
 }
 
 // NewRouter creates a new HTTP handler with the defined routes and applies the given middleware.
-func NewRouter(rs service.IReceiptService, mws []middleware.Middleware) http.Handler {
+func NewRouter(rs service.IReceiptService, ps service.IPointsService, cs service.ICampaignService, mws []middleware.Middleware) http.Handler {
 	r := &Router{
-		receiptService: rs,
-		middlewares:    mws,
+		receiptService:  rs,
+		pointsService:   ps,
+		campaignService: cs,
+		middlewares:     mws,
 	}
 
-	// Using the standard ServeMux.
+	// Using the standard ServeMux. Every route must be described by the OpenAPI spec,
+	// which its handler validates requests against.
 	mux := http.NewServeMux()
-	// Register the process receipt endpoint.
-	mux.Handle("/receipts/process", applyMiddlewares(http.HandlerFunc(r.ProcessReceiptHandler), mws))
-	// Register the get points endpoint. Since the route includes a dynamic receipt ID,
-	// we register a prefix and then parse the ID within the handler.
-	mux.Handle("/receipts/", applyMiddlewares(http.HandlerFunc(r.GetPointsHandler), mws))
+	for _, route := range r.routes() {
+		if _, ok := spec.Paths[route.pattern]; !ok {
+			panic(fmt.Sprintf("route %s is not described by the OpenAPI spec", route.pattern))
+		}
+		mux.Handle(route.pattern, applyMiddlewares(route.handler, mws))
+	}
 
 	return mux
 }
 
+// route ties a ServeMux pattern, which is also a path in the OpenAPI spec, to its handler.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// routes returns the API routes. Path wildcards such as {id} are parsed and
+// validated by each handler itself.
+func (r *Router) routes() []route {
+	return []route{
+		// The OpenAPI document.
+		{"/openapi.yaml", r.OpenAPIHandler},
+		// The process receipt endpoint.
+		{"/receipts/process", r.ProcessReceiptHandler},
+		// The batch receipt submission endpoint.
+		{"/receipts/batch", r.ProcessBatchHandler},
+		// The receipt listing endpoint.
+		{"/receipts", r.ListReceiptsHandler},
+		// The receipt lookup endpoints.
+		{"/receipts/{id}", r.GetReceiptHandler},
+		{"/receipts/{id}/points", r.GetPointsHandler},
+		{"/receipts/{id}/breakdown", r.GetBreakdownHandler},
+		// The receipt void and refund endpoints.
+		{"/receipts/{id}/void", r.VoidReceiptHandler},
+		{"/receipts/{id}/refunds", r.RefundItemsHandler},
+		// The user points balance and redemption endpoints.
+		{"/users/{id}/points", r.GetUserPointsHandler},
+		{"/users/{id}/redemptions", r.RedeemPointsHandler},
+		// The admin endpoints managing the tenant's campaigns.
+		{"/admin/campaigns", r.CampaignsHandler},
+		{"/admin/campaigns/{id}", r.CampaignHandler},
+	}
+}
+
+// OpenAPIHandler handles GET /openapi.yaml.
+// It serves the embedded OpenAPI document that requests are validated against.
+func (r *Router) OpenAPIHandler(w http.ResponseWriter, req *http.Request) {
+	if _, ok := operation(w, req, "/openapi.yaml"); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(openapi.Spec); err != nil {
+		log.Ctx(req.Context()).Error().Err(err).Msg("Failed to write response")
+	}
+}
+
 // applyMiddlewares composes the middleware functions around the handler.
+// The first middleware is the outermost, so middleware runs in the order given.
 func applyMiddlewares(h http.Handler, mws []middleware.Middleware) http.Handler {
-	for _, mw := range mws {
-		h = mw(h)
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
 	}
 	return h
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net/http"
@@ -8,7 +9,9 @@ import (
 	"strings"
 	"testing"
 
+	"receipt_processor/pkg/auth"
 	"receipt_processor/pkg/middleware"
+	"receipt_processor/pkg/openapi"
 	"receipt_processor/pkg/service"
 )
 
@@ -19,8 +22,47 @@ func (f *fakeService) ProcessReceipt(ctx context.Context, receipt service.Receip
 	return "fake-id", nil
 }
 
-func (f *fakeService) GetPoints(ctx context.Context, receiptID string) (int, error) {
-	return 42, nil
+func (f *fakeService) ProcessBatch(ctx context.Context, receipts []service.ReceiptDTO, atomic bool) ([]service.BatchResult, error) {
+	results := make([]service.BatchResult, len(receipts))
+	for i := range results {
+		results[i].ID = "fake-id"
+	}
+	return results, nil
+}
+
+func (f *fakeService) GetPoints(ctx context.Context, receiptID string) (service.ReceiptPointsDTO, error) {
+	return service.ReceiptPointsDTO{Points: 42, AwardedPoints: 42}, nil
+}
+
+func (f *fakeService) GetReceipt(ctx context.Context, receiptID string) (service.StoredReceiptDTO, error) {
+	return service.StoredReceiptDTO{ID: receiptID, Points: 42}, nil
+}
+
+func (f *fakeService) ListReceipts(ctx context.Context, query service.ReceiptQuery) (service.ReceiptPage, error) {
+	return service.ReceiptPage{Receipts: []service.StoredReceiptDTO{{ID: "fake-id"}}}, nil
+}
+
+func (f *fakeService) GetBreakdown(ctx context.Context, receiptID string) (service.PointsBreakdown, error) {
+	return service.PointsBreakdown{Points: 42, Rules: []service.RuleContribution{}}, nil
+}
+
+func (f *fakeService) VoidReceipt(ctx context.Context, receiptID string) (service.ReversalDTO, error) {
+	return service.ReversalDTO{ID: "reversal-id", Kind: "void", Items: []int{0}, Points: 42}, nil
+}
+
+func (f *fakeService) RefundItems(ctx context.Context, receiptID string, items []int) (service.ReversalDTO, error) {
+	return service.ReversalDTO{ID: "reversal-id", Kind: "refund", Items: items, Points: 6}, nil
+}
+
+// fakePointsService is a fake implementation of service.IPointsService for testing.
+type fakePointsService struct{}
+
+func (f *fakePointsService) GetUserPoints(ctx context.Context, userID string) (service.UserPointsDTO, error) {
+	return service.UserPointsDTO{UserID: userID, Points: 42, Entries: []service.LedgerEntryDTO{}}, nil
+}
+
+func (f *fakePointsService) Redeem(ctx context.Context, userID, idempotencyKey string, points int) (service.RedemptionDTO, error) {
+	return service.RedemptionDTO{ID: "1", UserID: userID, Points: points, Balance: 42 - points}, nil
 }
 
 // dummyMiddleware is a simple middleware that adds an "X-Dummy: dummy" header to the response.
@@ -35,7 +77,7 @@ func TestRouterEndpoints(t *testing.T) {
 	// Create a fake service and a middleware chain with dummyMiddleware.
 	fs := &fakeService{}
 	mws := []middleware.Middleware{dummyMiddleware}
-	router := NewRouter(fs, mws)
+	router := NewRouter(fs, &fakePointsService{}, &fakeCampaignService{}, mws)
 
 	t.Run("POST /receipts/process", func(t *testing.T) {
 		// A valid JSON payload for processing a receipt.
@@ -76,6 +118,36 @@ func TestRouterEndpoints(t *testing.T) {
 		}
 	})
 
+	t.Run("POST /receipts/batch", func(t *testing.T) {
+		payload := `[{
+			"retailer": "TestRetailer",
+			"purchaseDate": "2022-01-01",
+			"purchaseTime": "12:00",
+			"total": "10.00",
+			"items": [{"shortDescription": "Item A", "price": "5.00"}]
+		}]`
+		req := httptest.NewRequest(http.MethodPost, "/receipts/batch", strings.NewReader(payload))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the per-receipt results.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `{"index":0,"id":"fake-id"}`) {
+			t.Errorf("Expected response to contain the batch results, got %s", bodyStr)
+		}
+	})
+
 	t.Run("GET /receipts/{id}/points", func(t *testing.T) {
 		// Send a GET request to the get points endpoint.
 		req := httptest.NewRequest(http.MethodGet, "/receipts/test-id/points", nil)
@@ -104,4 +176,267 @@ func TestRouterEndpoints(t *testing.T) {
 			t.Errorf("Expected response to contain '\"points\":42', got %s", bodyStr)
 		}
 	})
+	t.Run("GET /receipts", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/receipts?limit=1", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the listed receipts.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"receipts":[{"id":"fake-id"`) {
+			t.Errorf("Expected response to contain the listed receipts, got %s", bodyStr)
+		}
+	})
+
+	t.Run("GET /receipts/{id}", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/receipts/test-id", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the stored receipt.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"id":"test-id"`) {
+			t.Errorf("Expected response to contain '\"id\":\"test-id\"', got %s", bodyStr)
+		}
+	})
+
+	t.Run("GET /receipts/{id}/breakdown", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/receipts/test-id/breakdown", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the breakdown.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"points":42,"rules":[]`) {
+			t.Errorf("Expected response to contain the breakdown, got %s", bodyStr)
+		}
+	})
+
+	t.Run("GET /users/{id}/points", func(t *testing.T) {
+		req := userRequest(http.MethodGet, "/users/user-1/points", "", "user-1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the balance.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"userId":"user-1","points":42`) {
+			t.Errorf("Expected response to contain the balance, got %s", bodyStr)
+		}
+	})
+
+	t.Run("POST /users/{id}/redemptions", func(t *testing.T) {
+		req := userRequest(http.MethodPost, "/users/user-1/redemptions", `{"points": 2}`, "user-1")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "key-1")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status 201, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the redemption.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"points":2,"balance":40`) {
+			t.Errorf("Expected response to contain the redemption, got %s", bodyStr)
+		}
+	})
+
+	t.Run("POST /receipts/{id}/void", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/receipts/test-id/void", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status 201, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the reversal.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"kind":"void","items":[0],"points":42`) {
+			t.Errorf("Expected response to contain the reversal, got %s", bodyStr)
+		}
+	})
+
+	t.Run("POST /receipts/{id}/refunds", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/receipts/test-id/refunds", strings.NewReader(`{"items": [1]}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("Expected status 201, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the reversal.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"kind":"refund","items":[1],"points":6`) {
+			t.Errorf("Expected response to contain the reversal, got %s", bodyStr)
+		}
+	})
+
+	t.Run("GET /admin/campaigns", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/campaigns", nil)
+		req = req.WithContext(auth.NewContext(req.Context(), auth.Identity{ClientID: "admin-key", Admin: true}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+
+		// Verify the response body contains the campaigns.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		bodyStr := string(bodyBytes)
+		if !strings.Contains(bodyStr, `"campaigns":[{"id":"campaign-1"`) {
+			t.Errorf("Expected response to contain the campaigns, got %s", bodyStr)
+		}
+	})
+
+	t.Run("GET /openapi.yaml", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		// Verify status code and content type.
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", res.StatusCode)
+		}
+		if res.Header.Get("Content-Type") != "application/yaml" {
+			t.Errorf("Expected Content-Type application/yaml, got '%s'", res.Header.Get("Content-Type"))
+		}
+
+		// Verify the embedded document is served.
+		bodyBytes, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		if !bytes.Equal(bodyBytes, openapi.Spec) {
+			t.Errorf("Expected the embedded OpenAPI document")
+		}
+	})
+
+	t.Run("Method not in spec", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/receipts/test-id", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		res := rec.Result()
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status 405, got %d", res.StatusCode)
+		}
+		if res.Header.Get("Allow") != "GET" {
+			t.Errorf("Expected Allow header to be 'GET', got '%s'", res.Header.Get("Allow"))
+		}
+	})
+}
+
+func TestRoutesMatchSpec(t *testing.T) {
+	// Every route must be described by the spec, and every path in the spec must be routed.
+	routed := make(map[string]bool)
+	for _, route := range (&Router{}).routes() {
+		routed[route.pattern] = true
+		if _, ok := spec.Paths[route.pattern]; !ok {
+			t.Errorf("route %s is not described by the OpenAPI spec", route.pattern)
+		}
+	}
+	for path := range spec.Paths {
+		if !routed[path] {
+			t.Errorf("path %s in the OpenAPI spec has no route", path)
+		}
+	}
+}
+
+func TestApplyMiddlewaresOrder(t *testing.T) {
+	// Each middleware records its name, so the handler sees the order they ran in.
+	var order []string
+	record := func(name string) middleware.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := applyMiddlewares(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		[]middleware.Middleware{record("first"), record("second"), record("third")})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if strings.Join(order, ",") != "first,second,third" {
+		t.Errorf("expected middleware to run in the order given, got %v", order)
+	}
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"receipt_processor/pkg/openapi"
+	"receipt_processor/pkg/problem"
+	"receipt_processor/pkg/service"
+)
+
+// spec is the embedded OpenAPI document. Every route is validated against it,
+// so the published document and the behavior of the API cannot drift apart.
+var spec = openapi.MustLoad()
+
+// maxPurchaseSkew is how far in the future a purchase may be, to allow for clock skew.
+const maxPurchaseSkew = 24 * time.Hour
+
+// operation looks up the operation for the request method on the path template.
+// If the spec does not define one, it writes a 405 response and returns false.
+func operation(w http.ResponseWriter, req *http.Request, path string) (*openapi.Operation, bool) {
+	op := spec.Operation(req.Method, path)
+	if op == nil {
+		w.Header().Set("Allow", strings.Join(spec.Methods(path), ", "))
+		problem.Write(w, req, problem.New(http.StatusMethodNotAllowed, ""))
+		return nil, false
+	}
+	return op, true
+}
+
+// validateParameters checks the request's path and query parameters against the operation.
+// If any are invalid, it writes a 400 problem listing them and returns false.
+func validateParameters(w http.ResponseWriter, req *http.Request, op *openapi.Operation, pathParams map[string]string) bool {
+	if errs := op.ValidateParameters(pathParams, req.URL.Query(), req.Header); len(errs) > 0 {
+		problem.Write(w, req, problem.Validation("The request is invalid.", errs))
+		return false
+	}
+	return true
+}
+
+// decodeReceipt validates a JSON receipt against the schema from the spec and decodes it.
+// It returns an error only if the data is not valid JSON; otherwise it returns every
+// violation of the schema and of validateReceipt, each identified by a JSON pointer.
+func decodeReceipt(schema *openapi.Schema, data []byte) (service.ReceiptDTO, []problem.FieldError, error) {
+	var receipt service.ReceiptDTO
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return receipt, nil, err
+	}
+	if errs := schema.Validate(value, ""); len(errs) > 0 {
+		return receipt, errs, nil
+	}
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return receipt, nil, fmt.Errorf("invalid receipt: %v", err)
+	}
+	return receipt, validateReceipt(receipt), nil
+}
+
+// validateReceipt checks the rules that the spec cannot express: that the timezone
+// is known and that the purchase is not in the future.
+func validateReceipt(receipt service.ReceiptDTO) []problem.FieldError {
+	if _, err := service.ParseTimezone(receipt.Timezone); err != nil {
+		return []problem.FieldError{{Pointer: "/timezone", Detail: err.Error()}}
+	}
+	purchasedAt, err := service.PurchaseTime(receipt)
+	if err != nil {
+		return []problem.FieldError{{Pointer: "/purchaseDate", Detail: err.Error()}}
+	}
+	if purchasedAt.After(time.Now().Add(maxPurchaseSkew)) {
+		return []problem.FieldError{{Pointer: "/purchaseDate", Detail: "purchase is in the future"}}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package auth carries the identity of the authenticated client through the request context.
+package auth
+
+import "context"
+
+// Authentication methods.
+const (
+	// MethodAPIKey identifies clients by an API key in the X-API-Key header.
+	MethodAPIKey = "api_key"
+)
+
+// DefaultTenant is the tenant of anonymous requests and of clients without a tenant of
+// their own, and owns the receipts stored before tenants were introduced.
+const DefaultTenant = "default"
+
+// Identity is the authenticated client that made a request.
+type Identity struct {
+	ClientID string // Stable ID of the client's credential, e.g. the API key ID.
+	Name     string // Name of the client the credential was issued to.
+	Method   string // How the client authenticated, e.g. MethodAPIKey.
+	TenantID string // The tenant whose receipts the client may access.
+	Admin    bool   // Whether the client may use the admin API for its tenant.
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying the identity.
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity in ctx, if the request was authenticated.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// CanActFor reports whether the client that made the request may act for the user: earn
+// points, see their balance, spend it or reverse their receipts. Only the user, by the subject of their token, and
+// admins of the tenant may.
+func CanActFor(ctx context.Context, userID string) bool {
+	if identity, ok := FromContext(ctx); ok && identity.Admin {
+		return true
+	}
+	user, ok := UserID(ctx)
+	return ok && user == userID
+}
+
+// TenantID returns the tenant of the client that made the request, or DefaultTenant
+// for anonymous requests and clients without a tenant.
+func TenantID(ctx context.Context) string {
+	if identity, ok := FromContext(ctx); ok && identity.TenantID != "" {
+		return identity.TenantID
+	}
+	return DefaultTenant
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// minRefreshInterval limits how often a key set is reloaded to find an unknown key ID,
+// so tokens with made-up key IDs cannot flood the JWKS endpoint.
+const minRefreshInterval = time.Minute
+
+// jwk is a public key from a key set.
+type jwk struct {
+	alg string // The algorithm the key is restricted to, if any.
+	key crypto.PublicKey
+}
+
+// KeySet is a JSON Web Key Set read from a file or URL. The keys are cached and
+// reloaded every refresh interval, and also when a token names a key ID that is not
+// in the cache, so signing keys can be rotated without a restart.
+type KeySet struct {
+	source          string
+	fetch           func(ctx context.Context) ([]byte, error)
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]jwk // By key ID.
+	loadedAt    time.Time      // When the keys were last loaded.
+	attemptedAt time.Time      // When loading was last attempted.
+}
+
+// NewKeySetFromURL creates a key set fetched from a JWKS URL.
+func NewKeySetFromURL(url string, client *http.Client, refreshInterval time.Duration) *KeySet {
+	fetch := func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	}
+	return newKeySet(url, fetch, refreshInterval, time.Now)
+}
+
+// NewKeySetFromFile creates a key set read from a JWKS file.
+func NewKeySetFromFile(path string, refreshInterval time.Duration) *KeySet {
+	fetch := func(ctx context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	}
+	return newKeySet(path, fetch, refreshInterval, time.Now)
+}
+
+func newKeySet(source string, fetch func(ctx context.Context) ([]byte, error), refreshInterval time.Duration, now func() time.Time) *KeySet {
+	return &KeySet{
+		source:          source,
+		fetch:           fetch,
+		refreshInterval: refreshInterval,
+		now:             now,
+	}
+}
+
+// errUnavailable is returned when a key set cannot be loaded and there are no cached keys.
+var errUnavailable = errors.New("key set unavailable")
+
+// key returns the key with the given ID. A token without a key ID may use a key set
+// with a single key.
+func (k *KeySet) key(ctx context.Context, kid string) (jwk, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := k.now()
+	_, known := k.lookup(kid)
+	stale := now.Sub(k.loadedAt) >= k.refreshInterval
+	if stale || (!known && now.Sub(k.attemptedAt) >= minRefreshInterval) {
+		k.attemptedAt = now
+		if err := k.load(ctx); err != nil {
+			if k.keys == nil {
+				return jwk{}, fmt.Errorf("%w: %v", errUnavailable, err)
+			}
+			// Keep verifying tokens with the cached keys until the source is back.
+			log.Ctx(ctx).Warn().Err(err).Str("source", k.source).Msg("Failed to reload JWKS; using cached keys")
+		} else {
+			k.loadedAt = now
+		}
+	}
+
+	key, ok := k.lookup(kid)
+	if !ok {
+		return jwk{}, fmt.Errorf("%w: unknown key ID %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// lookup finds a cached key. The caller must hold k.mu.
+func (k *KeySet) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// load replaces the cached keys with those from the source. The caller must hold k.mu.
+func (k *KeySet) load(ctx context.Context) error {
+	data, err := k.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := parseKeySet(data)
+	if err != nil {
+		return err
+	}
+	k.keys = keys
+	return nil
+}
+
+// parseKeySet parses the signing keys of a JWKS document. Keys of unsupported types,
+// and keys meant for encryption, are skipped.
+func parseKeySet(data []byte) (map[string]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := make(map[string]jwk)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = parseRSAKey(k.N, k.E)
+		case "EC":
+			key, err = parseECKey(k.Crv, k.X, k.Y)
+		case "OKP":
+			key, err = parseEd25519Key(k.Crv, k.X)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = jwk{alg: k.Alg, key: key}
+	}
+	return keys, nil
+}
+
+func parseRSAKey(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(eBytes)
+	if len(nBytes) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("unsupported RSA key size or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: int(exponent.Int64())}, nil
+}
+
+func parseECKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var validate ecdh.Curve
+	switch crv {
+	case "P-256":
+		curve, validate = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, validate = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, validate = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	xBytes, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(xBytes) != size || len(yBytes) != size {
+		return nil, errors.New("invalid point size")
+	}
+	// Reject points that are not on the curve.
+	point := append(append([]byte{4}, xBytes...), yBytes...)
+	if _, err := validate.NewPublicKey(point); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xBytes), Y: new(big.Int).SetBytes(yBytes)}, nil
+}
+
+func parseEd25519Key(crv, x string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", crv)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid key size")
+	}
+	return ed25519.PublicKey(key), nil
+}
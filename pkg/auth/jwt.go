@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // Register the hashes used by the signature algorithms.
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// MethodJWT identifies clients by a JWT bearer token in the Authorization header.
+const MethodJWT = "jwt"
+
+// ErrInvalidToken is returned, wrapped with the reason, when a token is malformed,
+// badly signed, expired, or not meant for this service.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	Issuer    string
+	Subject   string // The user the token was issued to.
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time // Zero if the token has no "nbf" claim.
+	IssuedAt  time.Time // Zero if the token has no "iat" claim.
+	// Raw holds every claim of the token, including the registered claims above.
+	// Numbers are json.Number.
+	Raw map[string]interface{}
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the claims of a verified token.
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims in ctx, if the request had a verified token.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// UserID returns the user the request was made by: the subject of its verified token.
+// Clients authenticated by an API key, and anonymous requests, act for no user.
+func UserID(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.Subject == "" {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// JWTSettings configures the verification of JWT bearer tokens.
+type JWTSettings struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Issuer   string `mapstructure:"issuer"`   // Required value of the "iss" claim.
+	Audience string `mapstructure:"audience"` // Required member of the "aud" claim.
+	// JWKSURL or JWKSFile is where the signing keys are read from.
+	JWKSURL  string `mapstructure:"jwks_url"`
+	JWKSFile string `mapstructure:"jwks_file"`
+	// RefreshInterval is how long the signing keys are cached.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// Leeway allows for clock skew when checking the "exp", "nbf" and "iat" claims.
+	Leeway time.Duration `mapstructure:"leeway"`
+	// TenantClaim names the claim holding the tenant of the token's subject. Tokens
+	// without it are rejected. If unset, every token belongs to DefaultTenant.
+	TenantClaim string `mapstructure:"tenant_claim"`
+}
+
+// LoadJWTSettings reads the settings from the "auth.jwt" configuration key.
+func LoadJWTSettings() (JWTSettings, error) {
+	settings := JWTSettings{RefreshInterval: time.Hour, Leeway: time.Minute}
+	if err := viper.UnmarshalKey("auth.jwt", &settings); err != nil {
+		return JWTSettings{}, fmt.Errorf("invalid JWT settings: %v", err)
+	}
+	return settings, nil
+}
+
+// JWTVerifier verifies JWT bearer tokens against a key set.
+type JWTVerifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewJWTVerifier creates a verifier from the settings. The issuer, audience and one of
+// the JWKS URL or file are required.
+func NewJWTVerifier(settings JWTSettings) (*JWTVerifier, error) {
+	if settings.Issuer == "" || settings.Audience == "" {
+		return nil, errors.New("JWT issuer and audience are required")
+	}
+	if settings.RefreshInterval <= 0 {
+		return nil, errors.New("JWKS refresh interval must be positive")
+	}
+	var keys *KeySet
+	switch {
+	case settings.JWKSURL != "" && settings.JWKSFile != "":
+		return nil, errors.New("set either a JWKS URL or a JWKS file, not both")
+	case settings.JWKSURL != "":
+		keys = NewKeySetFromURL(settings.JWKSURL, &http.Client{Timeout: 10 * time.Second}, settings.RefreshInterval)
+	case settings.JWKSFile != "":
+		keys = NewKeySetFromFile(settings.JWKSFile, settings.RefreshInterval)
+	default:
+		return nil, errors.New("a JWKS URL or file is required")
+	}
+	return newJWTVerifier(keys, settings.Issuer, settings.Audience, settings.Leeway, time.Now), nil
+}
+
+func newJWTVerifier(keys *KeySet, issuer, audience string, leeway time.Duration, now func() time.Time) *JWTVerifier {
+	return &JWTVerifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   leeway,
+		now:      now,
+	}
+}
+
+// signatureAlgorithms maps the supported "alg" header values to their hash.
+// Symmetric algorithms and "none" are deliberately not supported.
+var signatureAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// Verify checks the token's signature, expiry, issuer and audience, and returns its claims.
+// Errors for tokens that fail the checks wrap ErrInvalidToken; other errors mean the
+// signing keys could not be loaded.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	hash, ok := signatureAlgorithms[header.Alg]
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	key, err := v.keys.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return Claims{}, fmt.Errorf("%w: key %q is not for %s", ErrInvalidToken, header.Kid, header.Alg)
+	}
+	if err := verifySignature(header.Alg, hash, key.key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := v.validate(claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// validate checks the registered claims.
+func (v *JWTVerifier) validate(claims Claims) error {
+	now := v.now()
+	if claims.ExpiresAt.IsZero() {
+		return errors.New("token has no expiry")
+	}
+	if !now.Before(claims.ExpiresAt.Add(v.leeway)) {
+		return errors.New("token has expired")
+	}
+	if !claims.NotBefore.IsZero() && now.Add(v.leeway).Before(claims.NotBefore) {
+		return errors.New("token is not valid yet")
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(v.leeway).Before(claims.IssuedAt) {
+		return errors.New("token was issued in the future")
+	}
+	if claims.Subject == "" {
+		return errors.New("token has no subject")
+	}
+	if claims.Issuer != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	for _, audience := range claims.Audience {
+		if audience == v.audience {
+			return nil
+		}
+	}
+	return errors.New("token is not meant for this audience")
+}
+
+// verifySignature checks the signature of the signed part of a token.
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed, signature []byte) error {
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if ecdsa.Verify(k, digest, r, s) {
+			return nil
+		}
+		return errors.New("invalid signature")
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			break
+		}
+		if ed25519.Verify(k, signed, signature) {
+			return nil
+		}
+		return errors.New("invalid signature")
+	}
+	return fmt.Errorf("key cannot verify %s signatures", alg)
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token.
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// parseClaims reads the registered claims from the decoded claims.
+func parseClaims(raw map[string]interface{}) (Claims, error) {
+	claims := Claims{Raw: raw}
+	var ok bool
+	if v, present := raw["iss"]; present {
+		if claims.Issuer, ok = v.(string); !ok {
+			return Claims{}, errors.New(`"iss" must be a string`)
+		}
+	}
+	if v, present := raw["sub"]; present {
+		if claims.Subject, ok = v.(string); !ok {
+			return Claims{}, errors.New(`"sub" must be a string`)
+		}
+	}
+	switch aud := raw["aud"].(type) {
+	case nil:
+	case string:
+		claims.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return Claims{}, errors.New(`"aud" must be a string or an array of strings`)
+			}
+			claims.Audience = append(claims.Audience, s)
+		}
+	default:
+		return Claims{}, errors.New(`"aud" must be a string or an array of strings`)
+	}
+	for name, dst := range map[string]*time.Time{"exp": &claims.ExpiresAt, "nbf": &claims.NotBefore, "iat": &claims.IssuedAt} {
+		v, present := raw[name]
+		if !present {
+			continue
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			return Claims{}, fmt.Errorf("%q must be a number", name)
+		}
+		seconds, err := n.Float64()
+		if err != nil || math.Abs(seconds) > 1e12 {
+			return Claims{}, fmt.Errorf("%q must be a number of seconds", name)
+		}
+		whole := math.Floor(seconds)
+		*dst = time.Unix(int64(whole), int64((seconds-whole)*float64(time.Second)))
+	}
+	return claims, nil
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKey is a locally generated signing key and its public JWK.
+type testKey struct {
+	kid     string
+	alg     string
+	private crypto.Signer
+	jwk     map[string]string
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newRSAKey(t testing.TB, kid, alg string) testKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return testKey{kid: kid, alg: alg, private: key, jwk: map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}}
+}
+
+func newECKey(t testing.TB, kid string) testKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	return testKey{kid: kid, alg: "ES256", private: key, jwk: map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256", "alg": "ES256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+	}}
+}
+
+func newEd25519Key(t testing.TB, kid string) testKey {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %v", err)
+	}
+	return testKey{kid: kid, alg: "EdDSA", private: private, jwk: map[string]string{
+		"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(public),
+	}}
+}
+
+// sign creates a token with the key's algorithm, unless the header overrides it.
+func (k testKey) sign(t testing.TB, header map[string]interface{}, claims map[string]interface{}) string {
+	h := map[string]interface{}{"alg": k.alg, "typ": "JWT", "kid": k.kid}
+	for name, value := range header {
+		h[name] = value
+	}
+	headerJSON, _ := json.Marshal(h)
+	claimsJSON, _ := json.Marshal(claims)
+	signed := b64(headerJSON) + "." + b64(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	var err error
+	switch key := k.private.(type) {
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(k.alg, "PS") {
+			signature, err = rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed + "." + b64(signature)
+}
+
+// jwks returns a JWKS document with the keys.
+func jwks(keys ...testKey) []byte {
+	set := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk)
+	}
+	data, _ := json.Marshal(set)
+	return data
+}
+
+func TestJWTVerifier(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rsaKey := newRSAKey(t, "rsa", "RS256")
+	psKey := newRSAKey(t, "ps", "PS256")
+	ecKey := newECKey(t, "ec")
+	edKey := newEd25519Key(t, "ed")
+	otherKey := newRSAKey(t, "rsa", "RS256") // Not in the key set, but with a known key ID.
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks(rsaKey, psKey, ecKey, edKey), 0o600); err != nil {
+		t.Fatalf("failed to write JWKS: %v", err)
+	}
+	verifier := newJWTVerifier(NewKeySetFromFile(path, time.Hour), "https://issuer.example", "receipts", time.Minute, func() time.Time { return now })
+
+	// claims returns valid claims with the given changes; a nil value removes the claim.
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": "https://issuer.example", "sub": "user-1", "aud": "receipts",
+			"exp": now.Add(time.Hour).Unix(), "iat": now.Unix(), "tenant": "acme",
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+
+	testCases := []struct {
+		name        string
+		token       string
+		expectValid bool
+	}{
+		{name: "RS256", token: rsaKey.sign(t, nil, claims(nil)), expectValid: true},
+		{name: "PS256", token: psKey.sign(t, nil, claims(nil)), expectValid: true},
+		{name: "ES256", token: ecKey.sign(t, nil, claims(nil)), expectValid: true},
+		{name: "EdDSA", token: edKey.sign(t, nil, claims(nil)), expectValid: true},
+		{name: "Audience Array", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"aud": []string{"other", "receipts"}})), expectValid: true},
+		{name: "Expired Within Leeway", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), expectValid: true},
+		{name: "Expired", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}))},
+		{name: "No Expiry", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"exp": nil}))},
+		{name: "Not Valid Yet", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}))},
+		{name: "Wrong Issuer", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"iss": "https://evil.example"}))},
+		{name: "Wrong Audience", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"aud": "billing"}))},
+		{name: "No Subject", token: rsaKey.sign(t, nil, claims(map[string]interface{}{"sub": nil}))},
+		{name: "Signed By Another Key", token: otherKey.sign(t, nil, claims(nil))},
+		{name: "Unknown Key ID", token: rsaKey.sign(t, map[string]interface{}{"kid": "gone"}, claims(nil))},
+		{name: "Key For Another Algorithm", token: ecKey.sign(t, map[string]interface{}{"alg": "ES384"}, claims(nil))},
+		{name: "Algorithm None", token: rsaKey.sign(t, map[string]interface{}{"alg": "none"}, claims(nil))},
+		{name: "Symmetric Algorithm", token: rsaKey.sign(t, map[string]interface{}{"alg": "HS256"}, claims(nil))},
+		{name: "Malformed", token: "not.a-token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := verifier.Verify(context.Background(), tc.token)
+			if !tc.expectValid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("expected ErrInvalidToken, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected a valid token, got %v", err)
+			}
+			if got.Subject != "user-1" || got.Issuer != "https://issuer.example" || got.Raw["tenant"] != "acme" {
+				t.Errorf("unexpected claims: %+v", got)
+			}
+		})
+	}
+
+	// ---- A tampered payload fails the signature check.
+	token := rsaKey.sign(t, nil, claims(nil))
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin"}))
+	if _, err := verifier.Verify(context.Background(), parts[0]+"."+b64(forged)+"."+parts[2]); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a tampered token, got %v", err)
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey := newRSAKey(t, "old", "RS256")
+	newKey := newRSAKey(t, "new", "RS256")
+	now := time.Unix(1700000000, 0)
+	claims := map[string]interface{}{"iss": "iss", "sub": "user-1", "aud": "aud", "exp": now.Add(time.Hour).Unix()}
+
+	// The JWKS endpoint serves the current keys and counts its requests.
+	var current atomic.Value
+	current.Store(jwks(oldKey))
+	var fetches atomic.Int64
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.Write(current.Load().([]byte))
+	}))
+	defer server.Close()
+
+	clock := now
+	keys := NewKeySetFromURL(server.URL, server.Client(), time.Hour)
+	keys.now = func() time.Time { return clock }
+	verifier := newJWTVerifier(keys, "iss", "aud", 0, func() time.Time { return now })
+	verify := func(key testKey) error {
+		_, err := verifier.Verify(context.Background(), key.sign(t, nil, claims))
+		return err
+	}
+
+	// ---- Keys are cached.
+	for i := 0; i < 3; i++ {
+		if err := verify(oldKey); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("expected the keys to be fetched once, got %d", fetches.Load())
+	}
+
+	// ---- A token signed with a new key reloads the keys, at most once a minute.
+	current.Store(jwks(oldKey, newKey))
+	clock = clock.Add(30 * time.Second)
+	if err := verify(newKey); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the new key to be unknown within a minute of loading, got %v", err)
+	}
+	clock = clock.Add(time.Minute)
+	if err := verify(newKey); err != nil {
+		t.Errorf("expected the new key to be loaded, got %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("expected the keys to be fetched twice, got %d", fetches.Load())
+	}
+
+	// ---- Removed keys stop verifying once the cache expires.
+	current.Store(jwks(newKey))
+	clock = clock.Add(time.Hour)
+	if err := verify(oldKey); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the old key to be removed, got %v", err)
+	}
+
+	// ---- While the endpoint is down, the cached keys are used.
+	failing.Store(true)
+	clock = clock.Add(time.Hour)
+	if err := verify(newKey); err != nil {
+		t.Errorf("expected the cached key to be used, got %v", err)
+	}
+
+	// ---- Without cached keys, an outage is not the token's fault.
+	fresh := newJWTVerifier(NewKeySetFromURL(server.URL, server.Client(), time.Hour), "iss", "aud", 0, func() time.Time { return now })
+	if _, err := fresh.Verify(context.Background(), newKey.sign(t, nil, claims)); err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an unavailable key set, got %v", err)
+	}
+}
+
+func TestNewJWTVerifierSettings(t *testing.T) {
+	valid := JWTSettings{Issuer: "iss", Audience: "aud", JWKSFile: "jwks.json", RefreshInterval: time.Hour}
+	if _, err := NewJWTVerifier(valid); err != nil {
+		t.Errorf("did not expect error but got: %v", err)
+	}
+
+	testCases := map[string]func(s *JWTSettings){
+		"Missing Issuer":   func(s *JWTSettings) { s.Issuer = "" },
+		"Missing Audience": func(s *JWTSettings) { s.Audience = "" },
+		"Missing JWKS":     func(s *JWTSettings) { s.JWKSFile = "" },
+		"Both JWKS":        func(s *JWTSettings) { s.JWKSURL = "https://issuer.example/jwks" },
+		"Zero Refresh":     func(s *JWTSettings) { s.RefreshInterval = 0 },
+	}
+	for name, modify := range testCases {
+		t.Run(name, func(t *testing.T) {
+			settings := valid
+			modify(&settings)
+			if _, err := NewJWTVerifier(settings); err == nil {
+				t.Errorf("expected error but got nil")
+			}
+		})
+	}
+}
+
+func TestUserID(t *testing.T) {
+	if _, ok := UserID(context.Background()); ok {
+		t.Errorf("expected no user for an anonymous request")
+	}
+	apiKey := NewContext(context.Background(), Identity{ClientID: "key-1", Method: MethodAPIKey})
+	if _, ok := UserID(apiKey); ok {
+		t.Errorf("expected no user for an API key client")
+	}
+	token := WithClaims(context.Background(), Claims{Subject: "user-1"})
+	if userID, ok := UserID(token); !ok || userID != "user-1" {
+		t.Errorf("expected the token's subject user-1, got %q, %v", userID, ok)
+	}
+}
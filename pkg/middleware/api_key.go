@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/problem"
+	"receipt_processor/pkg/service"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// APIKeyAuthenticator checks API keys. It is implemented by service.IAPIKeyService.
+type APIKeyAuthenticator interface {
+	// Authenticate returns the identity of the client the key was issued to,
+	// or service.ErrInvalidAPIKey if the key is unknown or revoked.
+	Authenticate(ctx context.Context, key string) (auth.Identity, error)
+}
+
+// APIKeySettings configures API key authentication.
+type APIKeySettings struct {
+	// Required rejects requests without an API key. Otherwise they are served anonymously.
+	Required bool `mapstructure:"required"`
+	// PublicRoutes are ServeMux patterns served without an API key even when one is required.
+	PublicRoutes []string `mapstructure:"public_routes"`
+}
+
+// LoadAPIKeySettings reads the settings from the "auth.api_keys" configuration key.
+// Without configuration, API keys are optional.
+func LoadAPIKeySettings() (APIKeySettings, error) {
+	var settings APIKeySettings
+	if err := viper.UnmarshalKey("auth.api_keys", &settings); err != nil {
+		return APIKeySettings{}, fmt.Errorf("invalid API key settings: %v", err)
+	}
+	return settings, nil
+}
+
+// isPublic reports whether the ServeMux pattern is served without an API key.
+func (s APIKeySettings) isPublic(pattern string) bool {
+	for _, route := range s.PublicRoutes {
+		if route == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyMiddleware authenticates requests by the API key in the X-API-Key header. The
+// client's identity is stored in the request context, where auth.FromContext returns it,
+// and added to the context logger, so it must run after RequestIDMiddleware. Requests with
+// an invalid or revoked key, or without any credential where one is required, get a 401.
+func APIKeyMiddleware(authenticator APIKeyAuthenticator, settings APIKeySettings) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			if key == "" {
+				// The request may have been authenticated by JWTMiddleware instead.
+				_, authenticated := auth.FromContext(r.Context())
+				if settings.Required && !authenticated && !settings.isPublic(r.Pattern) {
+					problem.Write(w, r, problem.New(http.StatusUnauthorized, "An API key in the "+APIKeyHeader+" header or a bearer token is required."))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			identity, err := authenticator.Authenticate(r.Context(), key)
+			if errors.Is(err, service.ErrInvalidAPIKey) {
+				problem.Write(w, r, problem.New(http.StatusUnauthorized, "The API key is invalid or has been revoked."))
+				return
+			}
+			if err != nil {
+				log.Ctx(r.Context()).Error().Err(err).Msg("Failed to authenticate API key")
+				problem.Write(w, r, problem.New(http.StatusInternalServerError, ""))
+				return
+			}
+
+			ctx := auth.NewContext(r.Context(), identity)
+			logger := log.Ctx(ctx).With().Str("client_id", identity.ClientID).Str("tenant_id", identity.TenantID).Logger()
+			next.ServeHTTP(w, r.WithContext(logger.WithContext(ctx)))
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/service"
+
+	"github.com/rs/zerolog"
+)
+
+// fakeAuthenticator accepts the key "good", rejects "revoked" and fails on anything else.
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(ctx context.Context, key string) (auth.Identity, error) {
+	switch key {
+	case "good":
+		return auth.Identity{ClientID: "client-1", Name: "Acme", Method: auth.MethodAPIKey}, nil
+	case "revoked":
+		return auth.Identity{}, service.ErrInvalidAPIKey
+	}
+	return auth.Identity{}, errors.New("database down")
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	settings := APIKeySettings{Required: true, PublicRoutes: []string{"/openapi.yaml"}}
+
+	testCases := []struct {
+		name             string
+		pattern          string
+		key              string
+		required         bool
+		expectedStatus   int
+		expectedClientID string // Empty if the request is anonymous.
+	}{
+		{name: "Valid Key", pattern: "/receipts", key: "good", required: true, expectedStatus: http.StatusOK, expectedClientID: "client-1"},
+		{name: "Revoked Key", pattern: "/receipts", key: "revoked", required: true, expectedStatus: http.StatusUnauthorized},
+		{name: "Missing Key", pattern: "/receipts", required: true, expectedStatus: http.StatusUnauthorized},
+		{name: "Public Route", pattern: "/openapi.yaml", required: true, expectedStatus: http.StatusOK},
+		{name: "Invalid Key On Public Route", pattern: "/openapi.yaml", key: "revoked", required: true, expectedStatus: http.StatusUnauthorized},
+		{name: "Optional Key", pattern: "/receipts", expectedStatus: http.StatusOK},
+		{name: "Authenticator Error", pattern: "/receipts", key: "other", required: true, expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := settings
+			s.Required = tc.required
+			var buf bytes.Buffer
+			var identity auth.Identity
+			var authenticated bool
+			handler := APIKeyMiddleware(fakeAuthenticator{}, s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				identity, authenticated = auth.FromContext(r.Context())
+				zerolog.Ctx(r.Context()).Info().Msg("handled")
+			}))
+
+			// The middleware identifies public routes by their ServeMux pattern.
+			mux := http.NewServeMux()
+			mux.Handle(tc.pattern, handler)
+			req := httptest.NewRequest(http.MethodGet, tc.pattern, nil)
+			if tc.key != "" {
+				req.Header.Set(APIKeyHeader, tc.key)
+			}
+			logger := zerolog.New(&buf)
+			req = req.WithContext(logger.WithContext(req.Context()))
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if identity.ClientID != tc.expectedClientID || authenticated != (tc.expectedClientID != "") {
+				t.Errorf("expected client %q, got %+v", tc.expectedClientID, identity)
+			}
+			if tc.expectedClientID != "" && !strings.Contains(buf.String(), `"client_id":"`+tc.expectedClientID+`"`) {
+				t.Errorf("expected the client ID in the log, got %s", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// ClientIPResolver finds the IP address of the client that sent a request. It honors the
+// Forwarded and X-Forwarded-For headers only when they were added by a trusted proxy.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+// NewClientIPResolver creates a resolver that trusts the proxies in the given CIDRs.
+// A bare IP address trusts that address alone.
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	resolver := &ClientIPResolver{}
+	for _, proxy := range trustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		resolver.trusted = append(resolver.trusted, prefix.Masked())
+	}
+	return resolver, nil
+}
+
+// LoadClientIPResolver creates a resolver trusting the proxies in "server.trusted_proxies".
+func LoadClientIPResolver() (*ClientIPResolver, error) {
+	return NewClientIPResolver(viper.GetStringSlice("server.trusted_proxies"))
+}
+
+// isTrusted reports whether the address belongs to a trusted proxy.
+func (c *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client that sent the request. Starting from the
+// peer that connected to us, it walks the forwarding chain from the nearest hop outwards
+// for as long as each hop is a trusted proxy, and returns the first address that is not.
+// The chain stops at an address that cannot be parsed, such as an obfuscated identifier,
+// since nothing beyond it can be attributed.
+func (c *ClientIPResolver) ClientIP(r *http.Request) netip.Addr {
+	addr, ok := parseHost(r.RemoteAddr)
+	if !ok || !c.isTrusted(addr) {
+		return addr
+	}
+
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHost(hops[i])
+		if !ok {
+			break
+		}
+		addr = hop
+		if !c.isTrusted(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// forwardedFor returns the client addresses in the forwarding headers, nearest hop last.
+// The standard Forwarded header takes precedence over X-Forwarded-For.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				// Elements without a "for" parameter keep their place in the chain.
+				hop := ""
+				for _, pair := range strings.Split(element, ";") {
+					name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(name, "for") {
+						hop = strings.Trim(value, `"`)
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHost parses an IPv4 or IPv6 address, with or without a port, as found in
+// RemoteAddr and the forwarding headers: "192.0.2.1", "192.0.2.1:4711", "2001:db8::1"
+// or "[2001:db8::1]:4711". IPv4-mapped IPv6 addresses are returned as IPv4.
+func parseHost(host string) (netip.Addr, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	// Zones identify the local interface, not the client.
+	return addr.Unmap().WithZone(""), true
+}
+
+type clientIPKey struct{}
+
+// ClientIPMiddleware resolves the client IP of each request, stores it in the request
+// context for ClientIP, and adds it to the context logger. It must run after
+// RequestIDMiddleware, which creates the logger.
+func ClientIPMiddleware(resolver *ClientIPResolver) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolver.ClientIP(r)
+			ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+			logger := log.Ctx(ctx).With().Str("client_ip", ip.String()).Logger()
+			next.ServeHTTP(w, r.WithContext(logger.WithContext(ctx)))
+		})
+	}
+}
+
+// ClientIP returns the client IP resolved by ClientIPMiddleware. Without the middleware,
+// it falls back to the peer address of the request, trusting no proxies.
+func ClientIP(r *http.Request) netip.Addr {
+	if ip, ok := r.Context().Value(clientIPKey{}).(netip.Addr); ok {
+		return ip
+	}
+	addr, _ := parseHost(r.RemoteAddr)
+	return addr
+}
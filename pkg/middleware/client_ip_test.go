@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("failed to create resolver: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expectedIP string
+	}{
+		{
+			name:       "Direct Client",
+			remoteAddr: "203.0.113.7:4711",
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "Untrusted Peer Cannot Spoof",
+			remoteAddr: "203.0.113.7:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "X-Forwarded-For From Trusted Proxy",
+			remoteAddr: "10.1.2.3:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "Spoofed Entries Left Of The Client Are Ignored",
+			remoteAddr: "10.1.2.3:4711",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.9.9.9"},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "Single Trusted Address",
+			remoteAddr: "192.0.2.10:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "Only Trusted Proxies",
+			remoteAddr: "10.1.2.3:4711",
+			headers:    map[string]string{"X-Forwarded-For": "10.4.4.4, 10.5.5.5"},
+			expectedIP: "10.4.4.4",
+		},
+		{
+			name:       "Forwarded Takes Precedence",
+			remoteAddr: "10.1.2.3:4711",
+			headers: map[string]string{
+				"Forwarded":       `for=198.51.100.2;proto=https, for="10.9.9.9:80"`,
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			expectedIP: "198.51.100.2",
+		},
+		{
+			name:       "Forwarded IPv6",
+			remoteAddr: "[2001:db8:ffff::1]:4711",
+			headers:    map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711"`},
+			expectedIP: "2001:db8:cafe::17",
+		},
+		{
+			name:       "X-Forwarded-For IPv6",
+			remoteAddr: "[2001:db8:ffff::1]:4711",
+			headers:    map[string]string{"X-Forwarded-For": "2001:db8:cafe::17"},
+			expectedIP: "2001:db8:cafe::17",
+		},
+		{
+			name:       "IPv4-Mapped IPv6 Peer",
+			remoteAddr: "[::ffff:10.1.2.3]:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expectedIP: "198.51.100.1",
+		},
+		{
+			name:       "Obfuscated Hop Stops The Chain",
+			remoteAddr: "10.1.2.3:4711",
+			headers:    map[string]string{"Forwarded": "for=198.51.100.2, for=_hidden, for=10.9.9.9"},
+			expectedIP: "10.9.9.9",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/receipts", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			if got := resolver.ClientIP(req).String(); got != tc.expectedIP {
+				t.Errorf("expected client IP %s, got %s", tc.expectedIP, got)
+			}
+		})
+	}
+}
+
+func TestNewClientIPResolverInvalid(t *testing.T) {
+	if _, err := NewClientIPResolver([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("expected error for an invalid CIDR")
+	}
+	if _, err := NewClientIPResolver([]string{"proxy.internal"}); err == nil {
+		t.Errorf("expected error for a host name")
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	resolver, _ := NewClientIPResolver([]string{"10.0.0.0/8"})
+	var buf bytes.Buffer
+	var got string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r).String()
+		log.Ctx(r.Context()).Info().Msg("handled")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/receipts", nil)
+	req.RemoteAddr = "10.1.2.3:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	logger := zerolog.New(&buf)
+	req = req.WithContext(logger.WithContext(req.Context()))
+	ClientIPMiddleware(resolver)(http.HandlerFunc(handler)).ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "198.51.100.1" {
+		t.Errorf("expected the resolved client IP in the context, got %s", got)
+	}
+	if !strings.Contains(buf.String(), `"client_ip":"198.51.100.1"`) {
+		t.Errorf("expected the client IP in the log, got %s", buf.String())
+	}
+}
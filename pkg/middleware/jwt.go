@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/problem"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TokenVerifier verifies bearer tokens. It is implemented by auth.JWTVerifier.
+type TokenVerifier interface {
+	// Verify returns the claims of a valid token. Errors for invalid tokens wrap auth.ErrInvalidToken.
+	Verify(ctx context.Context, token string) (auth.Claims, error)
+}
+
+// JWTMiddleware authenticates requests by a JWT bearer token in the Authorization header.
+// The token's claims are stored in the request context, where auth.ClaimsFromContext returns
+// them and auth.UserID returns the subject as the user receipts and points are scoped to, along
+// with an identity for the subject, and the subject is added to the context logger.
+// The subject's tenant is read from tenantClaim, if set; otherwise it is auth.DefaultTenant.
+// Requests without a bearer token are passed on, so APIKeyMiddleware, which must run after this
+// middleware, can authenticate them or reject them if authentication is required. Requests with
+// an invalid token, or one without a tenant, get a 401.
+func JWTMiddleware(verifier TokenVerifier, tenantClaim string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := verifier.Verify(r.Context(), strings.TrimSpace(token))
+			if errors.Is(err, auth.ErrInvalidToken) {
+				log.Ctx(r.Context()).Info().Err(err).Msg("Rejected bearer token")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				problem.Write(w, r, problem.New(http.StatusUnauthorized, "The bearer token is invalid or has expired."))
+				return
+			}
+			if err != nil {
+				log.Ctx(r.Context()).Error().Err(err).Msg("Failed to verify bearer token")
+				problem.Write(w, r, problem.New(http.StatusServiceUnavailable, "Bearer tokens cannot be verified right now. Try again later."))
+				return
+			}
+
+			tenantID := auth.DefaultTenant
+			if tenantClaim != "" {
+				tenantID, _ = claims.Raw[tenantClaim].(string)
+				if tenantID == "" {
+					log.Ctx(r.Context()).Info().Str("claim", tenantClaim).Msg("Rejected bearer token without a tenant")
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					problem.Write(w, r, problem.New(http.StatusUnauthorized, "The bearer token does not name a tenant."))
+					return
+				}
+			}
+
+			ctx := auth.WithClaims(r.Context(), claims)
+			ctx = auth.NewContext(ctx, auth.Identity{ClientID: claims.Subject, Name: claims.Subject, Method: auth.MethodJWT, TenantID: tenantID})
+			logger := log.Ctx(ctx).With().Str("client_id", claims.Subject).Str("tenant_id", tenantID).Logger()
+			next.ServeHTTP(w, r.WithContext(logger.WithContext(ctx)))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+)
+
+// fakeVerifier accepts the token "good", rejects "bad" and fails on anything else.
+type fakeVerifier struct{}
+
+func (fakeVerifier) Verify(ctx context.Context, token string) (auth.Claims, error) {
+	switch token {
+	case "good":
+		return auth.Claims{Subject: "user-1", Raw: map[string]interface{}{"sub": "user-1", "org": "acme"}}, nil
+	case "bad":
+		return auth.Claims{}, fmt.Errorf("%w: token has expired", auth.ErrInvalidToken)
+	}
+	return auth.Claims{}, errors.New("key set unavailable")
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	testCases := []struct {
+		name            string
+		authorization   string
+		expectedStatus  int
+		tenantClaim     string
+		expectedSubject string // Empty if the request is not authenticated by a token.
+		expectedTenant  string
+	}{
+		{name: "Valid Token", authorization: "Bearer good", expectedStatus: http.StatusOK, expectedSubject: "user-1", expectedTenant: auth.DefaultTenant},
+		{name: "Scheme Is Case-Insensitive", authorization: "bearer good", expectedStatus: http.StatusOK, expectedSubject: "user-1", expectedTenant: auth.DefaultTenant},
+		{name: "Tenant Claim", authorization: "Bearer good", tenantClaim: "org", expectedStatus: http.StatusOK, expectedSubject: "user-1", expectedTenant: "acme"},
+		{name: "Missing Tenant Claim", authorization: "Bearer good", tenantClaim: "tenant", expectedStatus: http.StatusUnauthorized},
+		{name: "Invalid Token", authorization: "Bearer bad", expectedStatus: http.StatusUnauthorized},
+		{name: "Verifier Error", authorization: "Bearer other", expectedStatus: http.StatusServiceUnavailable},
+		{name: "No Token", expectedStatus: http.StatusOK},
+		{name: "Other Scheme", authorization: "Basic dXNlcjpwYXNz", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var claims auth.Claims
+			var identity auth.Identity
+			handler := JWTMiddleware(fakeVerifier{}, tc.tenantClaim)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ = auth.ClaimsFromContext(r.Context())
+				identity, _ = auth.FromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/receipts", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if claims.Subject != tc.expectedSubject {
+				t.Errorf("expected claims for %q, got %+v", tc.expectedSubject, claims)
+			}
+			if tc.expectedSubject != "" && (identity.ClientID != tc.expectedSubject || identity.Method != auth.MethodJWT || identity.TenantID != tc.expectedTenant) {
+				t.Errorf("unexpected identity: %+v", identity)
+			}
+			if tc.expectedStatus == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestJWTThenAPIKeyMiddleware(t *testing.T) {
+	// A request authenticated by a bearer token does not also need an API key.
+	handler := applyChain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		JWTMiddleware(fakeVerifier{}, ""),
+		APIKeyMiddleware(fakeAuthenticator{}, APIKeySettings{Required: true}),
+	)
+	req := httptest.NewRequest(http.MethodGet, "/receipts", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+// applyChain wraps the handler in the middleware, the first being the outermost.
+func applyChain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
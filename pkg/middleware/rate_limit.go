@@ -1,40 +1,231 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"net"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 	"time"
 
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/problem"
 	"receipt_processor/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 )
 
+// APIKeyHeader is the request header that carries a client's API key.
+const APIKeyHeader = "X-API-Key"
+
+// Rate limit key sources. A policy counts requests separately for each value of its key.
 const (
-	// maxRequests is the maximum number of requests allowed in the window period.
-	maxRequests = 5 // Keep it low to test the effects
-	// windowPeriod is the duration of the sliding window.
-	windowPeriod = time.Minute
+	// KeyIP keys requests on the client IP address.
+	KeyIP = "ip"
+	// KeyAPIKey keys requests on the client authenticated by APIKeyMiddleware, or the API key
+	// itself without the middleware, falling back to the client IP without one.
+	KeyAPIKey = "api_key"
+	// KeyHeaderPrefix keys requests on a request header, e.g. "header:X-Client-ID",
+	// falling back to the client IP without one.
+	KeyHeaderPrefix = "header:"
 )
 
-// RateLimitMiddleware enforces rate limiting using a sliding window algorithm with Redis.
-func RateLimitMiddleware(rateLimiter repository.IRateLimiterRepository) Middleware {
+// RateLimitPolicy limits the requests to a set of routes.
+type RateLimitPolicy struct {
+	Name string `mapstructure:"name"`
+	// Routes are the ServeMux patterns the policy applies to, e.g. "/receipts/{id}/points".
+	Routes   []string      `mapstructure:"routes"`
+	Requests int           `mapstructure:"requests"` // Maximum requests per window.
+	Window   time.Duration `mapstructure:"window"`
+	Key      string        `mapstructure:"key"` // KeyIP, KeyAPIKey or KeyHeaderPrefix followed by a header name.
+	// Algorithm is "sliding_window" (the default), "token_bucket" or "gcra".
+	Algorithm repository.RateLimitAlgorithm `mapstructure:"algorithm"`
+	// Burst is the number of requests that may be made at once with "token_bucket"
+	// or "gcra". It defaults to Requests.
+	Burst int `mapstructure:"burst"`
+}
+
+// limit returns the rate limit the policy applies to each key.
+func (p *RateLimitPolicy) limit() repository.RateLimit {
+	return repository.RateLimit{
+		Algorithm: p.Algorithm,
+		Requests:  p.Requests,
+		Window:    p.Window,
+		Burst:     p.Burst,
+	}
+}
+
+// RateLimitPolicies holds the configured policies. A route without a policy of its own
+// uses the default policy, if there is one, and is otherwise not limited.
+type RateLimitPolicies struct {
+	Default  *RateLimitPolicy
+	Policies []RateLimitPolicy
+}
+
+// DefaultRateLimitPolicies returns the policies used when none are configured:
+// five requests a minute per client IP on every route.
+func DefaultRateLimitPolicies() RateLimitPolicies {
+	return RateLimitPolicies{
+		Default: &RateLimitPolicy{Name: "default", Requests: 5, Window: time.Minute, Key: KeyIP},
+	}
+}
+
+// LoadRateLimitPolicies reads the policies from the "rate_limit.default" and
+// "rate_limit.policies" configuration keys, falling back to DefaultRateLimitPolicies.
+func LoadRateLimitPolicies() (RateLimitPolicies, error) {
+	if !viper.IsSet("rate_limit") {
+		return DefaultRateLimitPolicies(), nil
+	}
+
+	var policies RateLimitPolicies
+	if viper.IsSet("rate_limit.default") {
+		policies.Default = &RateLimitPolicy{}
+		if err := viper.UnmarshalKey("rate_limit.default", policies.Default); err != nil {
+			return RateLimitPolicies{}, fmt.Errorf("invalid default rate limit policy: %v", err)
+		}
+		if policies.Default.Name == "" {
+			policies.Default.Name = "default"
+		}
+	}
+	if err := viper.UnmarshalKey("rate_limit.policies", &policies.Policies); err != nil {
+		return RateLimitPolicies{}, fmt.Errorf("invalid rate limit policies: %v", err)
+	}
+	if err := policies.Validate(); err != nil {
+		return RateLimitPolicies{}, err
+	}
+	return policies, nil
+}
+
+// Validate checks that every policy is complete and that no route has two policies.
+func (p RateLimitPolicies) Validate() error {
+	all := p.Policies
+	if p.Default != nil {
+		all = append([]RateLimitPolicy{*p.Default}, all...)
+	}
+	names := make(map[string]bool)
+	routes := make(map[string]string)
+	for _, policy := range all {
+		if policy.Name == "" {
+			return fmt.Errorf("rate limit policy without a name")
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("duplicate rate limit policy %q", policy.Name)
+		}
+		names[policy.Name] = true
+		if policy.Requests <= 0 || policy.Window <= 0 {
+			return fmt.Errorf("rate limit policy %q: requests and window must be positive", policy.Name)
+		}
+		switch policy.Algorithm {
+		case "", repository.SlidingWindow, repository.TokenBucket, repository.GCRA:
+		default:
+			return fmt.Errorf("rate limit policy %q: unknown algorithm %q", policy.Name, policy.Algorithm)
+		}
+		if policy.Burst < 0 {
+			return fmt.Errorf("rate limit policy %q: burst must not be negative", policy.Name)
+		}
+		if policy.Key != KeyIP && policy.Key != KeyAPIKey &&
+			!(strings.HasPrefix(policy.Key, KeyHeaderPrefix) && len(policy.Key) > len(KeyHeaderPrefix)) {
+			return fmt.Errorf("rate limit policy %q: unknown key %q", policy.Name, policy.Key)
+		}
+		for _, route := range policy.Routes {
+			if other, ok := routes[route]; ok {
+				return fmt.Errorf("route %s has rate limit policies %q and %q", route, other, policy.Name)
+			}
+			routes[route] = policy.Name
+		}
+	}
+	return nil
+}
+
+// forRoute returns the policy for the ServeMux pattern, or nil if the route is not limited.
+func (p RateLimitPolicies) forRoute(pattern string) *RateLimitPolicy {
+	for i := range p.Policies {
+		for _, route := range p.Policies[i].Routes {
+			if route == pattern {
+				return &p.Policies[i]
+			}
+		}
+	}
+	return p.Default
+}
+
+// identity returns the value the policy counts requests against.
+func (p *RateLimitPolicy) identity(r *http.Request) string {
+	switch {
+	case p.Key == KeyAPIKey:
+		if identity, ok := auth.FromContext(r.Context()); ok {
+			return "client:" + identity.ClientID
+		}
+		if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+			// Without APIKeyMiddleware, count the key itself, but never store it in Redis.
+			sum := sha256.Sum256([]byte(apiKey))
+			return "api_key:" + hex.EncodeToString(sum[:])
+		}
+	case strings.HasPrefix(p.Key, KeyHeaderPrefix):
+		name := strings.TrimPrefix(p.Key, KeyHeaderPrefix)
+		if value := r.Header.Get(name); value != "" {
+			return "header:" + value
+		}
+	}
+	return "ip:" + rateLimitIP(ClientIP(r))
+}
+
+// rateLimitIP returns the address a client IP is counted under. An IPv6 client is usually
+// assigned a whole /64 and can use any address in it, so it is counted by its /64.
+func rateLimitIP(addr netip.Addr) string {
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(64)
+		return prefix.String()
+	}
+	return addr.String()
+}
+
+// RateLimitMiddleware enforces the rate limit policy of each route. Routes are identified
+// by their ServeMux pattern, so the middleware must be applied to handlers registered on a
+// ServeMux. Clients are identified by the IP resolved by ClientIPMiddleware, which must run
+// first. Responses carry RateLimit-Limit and RateLimit-Remaining headers, and rejected
+// requests a Retry-After header. The headers are left out while the rate limiter is
+// degraded, and requests it rejects for being unavailable get a 503.
+func RateLimitMiddleware(rateLimiter repository.IRateLimiterRepository, policies RateLimitPolicies) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			host, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				host = strings.Split(r.RemoteAddr, ":")[0]
+			policy := policies.forRoute(r.Pattern)
+			if policy == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := fmt.Sprintf("rate_limit:%s:%s", policy.Name, policy.identity(r))
+			if policy.Algorithm != "" && policy.Algorithm != repository.SlidingWindow {
+				// Each algorithm stores different state, so they must not share keys.
+				key = fmt.Sprintf("rate_limit:%s:%s:%s", policy.Name, policy.Algorithm, policy.identity(r))
 			}
-			// Use the remote IP address as a unique key.
-			key := fmt.Sprintf("rate_limit:%s", host)
 
-			allowed, err := rateLimiter.AllowRequest(r.Context(), key, windowPeriod, maxRequests)
+			result, err := rateLimiter.AllowRequest(r.Context(), key, policy.limit())
+			if errors.Is(err, repository.ErrRateLimiterUnavailable) {
+				problem.Write(w, r, problem.New(http.StatusServiceUnavailable, "Rate limiting is temporarily unavailable. Try again later."))
+				return
+			}
 			if err != nil {
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				log.Ctx(r.Context()).Error().Err(err).Msg("Failed to check rate limit")
+				problem.Write(w, r, problem.New(http.StatusInternalServerError, ""))
 				return
 			}
-			if !allowed {
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			if !result.Degraded {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Requests))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			}
+			if !result.Allowed {
+				retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				problem.Write(w, r, problem.New(http.StatusTooManyRequests, "Rate limit exceeded. Try again later."))
 				return
 			}
 			next.ServeHTTP(w, r)
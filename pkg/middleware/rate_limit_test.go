@@ -0,0 +1,322 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/problem"
+	"receipt_processor/pkg/repository"
+
+	"github.com/spf13/viper"
+)
+
+// fakeRateLimiter is a fake implementation of repository.IRateLimiterRepository for testing.
+// It records the key and limit of the last request.
+type fakeRateLimiter struct {
+	result repository.RateLimitResult
+	err    error
+
+	key   string
+	limit repository.RateLimit
+}
+
+func (f *fakeRateLimiter) AllowRequest(ctx context.Context, key string, limit repository.RateLimit) (repository.RateLimitResult, error) {
+	f.key, f.limit = key, limit
+	return f.result, f.err
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	// Define a dummy handler that simply returns 200 OK.
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Table-driven test cases.
+	testCases := []struct {
+		name               string
+		limiter            *fakeRateLimiter
+		expectedStatus     int
+		expectedRemaining  string
+		expectedRetryAfter string
+	}{
+		{
+			name:              "Allowed",
+			limiter:           &fakeRateLimiter{result: repository.RateLimitResult{Allowed: true, Remaining: 3}},
+			expectedStatus:    http.StatusOK,
+			expectedRemaining: "3",
+		},
+		{
+			name:               "Limit Exceeded",
+			limiter:            &fakeRateLimiter{result: repository.RateLimitResult{RetryAfter: 1500 * time.Millisecond}},
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedRemaining:  "0",
+			expectedRetryAfter: "2",
+		},
+		{
+			name:           "Limiter Error",
+			limiter:        &fakeRateLimiter{err: errors.New("redis down")},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "Limiter Unavailable",
+			limiter:        &fakeRateLimiter{err: repository.ErrRateLimiterUnavailable},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "Degraded",
+			limiter:        &fakeRateLimiter{result: repository.RateLimitResult{Allowed: true, Degraded: true}},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/receipts/id/points", nil)
+			rr := httptest.NewRecorder()
+			RateLimitMiddleware(tc.limiter, DefaultRateLimitPolicies())(dummyHandler).ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if tc.expectedRemaining == "" {
+				if got := rr.Header().Get("RateLimit-Remaining"); got != "" {
+					t.Errorf("expected no RateLimit-Remaining header, got %q", got)
+				}
+			} else {
+				if got := rr.Header().Get("RateLimit-Limit"); got != "5" {
+					t.Errorf("expected RateLimit-Limit 5, got %q", got)
+				}
+				if got := rr.Header().Get("RateLimit-Remaining"); got != tc.expectedRemaining {
+					t.Errorf("expected RateLimit-Remaining %s, got %q", tc.expectedRemaining, got)
+				}
+			}
+			if got := rr.Header().Get("Retry-After"); got != tc.expectedRetryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tc.expectedRetryAfter, got)
+			}
+			if tc.expectedStatus == http.StatusOK {
+				return
+			}
+
+			// Errors are reported as problem details.
+			if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("expected Content-Type %q, got %q", problem.ContentType, ct)
+			}
+			var p problem.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem: %v", err)
+			}
+			if p.Status != tc.expectedStatus || p.Type != problem.TypeBlank || p.Instance != "/receipts/id/points" {
+				t.Errorf("unexpected problem: %+v", p)
+			}
+		})
+	}
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	policies := RateLimitPolicies{
+		Policies: []RateLimitPolicy{
+			{Name: "process", Routes: []string{"/receipts/process"}, Requests: 2, Window: time.Minute, Key: KeyAPIKey},
+			{Name: "points", Routes: []string{"/receipts/{id}/points"}, Requests: 10, Window: time.Second, Key: "header:X-Client-ID"},
+			{Name: "list", Routes: []string{"/receipts"}, Requests: 100, Window: time.Minute, Key: KeyIP, Algorithm: repository.GCRA, Burst: 20},
+		},
+	}
+	limiter := &fakeRateLimiter{result: repository.RateLimitResult{Allowed: true}}
+
+	// The middleware identifies routes by their ServeMux pattern.
+	mux := http.NewServeMux()
+	handler := RateLimitMiddleware(limiter, policies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, pattern := range []string{"/receipts/process", "/receipts/{id}/points", "/receipts/{id}", "/receipts"} {
+		mux.Handle(pattern, handler)
+	}
+
+	testCases := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		remoteAddr     string
+		identity       *auth.Identity // Set by APIKeyMiddleware.
+		expectedKey    string         // Empty if the route is not limited.
+		expectedWindow time.Duration
+		expectedLimit  int
+	}{
+		{
+			name:           "API Key",
+			path:           "/receipts/process",
+			headers:        map[string]string{APIKeyHeader: "secret"},
+			expectedKey:    "rate_limit:process:api_key:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+			expectedWindow: time.Minute,
+			expectedLimit:  2,
+		},
+		{
+			name:           "Authenticated Client",
+			path:           "/receipts/process",
+			headers:        map[string]string{APIKeyHeader: "secret"},
+			identity:       &auth.Identity{ClientID: "client-1", Method: auth.MethodAPIKey},
+			expectedKey:    "rate_limit:process:client:client-1",
+			expectedWindow: time.Minute,
+			expectedLimit:  2,
+		},
+		{
+			name:           "API Key Falls Back To IP",
+			path:           "/receipts/process",
+			expectedKey:    "rate_limit:process:ip:192.0.2.1",
+			expectedWindow: time.Minute,
+			expectedLimit:  2,
+		},
+		{
+			name:           "Header",
+			path:           "/receipts/abc/points",
+			headers:        map[string]string{"X-Client-ID": "client-7"},
+			expectedKey:    "rate_limit:points:header:client-7",
+			expectedWindow: time.Second,
+			expectedLimit:  10,
+		},
+		{
+			name:           "Algorithm",
+			path:           "/receipts",
+			expectedKey:    "rate_limit:list:gcra:ip:192.0.2.1",
+			expectedWindow: time.Minute,
+			expectedLimit:  100,
+		},
+		{
+			name:           "IPv6 Counted By /64",
+			path:           "/receipts/process",
+			remoteAddr:     "[2001:db8:1:2:3:4:5:6]:4711",
+			expectedKey:    "rate_limit:process:ip:2001:db8:1:2::/64",
+			expectedWindow: time.Minute,
+			expectedLimit:  2,
+		},
+		{
+			name: "No Policy",
+			path: "/receipts/abc",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter.key = ""
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			if tc.identity != nil {
+				req = req.WithContext(auth.NewContext(req.Context(), *tc.identity))
+			}
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			if limiter.key != tc.expectedKey {
+				t.Errorf("expected key %q, got %q", tc.expectedKey, limiter.key)
+			}
+			if tc.expectedKey == "" {
+				if rr.Header().Get("RateLimit-Limit") != "" {
+					t.Errorf("expected no rate limit headers on an unlimited route")
+				}
+				return
+			}
+			if limiter.limit.Window != tc.expectedWindow || limiter.limit.Requests != tc.expectedLimit {
+				t.Errorf("expected %d requests per %v, got %d per %v", tc.expectedLimit, tc.expectedWindow, limiter.limit.Requests, limiter.limit.Window)
+			}
+		})
+	}
+}
+
+func TestLoadRateLimitPolicies(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	// Without configuration, the default policy is used.
+	viper.Reset()
+	policies, err := LoadRateLimitPolicies()
+	if err != nil {
+		t.Fatalf("failed to load default policies: %v", err)
+	}
+	if policies.Default == nil || policies.Default.Requests != 5 || len(policies.Policies) != 0 {
+		t.Errorf("unexpected default policies: %+v", policies)
+	}
+
+	viper.SetConfigType("yaml")
+	config := `
+rate_limit:
+  default:
+    requests: 100
+    window: 1h
+    key: ip
+  policies:
+    - name: process
+      routes: ["/receipts/process"]
+      requests: 3
+      window: 30s
+      key: header:X-Client-ID
+      algorithm: token_bucket
+      burst: 10
+`
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	policies, err = LoadRateLimitPolicies()
+	if err != nil {
+		t.Fatalf("failed to load configured policies: %v", err)
+	}
+	if policies.Default.Name != "default" || policies.Default.Window != time.Hour {
+		t.Errorf("unexpected default policy: %+v", policies.Default)
+	}
+	if p := policies.forRoute("/receipts/process"); p == nil || p.Requests != 3 || p.Window != 30*time.Second ||
+		p.Algorithm != repository.TokenBucket || p.Burst != 10 {
+		t.Errorf("unexpected process policy: %+v", p)
+	}
+	if p := policies.forRoute("/receipts"); p != policies.Default {
+		t.Errorf("expected the default policy, got %+v", p)
+	}
+}
+
+func TestRateLimitPoliciesValidate(t *testing.T) {
+	valid := RateLimitPolicy{Name: "a", Routes: []string{"/a"}, Requests: 1, Window: time.Second, Key: KeyIP}
+
+	testCases := []struct {
+		name   string
+		modify func(p *RateLimitPolicy)
+		second bool // Add a second, otherwise identical policy.
+	}{
+		{name: "Missing Name", modify: func(p *RateLimitPolicy) { p.Name = "" }},
+		{name: "Zero Requests", modify: func(p *RateLimitPolicy) { p.Requests = 0 }},
+		{name: "Zero Window", modify: func(p *RateLimitPolicy) { p.Window = 0 }},
+		{name: "Unknown Key", modify: func(p *RateLimitPolicy) { p.Key = "cookie" }},
+		{name: "Unknown Algorithm", modify: func(p *RateLimitPolicy) { p.Algorithm = "leaky_bucket" }},
+		{name: "Negative Burst", modify: func(p *RateLimitPolicy) { p.Algorithm, p.Burst = repository.TokenBucket, -1 }},
+		{name: "Empty Header", modify: func(p *RateLimitPolicy) { p.Key = KeyHeaderPrefix }},
+		{name: "Duplicate Name", modify: func(p *RateLimitPolicy) {}, second: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := valid
+			tc.modify(&policy)
+			policies := RateLimitPolicies{Policies: []RateLimitPolicy{policy}}
+			if tc.second {
+				policies.Policies = append(policies.Policies, policy)
+			}
+			if err := policies.Validate(); err == nil {
+				t.Errorf("expected error but got nil")
+			}
+		})
+	}
+
+	// The same route may not have two policies.
+	other := valid
+	other.Name = "b"
+	if err := (RateLimitPolicies{Policies: []RateLimitPolicy{valid, other}}).Validate(); err == nil {
+		t.Errorf("expected error for a route with two policies")
+	}
+	if err := (RateLimitPolicies{Policies: []RateLimitPolicy{valid}}).Validate(); err != nil {
+		t.Errorf("did not expect error but got: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Cents is an exact amount of money in integer cents.
+type Cents int64
+
+// Parse converts a decimal string such as "35.35", "9" or "-1.5" into Cents.
+// At most two decimal places are accepted, so no rounding ever takes place.
+func Parse(s string) (Cents, error) {
+	digits := s
+	negative := strings.HasPrefix(digits, "-")
+	if negative {
+		digits = digits[1:]
+	}
+
+	whole, frac, hasFrac := strings.Cut(digits, ".")
+	if whole == "" || !isDigits(whole) || (hasFrac && (len(frac) == 0 || len(frac) > 2 || !isDigits(frac))) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	dollars, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || dollars > (1<<63-1)/100-1 {
+		return 0, fmt.Errorf("amount %q out of range", s)
+	}
+	cents, _ := strconv.ParseInt(frac, 10, 64)
+
+	amount := Cents(dollars*100 + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// MustParse is like Parse but panics if the amount is invalid.
+// It is intended for constants and tests.
+func MustParse(s string) Cents {
+	c, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// String formats the amount with exactly two decimal places, e.g. "35.35".
+func (c Cents) String() string {
+	sign := ""
+	abs := uint64(c)
+	if c < 0 {
+		sign = "-"
+		abs = uint64(-c)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+// MarshalJSON encodes the amount as a decimal string, e.g. "35.35".
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(c.String())), nil
+}
+
+// UnmarshalJSON decodes an amount from a decimal string, e.g. "35.35".
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		return fmt.Errorf("amount must be a JSON string: %s", data)
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// IsWholeDollar reports whether the amount has no cents.
+func (c Cents) IsWholeDollar() bool {
+	return c%100 == 0
+}
+
+// IsMultipleOf reports whether the amount is an exact multiple of m.
+func (c Cents) IsMultipleOf(m Cents) bool {
+	return m != 0 && c%m == 0
+}
+
+// MulCeil multiplies the amount by num/den and returns the result in whole
+// dollars, rounded up. den must be positive. The computation is exact; for
+// example, 12.25 multiplied by 2/10 is 2.45, which rounds up to 3. The product
+// of a large amount and num does not fit in an int64, so it is computed with
+// big integers, and results beyond the range of an int64 are clamped to it.
+func (c Cents) MulCeil(num, den int64) int64 {
+	product := new(big.Int).Mul(big.NewInt(int64(c)), big.NewInt(num))
+	divisor := new(big.Int).Mul(big.NewInt(den), big.NewInt(100))
+	// QuoRem truncates towards zero, so a positive quotient with a remainder is rounded up.
+	q, r := new(big.Int).QuoRem(product, divisor, new(big.Int))
+	if r.Sign() > 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	switch {
+	case q.IsInt64():
+		return q.Int64()
+	case q.Sign() > 0:
+		return math.MaxInt64
+	default:
+		return math.MinInt64
+	}
+}
+
+// isDigits reports whether s consists only of ASCII digits.
+func isDigits(s string) bool {
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expected    Cents
+		expectError bool
+	}{
+		{input: "35.35", expected: 3535},
+		{input: "9.00", expected: 900},
+		{input: "0.01", expected: 1},
+		{input: "12", expected: 1200},
+		{input: "1.5", expected: 150},
+		{input: "-2.25", expected: -225},
+		{input: "", expectError: true},
+		{input: "abc", expectError: true},
+		{input: "1.", expectError: true},
+		{input: ".50", expectError: true},
+		{input: "1.005", expectError: true},
+		{input: "1e3", expectError: true},
+		{input: "+1.00", expectError: true},
+		{input: "99999999999999999999.00", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := Parse(tc.input)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error but got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Price Cents `json:"price"`
+	}{Price: 1225})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	if string(data) != `{"price":"12.25"}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	var decoded struct {
+		Price Cents `json:"price"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if decoded.Price != 1225 {
+		t.Errorf("expected 1225, got %d", decoded.Price)
+	}
+	if err := json.Unmarshal([]byte(`{"price":12.25}`), &decoded); err == nil {
+		t.Errorf("expected error for a numeric amount, got nil")
+	}
+}
+
+// The properties below are checked against arbitrary amounts with testing/quick.
+
+func TestStringParseRoundTrip(t *testing.T) {
+	property := func(c int64) bool {
+		amount := Cents(c / 1000) // Keep well within range.
+		parsed, err := Parse(amount.String())
+		return err == nil && parsed == amount
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseMatchesDecimal(t *testing.T) {
+	// Any dollars and cents written as "D.CC" parse to exactly D*100 + CC.
+	property := func(dollars uint32, cents uint8) bool {
+		cc := int64(cents % 100)
+		parsed, err := Parse(fmt.Sprintf("%d.%02d", dollars, cc))
+		return err == nil && parsed == Cents(int64(dollars)*100+cc)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsWholeDollarAndMultiple(t *testing.T) {
+	property := func(dollars uint32, cents uint8) bool {
+		cc := int64(cents % 100)
+		amount := Cents(int64(dollars)*100 + cc)
+		whole := amount.IsWholeDollar() == (cc == 0)
+		quarter := amount.IsMultipleOf(25) == (cc == 0 || cc == 25 || cc == 50 || cc == 75)
+		return whole && quarter
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMulCeil(t *testing.T) {
+	// MulCeil must agree with exact rational arithmetic: the result is the
+	// smallest integer not less than amount * num / den.
+	property := func(c int32, num uint16, den uint16) bool {
+		if den == 0 {
+			den = 1
+		}
+		amount := Cents(c)
+		got := amount.MulCeil(int64(num), int64(den))
+
+		exact := new(big.Rat).SetFrac64(int64(c)*int64(num), int64(den)*100)
+		gotRat := new(big.Rat).SetInt64(got)
+		below := new(big.Rat).SetInt64(got - 1)
+		return gotRat.Cmp(exact) >= 0 && below.Cmp(exact) < 0
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMulCeilExamples(t *testing.T) {
+	testCases := []struct {
+		price    string
+		num, den int64
+		expected int64
+	}{
+		{price: "12.25", num: 2, den: 10, expected: 3},     // 2.45
+		{price: "12.00", num: 2, den: 10, expected: 3},     // 2.40
+		{price: "5.00", num: 2, den: 10, expected: 1},      // Exactly 1.
+		{price: "100.00", num: 55, den: 100, expected: 55}, // float64 gives 56.
+		{price: "0.00", num: 2, den: 10, expected: 0},
+		// Products beyond the range of an int64 are still exact.
+		{price: "92233720368547.58", num: 2000, den: 10000, expected: 18446744073710},
+		{price: "92233720368547757.99", num: 2000, den: 10000, expected: 18446744073709552},
+		{price: "-92233720368547757.99", num: 2000, den: 10000, expected: -18446744073709551},
+		// Results beyond the range of an int64 are clamped.
+		{price: "92233720368547757.99", num: math.MaxInt64, den: 1, expected: math.MaxInt64},
+		{price: "-92233720368547757.99", num: math.MaxInt64, den: 1, expected: math.MinInt64},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.price, func(t *testing.T) {
+			if got := MustParse(tc.price).MulCeil(tc.num, tc.den); got != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
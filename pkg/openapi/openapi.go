@@ -0,0 +1,259 @@
+package openapi
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"receipt_processor/pkg/problem"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the OpenAPI document describing the API, as served at /openapi.yaml.
+//
+//go:embed openapi.yaml
+var Spec []byte
+
+// Document is the subset of an OpenAPI 3 document used to validate requests.
+type Document struct {
+	Paths      map[string]PathItem `yaml:"paths"`
+	Components struct {
+		Parameters map[string]*Parameter `yaml:"parameters"`
+		Schemas    map[string]*Schema    `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+// PathItem maps lower-case HTTP methods to the operations of a path.
+type PathItem map[string]*Operation
+
+// Operation describes a single API operation.
+type Operation struct {
+	OperationID string       `yaml:"operationId"`
+	Parameters  []*Parameter `yaml:"parameters"`
+	RequestBody *RequestBody `yaml:"requestBody"`
+}
+
+// Parameter describes a path or query parameter.
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+// RequestBody describes the request body of an operation, by media type.
+type RequestBody struct {
+	Required bool `yaml:"required"`
+	Content  map[string]struct {
+		Schema *Schema `yaml:"schema"`
+	} `yaml:"content"`
+}
+
+// Load parses the embedded document and resolves its references.
+func Load() (*Document, error) {
+	return Parse(Spec)
+}
+
+// MustLoad is like Load but panics if the embedded document is invalid.
+func MustLoad() *Document {
+	doc, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// Parse parses an OpenAPI document and resolves its references.
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %v", err)
+	}
+	r := resolver{doc: &doc, done: make(map[*Schema]bool)}
+	for name, schema := range doc.Components.Schemas {
+		if err := r.resolve(schema); err != nil {
+			return nil, fmt.Errorf("schema %s: %v", name, err)
+		}
+	}
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			if err := r.resolveOperation(op); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", strings.ToUpper(method), path, err)
+			}
+		}
+	}
+	return &doc, nil
+}
+
+// Operation returns the operation for the method and path template, such as
+// "/receipts/{id}", or nil if the document does not define it.
+func (d *Document) Operation(method, path string) *Operation {
+	return d.Paths[path][strings.ToLower(method)]
+}
+
+// Methods returns the HTTP methods defined for the path template.
+func (d *Document) Methods(path string) []string {
+	var methods []string
+	for method := range d.Paths[path] {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// BodySchema returns the schema of the request body for the media type, or nil if there is none.
+func (o *Operation) BodySchema(mediaType string) *Schema {
+	if o.RequestBody == nil {
+		return nil
+	}
+	return o.RequestBody.Content[mediaType].Schema
+}
+
+// ValidateParameters checks the path, query and header parameters of a request against the
+// operation. Query parameters and headers that the operation does not define are ignored.
+func (o *Operation) ValidateParameters(pathParams map[string]string, query url.Values, header http.Header) []problem.FieldError {
+	var errs []problem.FieldError
+	for _, param := range o.Parameters {
+		var raw string
+		var present bool
+		switch param.In {
+		case "path":
+			raw, present = pathParams[param.Name]
+		case "query":
+			present = query.Has(param.Name)
+			raw = query.Get(param.Name)
+		case "header":
+			values := header.Values(param.Name)
+			present = len(values) > 0
+			if present {
+				raw = values[0]
+			}
+		}
+		if !present {
+			if param.Required {
+				errs = append(errs, problem.FieldError{Parameter: param.Name, Detail: "is required"})
+			}
+			continue
+		}
+		if param.Schema == nil {
+			continue
+		}
+		value, err := param.Schema.coerce(raw)
+		if err != nil {
+			errs = append(errs, problem.FieldError{Parameter: param.Name, Detail: err.Error()})
+			continue
+		}
+		for _, e := range param.Schema.Validate(value, "") {
+			errs = append(errs, problem.FieldError{Parameter: param.Name, Detail: e.Detail})
+		}
+	}
+	return errs
+}
+
+// resolver replaces references with the components they refer to.
+type resolver struct {
+	doc  *Document
+	done map[*Schema]bool
+}
+
+func (r *resolver) resolveOperation(op *Operation) error {
+	for i, param := range op.Parameters {
+		if param.Ref != "" {
+			name, ok := strings.CutPrefix(param.Ref, "#/components/parameters/")
+			target := r.doc.Components.Parameters[name]
+			if !ok || target == nil {
+				return fmt.Errorf("unresolved reference %q", param.Ref)
+			}
+			op.Parameters[i] = target
+			param = target
+		}
+		if param.Schema != nil {
+			if err := r.resolve(param.Schema); err != nil {
+				return fmt.Errorf("parameter %s: %v", param.Name, err)
+			}
+		}
+	}
+	if op.RequestBody != nil {
+		for mediaType, content := range op.RequestBody.Content {
+			if content.Schema == nil {
+				continue
+			}
+			if err := r.resolve(content.Schema); err != nil {
+				return fmt.Errorf("request body %s: %v", mediaType, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve links the references within the schema and compiles its patterns.
+func (r *resolver) resolve(s *Schema) error {
+	if r.done[s] {
+		return nil
+	}
+	r.done[s] = true
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		target := r.doc.Components.Schemas[name]
+		if !ok || target == nil {
+			return fmt.Errorf("unresolved reference %q", s.Ref)
+		}
+		s.target = target
+		return r.resolve(target)
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+	for _, name := range s.Properties.names {
+		if err := r.resolve(s.Properties.schemas[name]); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	if s.Items != nil {
+		if err := r.resolve(s.Items); err != nil {
+			return err
+		}
+	}
+	for _, sub := range s.AllOf {
+		if err := r.resolve(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coerce converts a parameter value to the type required by the schema.
+func (s *Schema) coerce(raw string) (interface{}, error) {
+	switch s.resolved().Type {
+	case "integer":
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return float64(v), nil
+	case "number":
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return v, nil
+	case "boolean":
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return v, nil
+	}
+	return raw, nil
+}
@@ -0,0 +1,166 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/problem"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatalf("failed to load embedded spec: %v", err)
+	}
+	op := doc.Operation(http.MethodPost, "/receipts/process")
+	if op == nil || op.OperationID != "processReceipt" {
+		t.Fatalf("expected the processReceipt operation, got %+v", op)
+	}
+	if doc.Operation(http.MethodDelete, "/receipts/process") != nil {
+		t.Errorf("expected no DELETE operation")
+	}
+	if schema := op.BodySchema("application/json"); schema == nil || schema.Property("items") == nil {
+		t.Errorf("expected the Receipt schema to be resolved")
+	}
+	if methods := doc.Methods("/receipts/{id}"); !reflect.DeepEqual(methods, []string{"GET"}) {
+		t.Errorf("expected [GET], got %v", methods)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec string
+	}{
+		{name: "Invalid YAML", spec: "paths: ["},
+		{name: "Unresolved schema", spec: `
+components:
+  schemas:
+    A:
+      $ref: "#/components/schemas/B"
+`},
+		{name: "Unresolved parameter", spec: `
+paths:
+  /a:
+    get:
+      parameters:
+        - $ref: "#/components/parameters/Missing"
+`},
+		{name: "Invalid pattern", spec: `
+components:
+  schemas:
+    A:
+      type: string
+      pattern: "("
+`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tc.spec)); err == nil {
+				t.Errorf("expected error but got nil")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := MustLoad().Components.Schemas["Receipt"]
+
+	testCases := []struct {
+		name     string
+		body     string
+		expected []problem.FieldError
+	}{
+		{
+			name: "Valid",
+			body: `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "1.00", "items": [{"shortDescription": "A", "price": "1.00"}]}`,
+		},
+		{
+			name: "Every Violation",
+			body: `{"retailer": "Tar*get", "purchaseDate": "2022-02-30", "purchaseTime": "24:00", "total": 1, "items": [{"price": "1.00"}, "item"]}`,
+			expected: []problem.FieldError{
+				{Pointer: "/retailer", Detail: `must match the pattern ^[\w\p{L}\p{M}\p{N}\s\-&#.']+$`},
+				{Pointer: "/purchaseDate", Detail: "must be a valid date in YYYY-MM-DD format"},
+				{Pointer: "/purchaseTime", Detail: "must match the pattern ^([01]\\d|2[0-3]):[0-5]\\d$"},
+				{Pointer: "/items/0/shortDescription", Detail: "is required"},
+				{Pointer: "/items/1", Detail: "must be an object"},
+				{Pointer: "/total", Detail: "must be a string"},
+			},
+		},
+		{
+			name: "Empty Items",
+			body: `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "1.00", "items": []}`,
+			expected: []problem.FieldError{
+				{Pointer: "/items", Detail: "must contain at least 1 item(s)"},
+			},
+		},
+		{
+			name:     "Not An Object",
+			body:     `[]`,
+			expected: []problem.FieldError{{Detail: "must be an object"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var value interface{}
+			decoder := json.NewDecoder(strings.NewReader(tc.body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&value); err != nil {
+				t.Fatalf("invalid test body: %v", err)
+			}
+			if errs := schema.Validate(value, ""); !reflect.DeepEqual(errs, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, errs)
+			}
+		})
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	doc := MustLoad()
+	list := doc.Operation(http.MethodGet, "/receipts")
+
+	query, _ := url.ParseQuery("limit=0&minPoints=ten&flagged=yes&minTotal=1.00&unknown=x")
+	expected := []problem.FieldError{
+		{Parameter: "minPoints", Detail: "must be an integer"},
+		{Parameter: "flagged", Detail: "must be a boolean"},
+		{Parameter: "limit", Detail: "must be at least 1"},
+	}
+	if errs := list.ValidateParameters(nil, query, nil); !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v, got %v", expected, errs)
+	}
+
+	batch := doc.Operation(http.MethodPost, "/receipts/batch")
+	errs := batch.ValidateParameters(nil, url.Values{"mode": {"all"}}, nil)
+	if len(errs) != 1 || errs[0].Detail != "must be one of: per_item, transaction" {
+		t.Errorf("expected an enum error, got %v", errs)
+	}
+
+	get := doc.Operation(http.MethodGet, "/receipts/{id}")
+	if errs := get.ValidateParameters(map[string]string{"id": "abc"}, nil, nil); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+	errs = get.ValidateParameters(nil, nil, nil)
+	if len(errs) != 1 || errs[0].Parameter != "id" || errs[0].Detail != "is required" {
+		t.Errorf("expected a missing id error, got %v", errs)
+	}
+	errs = get.ValidateParameters(map[string]string{"id": "a b"}, nil, nil)
+	if len(errs) != 1 || errs[0].Parameter != "id" {
+		t.Errorf("expected an invalid id error, got %v", errs)
+	}
+
+	redeem := doc.Operation(http.MethodPost, "/users/{id}/redemptions")
+	header := http.Header{}
+	header.Set("Idempotency-Key", "key-1")
+	if errs := redeem.ValidateParameters(map[string]string{"id": "user-1"}, nil, header); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+	errs = redeem.ValidateParameters(map[string]string{"id": "user-1"}, nil, http.Header{})
+	if len(errs) != 1 || errs[0].Parameter != "Idempotency-Key" || errs[0].Detail != "is required" {
+		t.Errorf("expected a missing header error, got %v", errs)
+	}
+}
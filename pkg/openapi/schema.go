@@ -0,0 +1,205 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"receipt_processor/pkg/problem"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is the subset of an OpenAPI schema object used to validate requests.
+type Schema struct {
+	Ref        string     `yaml:"$ref"`
+	Type       string     `yaml:"type"`
+	Format     string     `yaml:"format"`
+	Pattern    string     `yaml:"pattern"`
+	Enum       []string   `yaml:"enum"`
+	Minimum    *float64   `yaml:"minimum"`
+	Maximum    *float64   `yaml:"maximum"`
+	MinLength  *int       `yaml:"minLength"`
+	MaxLength  *int       `yaml:"maxLength"`
+	MinItems   *int       `yaml:"minItems"`
+	MaxItems   *int       `yaml:"maxItems"`
+	Required   []string   `yaml:"required"`
+	Properties Properties `yaml:"properties"`
+	Items      *Schema    `yaml:"items"`
+	AllOf      []*Schema  `yaml:"allOf"`
+
+	target  *Schema        // The schema referred to by Ref.
+	pattern *regexp.Regexp // The compiled Pattern.
+}
+
+// Properties holds the property schemas of an object, in document order.
+type Properties struct {
+	names   []string
+	schemas map[string]*Schema
+}
+
+// UnmarshalYAML decodes the properties, keeping the order they are declared in
+// so that validation errors are reported in a stable order.
+func (p *Properties) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("properties must be a mapping")
+	}
+	p.schemas = make(map[string]*Schema, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		var schema Schema
+		if err := node.Content[i+1].Decode(&schema); err != nil {
+			return err
+		}
+		p.names = append(p.names, name)
+		p.schemas[name] = &schema
+	}
+	return nil
+}
+
+// Property returns the schema of the named property, or nil if there is none.
+func (s *Schema) Property(name string) *Schema {
+	return s.resolved().Properties.schemas[name]
+}
+
+// resolved follows the schema's reference, if any.
+func (s *Schema) resolved() *Schema {
+	for s.target != nil {
+		s = s.target
+	}
+	return s
+}
+
+// Validate checks a value decoded from JSON against the schema. Numbers must be
+// decoded as json.Number or float64. Every violation is returned, identified by a
+// JSON pointer relative to the given pointer.
+func (s *Schema) Validate(value interface{}, pointer string) []problem.FieldError {
+	s = s.resolved()
+	var errs []problem.FieldError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, problem.FieldError{Pointer: pointer, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	for _, sub := range s.AllOf {
+		errs = append(errs, sub.Validate(value, pointer)...)
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return errs
+		}
+		for _, name := range s.Properties.names {
+			if v, ok := object[name]; ok {
+				errs = append(errs, s.Properties.schemas[name].Validate(v, pointer+"/"+escape(name))...)
+			}
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				errs = append(errs, problem.FieldError{Pointer: pointer + "/" + escape(name), Detail: "is required"})
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return errs
+		}
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			fail("must contain at least %d item(s)", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(array) > *s.MaxItems {
+			fail("must contain at most %d item(s)", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, v := range array {
+				errs = append(errs, s.Items.Validate(v, pointer+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return errs
+		}
+		if s.MinLength != nil && utf8.RuneCountInString(str) < *s.MinLength {
+			fail("must be at least %d character(s) long", *s.MinLength)
+		}
+		if s.MaxLength != nil && utf8.RuneCountInString(str) > *s.MaxLength {
+			fail("must be at most %d character(s) long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			fail("must match the pattern %s", s.Pattern)
+		}
+		if err := checkFormat(s.Format, str); err != nil {
+			fail("%v", err)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			fail("must be one of: %s", strings.Join(s.Enum, ", "))
+		}
+	case "integer", "number":
+		number, ok := toFloat(value)
+		if !ok || (s.Type == "integer" && number != float64(int64(number))) {
+			fail("must be a(n) %s", s.Type)
+			return errs
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+	return errs
+}
+
+// checkFormat validates the string formats used by the API.
+func checkFormat(format, s string) error {
+	switch format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return fmt.Errorf("must be a valid date in YYYY-MM-DD format")
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Errorf("must be a valid RFC 3339 date-time")
+		}
+	}
+	return nil
+}
+
+// toFloat converts a number decoded from JSON to a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// escape escapes a property name for use in a JSON pointer (RFC 6901).
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
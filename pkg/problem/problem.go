@@ -0,0 +1,96 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ContentType is the media type of an RFC 7807 problem details response.
+const ContentType = "application/problem+json"
+
+// Problem types used by the API. A problem of type "about:blank" has no
+// meaning beyond its HTTP status code.
+const (
+	TypeBlank         = "about:blank"
+	TypeValidation    = "/problems/validation"
+	TypeTotalMismatch = "/problems/total-mismatch"
+)
+
+// FieldError describes a single invalid field in a request body, or an invalid
+// path or query parameter.
+type FieldError struct {
+	Pointer   string `json:"pointer,omitempty"`   // JSON pointer to the field, e.g. "/items/3/price".
+	Parameter string `json:"parameter,omitempty"` // Name of the parameter, e.g. "limit".
+	Detail    string `json:"detail"`
+}
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// Extensions holds additional members, which are serialized alongside the standard ones.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// New creates a problem of type "about:blank" for the given status code.
+func New(status int, detail string) Problem {
+	return Problem{
+		Type:   TypeBlank,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Validation creates a 400 problem listing every invalid field.
+func Validation(detail string, errors []FieldError) Problem {
+	return Problem{
+		Type:   TypeValidation,
+		Title:  "Validation failed",
+		Status: http.StatusBadRequest,
+		Detail: detail,
+		Errors: errors,
+	}
+}
+
+// MarshalJSON serializes the problem, including its extension members.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type standard Problem
+	data, err := json.Marshal(standard(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+	members := make(map[string]interface{}, len(p.Extensions))
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	// Standard members take precedence over extensions with the same name.
+	for k, v := range fields {
+		members[k] = v
+	}
+	return json.Marshal(members)
+}
+
+// Write sends the problem as an application/problem+json response.
+// The request path is used as the instance if none is set.
+func Write(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Ctx(r.Context()).Error().Err(err).Msg("Failed to write problem response")
+	}
+}
@@ -0,0 +1,53 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/receipts/process", nil)
+	rec := httptest.NewRecorder()
+	Write(rec, req, Validation("The receipt is invalid.", []FieldError{
+		{Pointer: "/items/3/price", Detail: "invalid item price format"},
+	}))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected Content-Type %q, got %q", ContentType, ct)
+	}
+	expected := `{"type":"/problems/validation","title":"Validation failed","status":400,"detail":"The receipt is invalid.","instance":"/receipts/process","errors":[{"pointer":"/items/3/price","detail":"invalid item price format"}]}` + "\n"
+	if rec.Body.String() != expected {
+		t.Errorf("expected body %s, got %s", expected, rec.Body.String())
+	}
+}
+
+func TestMarshalJSONExtensions(t *testing.T) {
+	p := New(http.StatusBadRequest, "bad")
+	p.Extensions = map[string]interface{}{
+		"total":  "35.35",
+		"status": 999, // Standard members cannot be overridden.
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if members["total"] != "35.35" {
+		t.Errorf("expected extension member total, got %v", members["total"])
+	}
+	if members["status"] != float64(http.StatusBadRequest) {
+		t.Errorf("expected status 400, got %v", members["status"])
+	}
+	if members["title"] != "Bad Request" || members["type"] != TypeBlank {
+		t.Errorf("unexpected standard members: %v", members)
+	}
+}
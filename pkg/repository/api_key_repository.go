@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"receipt_processor/pkg/auth"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyModel represents an issued API key. Only a hash of the key is stored.
+type APIKeyModel struct {
+	ID        string `gorm:"primaryKey;type:varchar(36)"`
+	Name      string `gorm:"index"` // The client the key was issued to.
+	TenantID  string `gorm:"index"` // The tenant whose receipts the client may access.
+	Admin     bool   // Whether the client may use the admin API for its tenant.
+	Prefix    string // The first characters of the key, to recognize it by.
+	Hash      string `gorm:"uniqueIndex;not null"` // SHA-256 of the key, hex encoded.
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// IAPIKeyRepository defines the interface for interacting with API key persistence.
+type IAPIKeyRepository interface {
+	Save(ctx context.Context, key APIKeyModel) error
+	FindByHash(ctx context.Context, hash string) (APIKeyModel, error)
+	// List returns every key, revoked or not, oldest first.
+	List(ctx context.Context) ([]APIKeyModel, error)
+	// Revoke marks the key as revoked at the given time. Revoking a revoked key has no
+	// effect; revoking an unknown key returns gorm.ErrRecordNotFound.
+	Revoke(ctx context.Context, id string, at time.Time) error
+}
+
+// apiKeyRepository is a concrete implementation of IAPIKeyRepository using GORM.
+type apiKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewAPIKeyRepository creates a new instance of the API key repository.
+// It performs auto-migration to ensure the schema is up to date.
+func NewAPIKeyRepository(db *gorm.DB) IAPIKeyRepository {
+	db.AutoMigrate(&APIKeyModel{})
+	// Keys issued before tenants were introduced belong to the default tenant.
+	db.Model(&APIKeyModel{}).Where("tenant_id IS NULL OR tenant_id = ''").Update("tenant_id", auth.DefaultTenant)
+	return &apiKeyRepository{
+		db: db,
+	}
+}
+
+// Save stores an API key in the database.
+func (r *apiKeyRepository) Save(ctx context.Context, key APIKeyModel) error {
+	return r.db.WithContext(ctx).Create(&key).Error
+}
+
+// FindByHash retrieves an API key by the hash of the key. If not found, returns an error.
+func (r *apiKeyRepository) FindByHash(ctx context.Context, hash string) (APIKeyModel, error) {
+	var key APIKeyModel
+	result := r.db.WithContext(ctx).Where("hash = ?", hash).First(&key)
+	return key, result.Error
+}
+
+// List returns every API key in the order they were created.
+func (r *apiKeyRepository) List(ctx context.Context) ([]APIKeyModel, error) {
+	var keys []APIKeyModel
+	result := r.db.WithContext(ctx).Order("created_at").Order("id").Find(&keys)
+	return keys, result.Error
+}
+
+// Revoke sets the revocation time of a key that has not been revoked yet.
+func (r *apiKeyRepository) Revoke(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var key APIKeyModel
+		if err := tx.First(&key, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if key.RevokedAt != nil {
+			return nil
+		}
+		return tx.Model(&key).Update("revoked_at", at).Error
+	})
+}
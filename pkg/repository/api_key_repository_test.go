@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	db := newTestDB(t, "api_key_test")
+	repo := NewAPIKeyRepository(db)
+	ctx := context.Background()
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	keys := []APIKeyModel{
+		{ID: "key1", Name: "Acme", Prefix: "rp_aaaaaaaa", Hash: "hash1", CreatedAt: created},
+		{ID: "key2", Name: "Globex", Prefix: "rp_bbbbbbbb", Hash: "hash2", CreatedAt: created.Add(time.Hour)},
+	}
+	for _, key := range keys {
+		if err := repo.Save(ctx, key); err != nil {
+			t.Fatalf("failed to save key: %v", err)
+		}
+	}
+
+	// ---- Hashes are unique.
+	if err := repo.Save(ctx, APIKeyModel{ID: "key3", Name: "Initech", Hash: "hash1"}); err == nil {
+		t.Errorf("expected error saving a duplicate hash")
+	}
+
+	// ---- Keys are found by their hash.
+	found, err := repo.FindByHash(ctx, "hash2")
+	if err != nil || found.ID != "key2" || found.Name != "Globex" {
+		t.Errorf("unexpected key %+v, error %v", found, err)
+	}
+	if _, err := repo.FindByHash(ctx, "unknown"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected gorm.ErrRecordNotFound, got %v", err)
+	}
+
+	// ---- Revoking is recorded once.
+	revoked := created.Add(24 * time.Hour)
+	if err := repo.Revoke(ctx, "key1", revoked); err != nil {
+		t.Fatalf("failed to revoke key: %v", err)
+	}
+	if err := repo.Revoke(ctx, "key1", revoked.Add(time.Hour)); err != nil {
+		t.Fatalf("failed to revoke key again: %v", err)
+	}
+	if err := repo.Revoke(ctx, "unknown", revoked); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected gorm.ErrRecordNotFound, got %v", err)
+	}
+
+	// ---- Keys are listed oldest first.
+	list, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != "key1" || list[1].ID != "key2" {
+		t.Fatalf("unexpected keys: %+v", list)
+	}
+	if list[0].RevokedAt == nil || !list[0].RevokedAt.Equal(revoked) {
+		t.Errorf("expected key1 revoked at %v, got %v", revoked, list[0].RevokedAt)
+	}
+	if list[1].RevokedAt != nil {
+		t.Errorf("expected key2 not to be revoked")
+	}
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CampaignModel represents a promotional campaign that awards extra points for receipts
+// matching its retailer, purchase window and items. Deleted campaigns are kept, so the
+// receipts they awarded points to can still be rescored with them.
+type CampaignModel struct {
+	ID       string `gorm:"primaryKey;type:varchar(36)"`
+	TenantID string `gorm:"type:varchar(64);not null;index:idx_campaign_tenant_dates,priority:1"`
+	Name     string `gorm:"not null"`
+	Retailer string // Matches the normalized retailer name; empty matches every retailer.
+	// StartDate and EndDate bound the purchase dates, inclusive, as YYYY-MM-DD.
+	StartDate string `gorm:"type:varchar(10);not null;index:idx_campaign_tenant_dates,priority:2"`
+	EndDate   string `gorm:"type:varchar(10);not null;index:idx_campaign_tenant_dates,priority:3"`
+	// Weekdays restricts the purchase days, e.g. ["sat", "sun"]; empty for every day.
+	Weekdays []string `gorm:"serializer:json"`
+	// StartTime and EndTime restrict the purchase time to [StartTime, EndTime) as HH:MM;
+	// empty for the whole day.
+	StartTime string `gorm:"type:varchar(5)"`
+	EndTime   string `gorm:"type:varchar(5)"`
+	// ItemMatchers restricts the campaign to receipts with an item whose description
+	// contains one of them, case-insensitively; empty for every receipt.
+	ItemMatchers []string `gorm:"serializer:json"`
+	Multiplier   float64  // Multiplies the points awarded by the rules; 0 for none.
+	BonusPoints  int      // Added once, or once per matching item if there are item matchers.
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+}
+
+// ICampaignRepository defines the interface for interacting with campaign persistence.
+// Every method is scoped to a tenant.
+type ICampaignRepository interface {
+	Save(ctx context.Context, campaign CampaignModel) error
+	// Update replaces a campaign. It returns gorm.ErrRecordNotFound if it does not exist.
+	Update(ctx context.Context, campaign CampaignModel) error
+	// Delete deletes a campaign. It returns gorm.ErrRecordNotFound if it does not exist.
+	Delete(ctx context.Context, tenantID, id string) error
+	GetByID(ctx context.Context, tenantID, id string) (CampaignModel, error)
+	// List returns the tenant's campaigns, oldest first.
+	List(ctx context.Context, tenantID string) ([]CampaignModel, error)
+	// Running returns the tenant's campaigns whose dates include the purchase date
+	// (YYYY-MM-DD), oldest first.
+	Running(ctx context.Context, tenantID, purchaseDate string) ([]CampaignModel, error)
+}
+
+// campaignRepository is a concrete implementation of ICampaignRepository using GORM.
+type campaignRepository struct {
+	db *gorm.DB
+}
+
+// NewCampaignRepository creates a new instance of the campaign repository.
+// It performs auto-migration to ensure the schema is up to date.
+func NewCampaignRepository(db *gorm.DB) ICampaignRepository {
+	db.AutoMigrate(&CampaignModel{})
+	return &campaignRepository{
+		db: db,
+	}
+}
+
+// Save stores a new campaign in the database.
+func (r *campaignRepository) Save(ctx context.Context, campaign CampaignModel) error {
+	return r.db.WithContext(ctx).Create(&campaign).Error
+}
+
+// Update saves every field of an existing campaign, keeping its creation time.
+func (r *campaignRepository) Update(ctx context.Context, campaign CampaignModel) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing CampaignModel
+		if err := tx.First(&existing, "tenant_id = ? AND id = ?", campaign.TenantID, campaign.ID).Error; err != nil {
+			return err
+		}
+		campaign.CreatedAt = existing.CreatedAt
+		return tx.Save(&campaign).Error
+	})
+}
+
+// Delete soft-deletes the campaign.
+func (r *campaignRepository) Delete(ctx context.Context, tenantID, id string) error {
+	result := r.db.WithContext(ctx).Where("tenant_id = ? AND id = ?", tenantID, id).Delete(&CampaignModel{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// GetByID retrieves a campaign of the tenant by its ID.
+func (r *campaignRepository) GetByID(ctx context.Context, tenantID, id string) (CampaignModel, error) {
+	var campaign CampaignModel
+	result := r.db.WithContext(ctx).First(&campaign, "tenant_id = ? AND id = ?", tenantID, id)
+	return campaign, result.Error
+}
+
+// List returns every campaign of the tenant that has not been deleted.
+func (r *campaignRepository) List(ctx context.Context, tenantID string) ([]CampaignModel, error) {
+	var campaigns []CampaignModel
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at").Order("id").
+		Find(&campaigns)
+	return campaigns, result.Error
+}
+
+// Running compares the dates as strings, which sort like the dates they hold.
+func (r *campaignRepository) Running(ctx context.Context, tenantID, purchaseDate string) ([]CampaignModel, error) {
+	var campaigns []CampaignModel
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND start_date <= ? AND end_date >= ?", tenantID, purchaseDate, purchaseDate).
+		Order("created_at").Order("id").
+		Find(&campaigns)
+	return campaigns, result.Error
+}
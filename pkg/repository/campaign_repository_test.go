@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestCampaignRepository(t *testing.T) {
+	db := newTestDB(t, "campaign_test")
+	repo := NewCampaignRepository(db)
+	ctx := context.Background()
+
+	campaigns := []CampaignModel{
+		{ID: "december", TenantID: testTenant, Name: "December", StartDate: "2022-12-01", EndDate: "2022-12-31", Weekdays: []string{"sat", "sun"}, Multiplier: 2},
+		{ID: "xmas", TenantID: testTenant, Name: "Christmas", StartDate: "2022-12-24", EndDate: "2022-12-26", ItemMatchers: []string{"gatorade"}, BonusPoints: 10},
+		{ID: "other-tenant", TenantID: "tenant-b", Name: "December", StartDate: "2022-12-01", EndDate: "2022-12-31", Multiplier: 3},
+	}
+	for _, campaign := range campaigns {
+		if err := repo.Save(ctx, campaign); err != nil {
+			t.Fatalf("failed to save campaign: %v", err)
+		}
+	}
+
+	// The JSON serialized fields round trip.
+	saved, err := repo.GetByID(ctx, testTenant, "december")
+	if err != nil {
+		t.Fatalf("failed to get campaign: %v", err)
+	}
+	if len(saved.Weekdays) != 2 || saved.Weekdays[1] != "sun" || saved.Multiplier != 2 {
+		t.Errorf("expected the saved campaign, got %+v", saved)
+	}
+	if _, err := repo.GetByID(ctx, "tenant-b", "december"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected another tenant's campaign to be not found, got %v", err)
+	}
+
+	// Only the tenant's campaigns running on the purchase date are returned.
+	testCases := []struct {
+		purchaseDate string
+		expectedIDs  []string
+	}{
+		{purchaseDate: "2022-11-30"},
+		{purchaseDate: "2022-12-01", expectedIDs: []string{"december"}},
+		{purchaseDate: "2022-12-24", expectedIDs: []string{"december", "xmas"}},
+		{purchaseDate: "2022-12-26", expectedIDs: []string{"december", "xmas"}},
+		{purchaseDate: "2022-12-31", expectedIDs: []string{"december"}},
+	}
+	for _, tc := range testCases {
+		running, err := repo.Running(ctx, testTenant, tc.purchaseDate)
+		if err != nil {
+			t.Fatalf("failed to find running campaigns: %v", err)
+		}
+		if got := campaignIDs(running); got != strings.Join(tc.expectedIDs, ",") {
+			t.Errorf("%s: expected campaigns %v, got %s", tc.purchaseDate, tc.expectedIDs, got)
+		}
+	}
+
+	// Updating keeps the creation time; updating an unknown campaign fails.
+	update := saved
+	update.Multiplier = 3
+	update.Weekdays = nil
+	if err := repo.Update(ctx, update); err != nil {
+		t.Fatalf("failed to update campaign: %v", err)
+	}
+	updated, err := repo.GetByID(ctx, testTenant, "december")
+	if err != nil {
+		t.Fatalf("failed to get campaign: %v", err)
+	}
+	if updated.Multiplier != 3 || len(updated.Weekdays) != 0 || !updated.CreatedAt.Equal(saved.CreatedAt) {
+		t.Errorf("expected the updated campaign with its creation time, got %+v", updated)
+	}
+	if err := repo.Update(ctx, CampaignModel{ID: "december", TenantID: "tenant-c", Name: "December"}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected updating another tenant's campaign to fail, got %v", err)
+	}
+
+	// Deleted campaigns are no longer listed or running.
+	if err := repo.Delete(ctx, testTenant, "xmas"); err != nil {
+		t.Fatalf("failed to delete campaign: %v", err)
+	}
+	if err := repo.Delete(ctx, testTenant, "xmas"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected deleting again to fail, got %v", err)
+	}
+	listed, err := repo.List(ctx, testTenant)
+	if err != nil {
+		t.Fatalf("failed to list campaigns: %v", err)
+	}
+	if got := campaignIDs(listed); got != "december" {
+		t.Errorf("expected only december to be listed, got %s", got)
+	}
+	running, err := repo.Running(ctx, testTenant, "2022-12-25")
+	if err != nil {
+		t.Fatalf("failed to find running campaigns: %v", err)
+	}
+	if got := campaignIDs(running); got != "december" {
+		t.Errorf("expected only december to be running, got %s", got)
+	}
+}
+
+// campaignIDs joins the IDs of the campaigns with commas.
+func campaignIDs(campaigns []CampaignModel) string {
+	ids := make([]string, len(campaigns))
+	for i, campaign := range campaigns {
+		ids[i] = campaign.ID
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// FailureMode selects what happens to requests while the rate limiter is unavailable.
+type FailureMode string
+
+const (
+	// FailOpen allows every request without limiting it.
+	FailOpen FailureMode = "open"
+	// FailClosed rejects every request with ErrRateLimiterUnavailable.
+	FailClosed FailureMode = "closed"
+	// FailLocal limits requests with a fallback limiter local to this process.
+	FailLocal FailureMode = "local"
+)
+
+// ErrRateLimiterUnavailable is returned in FailClosed mode while the rate limiter is unavailable.
+var ErrRateLimiterUnavailable = errors.New("rate limiter unavailable")
+
+// Circuit breaker states, as reported by the "rate_limiter.breaker_state" metric.
+const (
+	breakerClosed   = "closed"    // Requests go to the rate limiter.
+	breakerOpen     = "open"      // Requests are handled by the failure mode.
+	breakerHalfOpen = "half_open" // A single trial request checks whether the rate limiter is back.
+)
+
+// rateLimiterMetrics are published through expvar under "rate_limiter".
+var rateLimiterMetrics = struct {
+	breakerState     *expvar.String
+	breakerOpened    *expvar.Int // Times the breaker has opened.
+	failures         *expvar.Int // Failed rate limiter calls.
+	degradedRequests *expvar.Int // Requests handled by the failure mode.
+}{new(expvar.String), new(expvar.Int), new(expvar.Int), new(expvar.Int)}
+
+func init() {
+	m := expvar.NewMap("rate_limiter")
+	m.Set("breaker_state", rateLimiterMetrics.breakerState)
+	m.Set("breaker_opened", rateLimiterMetrics.breakerOpened)
+	m.Set("failures", rateLimiterMetrics.failures)
+	m.Set("degraded_requests", rateLimiterMetrics.degradedRequests)
+	rateLimiterMetrics.breakerState.Set(breakerClosed)
+}
+
+// CircuitBreakerSettings configures a circuit breaker around a rate limiter.
+type CircuitBreakerSettings struct {
+	Mode FailureMode // What happens to requests while the rate limiter is unavailable.
+	// FailureThreshold is the number of consecutive failures that opens the breaker.
+	FailureThreshold int `mapstructure:"failure_threshold"`
+	// OpenTimeout is how long the breaker stays open before a trial request is let through.
+	OpenTimeout time.Duration `mapstructure:"open_timeout"`
+	// Timeout bounds each call to the rate limiter, so a slow backend counts as a failure.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Validate checks that the settings are complete.
+func (s CircuitBreakerSettings) Validate() error {
+	switch s.Mode {
+	case FailOpen, FailClosed, FailLocal:
+	default:
+		return fmt.Errorf("unknown rate limiter failure mode %q", s.Mode)
+	}
+	if s.FailureThreshold <= 0 || s.OpenTimeout <= 0 || s.Timeout <= 0 {
+		return fmt.Errorf("circuit breaker failure threshold, open timeout and timeout must be positive")
+	}
+	return nil
+}
+
+// circuitBreakerRateLimiterRepository stops calling a failing rate limiter for a while,
+// handling requests with the failure mode instead, so an outage does not slow down or
+// fail every request.
+type circuitBreakerRateLimiterRepository struct {
+	limiter  IRateLimiterRepository
+	fallback IRateLimiterRepository // Used in FailLocal mode.
+	settings CircuitBreakerSettings
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int       // Consecutive failures while closed.
+	openedAt time.Time // When the breaker last opened.
+}
+
+// NewCircuitBreakerRateLimiterRepository wraps the limiter in a circuit breaker. The fallback
+// limiter is only used in FailLocal mode and may be nil otherwise.
+func NewCircuitBreakerRateLimiterRepository(limiter, fallback IRateLimiterRepository, settings CircuitBreakerSettings) IRateLimiterRepository {
+	return newCircuitBreakerRateLimiterRepository(limiter, fallback, settings, time.Now)
+}
+
+func newCircuitBreakerRateLimiterRepository(limiter, fallback IRateLimiterRepository, settings CircuitBreakerSettings, now func() time.Time) *circuitBreakerRateLimiterRepository {
+	rateLimiterMetrics.breakerState.Set(breakerClosed)
+	return &circuitBreakerRateLimiterRepository{
+		limiter:  limiter,
+		fallback: fallback,
+		settings: settings,
+		now:      now,
+		state:    breakerClosed,
+	}
+}
+
+// AllowRequest checks the request with the rate limiter while it is available,
+// and with the failure mode otherwise.
+func (r *circuitBreakerRateLimiterRepository) AllowRequest(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	if !r.acquire() {
+		return r.degraded(ctx, key, limit)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, r.settings.Timeout)
+	result, err := r.limiter.AllowRequest(callCtx, key, limit)
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			// The client went away; that says nothing about the rate limiter.
+			r.release()
+			return RateLimitResult{}, err
+		}
+		r.failure(ctx, err)
+		return r.degraded(ctx, key, limit)
+	}
+	r.success(ctx)
+	return result, nil
+}
+
+// acquire reports whether the request may be checked by the rate limiter. Once the
+// breaker has been open for OpenTimeout, it lets a single trial request through.
+func (r *circuitBreakerRateLimiterRepository) acquire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if r.now().Sub(r.openedAt) >= r.settings.OpenTimeout {
+			r.setState(breakerHalfOpen)
+			return true
+		}
+	}
+	return false
+}
+
+// release returns the trial request of a half-open breaker without a verdict.
+func (r *circuitBreakerRateLimiterRepository) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == breakerHalfOpen {
+		r.setState(breakerOpen)
+	}
+}
+
+// success records a successful call, closing the breaker if it was half-open.
+func (r *circuitBreakerRateLimiterRepository) success(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = 0
+	if r.state == breakerHalfOpen {
+		r.setState(breakerClosed)
+		log.Ctx(ctx).Info().Msg("Rate limiter recovered; circuit breaker closed")
+	}
+}
+
+// failure records a failed call, opening the breaker after FailureThreshold consecutive
+// failures or a failed trial request.
+func (r *circuitBreakerRateLimiterRepository) failure(ctx context.Context, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rateLimiterMetrics.failures.Add(1)
+	r.failures++
+	if r.state == breakerHalfOpen || r.failures >= r.settings.FailureThreshold {
+		r.failures = 0
+		r.openedAt = r.now()
+		r.setState(breakerOpen)
+		rateLimiterMetrics.breakerOpened.Add(1)
+		log.Ctx(ctx).Error().Err(err).Str("failure_mode", string(r.settings.Mode)).
+			Dur("open_timeout", r.settings.OpenTimeout).Msg("Rate limiter unavailable; circuit breaker opened")
+		return
+	}
+	log.Ctx(ctx).Warn().Err(err).Msg("Rate limiter call failed")
+}
+
+// setState changes the breaker state. The caller must hold r.mu.
+func (r *circuitBreakerRateLimiterRepository) setState(state string) {
+	r.state = state
+	rateLimiterMetrics.breakerState.Set(state)
+}
+
+// degraded handles a request the rate limiter could not check.
+func (r *circuitBreakerRateLimiterRepository) degraded(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	rateLimiterMetrics.degradedRequests.Add(1)
+	switch r.settings.Mode {
+	case FailOpen:
+		return RateLimitResult{Allowed: true, Degraded: true}, nil
+	case FailLocal:
+		result, err := r.fallback.AllowRequest(ctx, key, limit)
+		result.Degraded = true
+		return result, err
+	}
+	return RateLimitResult{}, ErrRateLimiterUnavailable
+}
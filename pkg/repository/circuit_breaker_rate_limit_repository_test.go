@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeLimiter is a rate limiter that fails while err is set. It counts its calls.
+type fakeLimiter struct {
+	err   error
+	block bool // Wait for the context to be done instead of returning.
+	calls int
+}
+
+func (f *fakeLimiter) AllowRequest(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	f.calls++
+	if f.block {
+		<-ctx.Done()
+		return RateLimitResult{}, ctx.Err()
+	}
+	if f.err != nil {
+		return RateLimitResult{}, f.err
+	}
+	return RateLimitResult{Allowed: true, Remaining: 7}, nil
+}
+
+var testBreakerSettings = CircuitBreakerSettings{
+	FailureThreshold: 3,
+	OpenTimeout:      30 * time.Second,
+	Timeout:          50 * time.Millisecond,
+}
+
+func TestCircuitBreakerFailureModes(t *testing.T) {
+	limit := RateLimit{Requests: 1, Window: time.Minute}
+
+	testCases := []struct {
+		mode          FailureMode
+		expectAllowed []bool // For two requests with the same key.
+		expectErr     error
+	}{
+		{mode: FailOpen, expectAllowed: []bool{true, true}},
+		{mode: FailClosed, expectAllowed: []bool{false, false}, expectErr: ErrRateLimiterUnavailable},
+		{mode: FailLocal, expectAllowed: []bool{true, false}},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			settings := testBreakerSettings
+			settings.Mode = tc.mode
+			clock := &fakeClock{now: time.Unix(1700000000, 0)}
+			fallback := newMemoryRateLimiterRepository(10, clock.Now)
+			repo := newCircuitBreakerRateLimiterRepository(&fakeLimiter{err: errors.New("redis down")}, fallback, settings, clock.Now)
+
+			for i, expectAllowed := range tc.expectAllowed {
+				result, err := repo.AllowRequest(context.Background(), "key", limit)
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				if err != nil {
+					continue
+				}
+				if result.Allowed != expectAllowed || !result.Degraded {
+					t.Errorf("request %d: expected a degraded result with allowed %v, got %+v", i+1, expectAllowed, result)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	settings := testBreakerSettings
+	settings.Mode = FailOpen
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	limiter := &fakeLimiter{err: errors.New("redis down")}
+	repo := newCircuitBreakerRateLimiterRepository(limiter, nil, settings, clock.Now)
+	ctx := context.Background()
+	limit := RateLimit{Requests: 1, Window: time.Minute}
+
+	// ---- The breaker opens after three consecutive failures, and stops calling the limiter.
+	for i := 0; i < 5; i++ {
+		repo.AllowRequest(ctx, "key", limit)
+	}
+	if limiter.calls != 3 || repo.state != breakerOpen {
+		t.Fatalf("expected an open breaker after 3 calls, got %s after %d calls", repo.state, limiter.calls)
+	}
+
+	// ---- After the open timeout, a failed trial request opens it again.
+	clock.Advance(settings.OpenTimeout)
+	repo.AllowRequest(ctx, "key", limit)
+	repo.AllowRequest(ctx, "key", limit)
+	if limiter.calls != 4 || repo.state != breakerOpen {
+		t.Fatalf("expected an open breaker after a failed trial, got %s after %d calls", repo.state, limiter.calls)
+	}
+
+	// ---- A successful trial request closes it.
+	clock.Advance(settings.OpenTimeout)
+	limiter.err = nil
+	result, err := repo.AllowRequest(ctx, "key", limit)
+	if err != nil || result.Degraded || result.Remaining != 7 {
+		t.Fatalf("expected the limiter's result, got %+v, %v", result, err)
+	}
+	if repo.state != breakerClosed || rateLimiterMetrics.breakerState.Value() != breakerClosed {
+		t.Errorf("expected a closed breaker, got %s", repo.state)
+	}
+
+	// ---- Failures below the threshold are reset by a success.
+	limiter.err = errors.New("redis down")
+	repo.AllowRequest(ctx, "key", limit)
+	repo.AllowRequest(ctx, "key", limit)
+	limiter.err = nil
+	repo.AllowRequest(ctx, "key", limit)
+	limiter.err = errors.New("redis down")
+	repo.AllowRequest(ctx, "key", limit)
+	if repo.state != breakerClosed {
+		t.Errorf("expected a closed breaker, got %s", repo.state)
+	}
+}
+
+func TestCircuitBreakerTimeout(t *testing.T) {
+	settings := testBreakerSettings
+	settings.Mode = FailOpen
+	settings.FailureThreshold = 1
+	repo := newCircuitBreakerRateLimiterRepository(&fakeLimiter{block: true}, nil, settings, time.Now)
+
+	// A slow limiter counts as a failure.
+	result, err := repo.AllowRequest(context.Background(), "key", RateLimit{Requests: 1, Window: time.Minute})
+	if err != nil || !result.Allowed || !result.Degraded {
+		t.Errorf("expected a degraded result, got %+v, %v", result, err)
+	}
+	if repo.state != breakerOpen {
+		t.Errorf("expected an open breaker, got %s", repo.state)
+	}
+
+	// A request whose own context is canceled does not.
+	repo = newCircuitBreakerRateLimiterRepository(&fakeLimiter{block: true}, nil, settings, time.Now)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.AllowRequest(ctx, "key", RateLimit{Requests: 1, Window: time.Minute}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if repo.state != breakerClosed {
+		t.Errorf("expected a closed breaker, got %s", repo.state)
+	}
+}
+
+func TestCircuitBreakerSettingsValidate(t *testing.T) {
+	settings := testBreakerSettings
+	settings.Mode = FailLocal
+	if err := settings.Validate(); err != nil {
+		t.Errorf("did not expect error but got: %v", err)
+	}
+	settings.Mode = "retry"
+	if err := settings.Validate(); err == nil {
+		t.Errorf("expected error for an unknown failure mode")
+	}
+	settings.Mode = FailOpen
+	settings.Timeout = 0
+	if err := settings.Validate(); err == nil {
+		t.Errorf("expected error for a zero timeout")
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IExpirationRepository defines the interface for expiring earned points in the ledger.
+type IExpirationRepository interface {
+	// Expire appends an expiration entry for every receipt purchased on or before the cutoff
+	// date (YYYY-MM-DD) whose user has not spent all of the points it earned, less any points
+	// clawed back from it. Points are spent oldest first, so only what the user's redemptions
+	// and earlier expirations have not used up expires. It returns the number of receipts
+	// whose points expired.
+	Expire(ctx context.Context, cutoff string) (int64, error)
+}
+
+// expirationRepository is a concrete implementation of IExpirationRepository using GORM.
+type expirationRepository struct {
+	db *gorm.DB
+}
+
+// NewExpirationRepository creates a new instance of the expiration repository.
+// It performs auto-migration to ensure the schema is up to date.
+func NewExpirationRepository(db *gorm.DB) IExpirationRepository {
+	db.AutoMigrate(&LedgerEntryModel{})
+	return &expirationRepository{
+		db: db,
+	}
+}
+
+// Expire computes and appends the expirations of every receipt in a single statement, so they
+// cannot race with concurrent redemptions. Debits use up the oldest points first: a receipt's
+// unspent points are what its points, net of clawbacks, add to the user's points earned up to
+// and including it, beyond everything the user has had debited. Those of the receipts up to the
+// cutoff expire, recorded against the receipt so that later clawbacks do not debit them again.
+func (r *expirationRepository) Expire(ctx context.Context, cutoff string) (int64, error) {
+	result := r.db.WithContext(ctx).Exec(`INSERT INTO ledger_entry_models (tenant_id, user_id, receipt_id, kind, points, created_at)
+		SELECT tenant_id, user_id, receipt_id, ?, -MIN(net, earned - debited), ? FROM (
+			SELECT n.tenant_id, n.user_id, n.receipt_id, n.purchase_date, n.net,
+				SUM(n.net) OVER (PARTITION BY n.tenant_id, n.user_id ORDER BY n.purchase_date, n.receipt_id) AS earned,
+				COALESCE(d.debited, 0) AS debited
+			FROM (
+				SELECT l.tenant_id, l.user_id, l.receipt_id, r.purchase_date, SUM(l.points) AS net
+				FROM ledger_entry_models l
+				JOIN receipt_models r ON r.tenant_id = l.tenant_id AND r.id = l.receipt_id
+				WHERE l.kind IN (?, ?)
+				GROUP BY l.tenant_id, l.user_id, l.receipt_id, r.purchase_date
+			) n
+			LEFT JOIN (
+				SELECT tenant_id, user_id, -SUM(points) AS debited
+				FROM ledger_entry_models
+				WHERE kind IN (?, ?)
+				GROUP BY tenant_id, user_id
+			) d ON d.tenant_id = n.tenant_id AND d.user_id = n.user_id
+		) WHERE purchase_date <= ? AND net > 0 AND earned > debited`,
+		LedgerKindExpire, time.Now(),
+		LedgerKindEarn, LedgerKindReverse,
+		LedgerKindRedeem, LedgerKindExpire,
+		cutoff)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExpirationRepository_Expire(t *testing.T) {
+	db := newTestDB(t, "expiration_test")
+
+	receipts := NewReceiptRepository(db)
+	ledger := NewLedgerRepository(db)
+	expirations := NewExpirationRepository(db)
+	ctx := context.Background()
+
+	batch := []ReceiptModel{
+		{ID: "expire-1", TenantID: testTenant, UserID: "user-1", PurchaseDate: "2023-01-10", Points: 40, Hash: "expire-hash-1"},
+		{ID: "expire-2", TenantID: testTenant, UserID: "user-1", PurchaseDate: "2023-06-10", Points: 30, Hash: "expire-hash-2"},
+		{ID: "expire-3", TenantID: testTenant, UserID: "user-1", PurchaseDate: "2024-01-10", Points: 20, Hash: "expire-hash-3"},
+		{ID: "expire-4", TenantID: testTenant, UserID: "user-2", PurchaseDate: "2023-01-10", Points: 10, Hash: "expire-hash-4"},
+	}
+	if err := receipts.SaveAll(ctx, batch); err != nil {
+		t.Fatalf("failed to save receipts: %v", err)
+	}
+	// user-1 spends 50 points, all of the first receipt's and 10 of the second's.
+	if _, _, err := ledger.Redeem(ctx, testTenant, "user-1", "key-1", 50); err != nil {
+		t.Fatalf("failed to redeem points: %v", err)
+	}
+	// user-2 spends all their points.
+	if _, _, err := ledger.Redeem(ctx, testTenant, "user-2", "key-2", 10); err != nil {
+		t.Fatalf("failed to redeem points: %v", err)
+	}
+
+	testCases := []struct {
+		name             string
+		cutoff           string
+		expectedReceipts int64
+		expectedBalance  int // Of user-1.
+	}{
+		{name: "Spent points do not expire", cutoff: "2023-01-10", expectedReceipts: 0, expectedBalance: 40},
+		{name: "Unspent points expire", cutoff: "2023-06-10", expectedReceipts: 1, expectedBalance: 20},
+		{name: "Expired points expire once", cutoff: "2023-12-31", expectedReceipts: 0, expectedBalance: 20},
+		{name: "Later points expire", cutoff: "2024-01-10", expectedReceipts: 1, expectedBalance: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expired, err := expirations.Expire(ctx, tc.cutoff)
+			if err != nil {
+				t.Fatalf("failed to expire points: %v", err)
+			}
+			if expired != tc.expectedReceipts {
+				t.Errorf("expected points of %d receipts to expire, got %d", tc.expectedReceipts, expired)
+			}
+			if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != tc.expectedBalance {
+				t.Errorf("expected balance %d, got %d", tc.expectedBalance, balance)
+			}
+		})
+	}
+
+	entries, err := ledger.Entries(ctx, testTenant, "user-1", 1)
+	if err != nil || len(entries) != 1 || entries[0].Kind != LedgerKindExpire || entries[0].Points != -20 || entries[0].ReceiptID != "expire-3" {
+		t.Errorf("expected the latest entry to expire the 20 points of expire-3, got %+v (err: %v)", entries, err)
+	}
+
+	// Voiding a receipt whose points expired does not debit them again.
+	void := ReversalModel{ID: "expire-void-3", TenantID: testTenant, ReceiptID: "expire-3", Kind: ReversalVoid, Points: 20}
+	if err := receipts.SaveReversal(ctx, void); err != nil {
+		t.Fatalf("failed to save reversal: %v", err)
+	}
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 0 {
+		t.Errorf("expected balance 0 after voiding an expired receipt, got %d", balance)
+	}
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The kinds of ledger entries.
+const (
+	// LedgerKindEarn marks the points a user earned with a receipt.
+	LedgerKindEarn = "earn"
+	// LedgerKindRedeem marks the points a user spent.
+	LedgerKindRedeem = "redeem"
+	// LedgerKindExpire marks earned points that expired before they were spent.
+	LedgerKindExpire = "expire"
+	// LedgerKindReverse marks earned points clawed back by a void or refund of the receipt.
+	LedgerKindReverse = "reverse"
+)
+
+var (
+	// ErrLedgerAppendOnly is returned when updating or deleting a ledger entry.
+	ErrLedgerAppendOnly = errors.New("points ledger entries cannot be changed")
+	// ErrInsufficientPoints is returned when redeeming more points than a user's balance.
+	ErrInsufficientPoints = errors.New("insufficient points")
+)
+
+// LedgerEntryModel records a change to a user's points balance. Entries are only ever
+// appended: a user's balance is the sum of the points of their entries.
+type LedgerEntryModel struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement"`
+	TenantID  string `gorm:"type:varchar(64);not null;index:idx_ledger_tenant_user_id,priority:1;uniqueIndex:idx_ledger_tenant_idempotency_key,priority:1"`
+	UserID    string `gorm:"type:varchar(64);not null;index:idx_ledger_tenant_user_id,priority:2"`
+	ReceiptID string `gorm:"type:varchar(36);index"` // The receipt the points were earned with or clawed back from, if any.
+	Kind      string `gorm:"type:varchar(16);not null"`
+	Points    int    // Positive for grants, negative for debits.
+	// IdempotencyKey identifies the request that redeemed the points, so it is only applied once.
+	IdempotencyKey *string `gorm:"type:varchar(255);uniqueIndex:idx_ledger_tenant_idempotency_key,priority:2"`
+	CreatedAt      time.Time
+}
+
+// BeforeUpdate keeps ledger entries from being changed.
+func (LedgerEntryModel) BeforeUpdate(tx *gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
+
+// BeforeDelete keeps ledger entries from being deleted.
+func (LedgerEntryModel) BeforeDelete(tx *gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
+
+// ILedgerRepository defines the interface for the points ledger. Earned points are appended
+// along with the receipts that earn them, by IReceiptRepository.
+type ILedgerRepository interface {
+	// Balance returns the sum of the points of the user's entries, or 0 if there are none.
+	Balance(ctx context.Context, tenantID, userID string) (int, error)
+	// Entries returns up to limit of the user's entries, newest first.
+	Entries(ctx context.Context, tenantID, userID string, limit int) ([]LedgerEntryModel, error)
+	// Redeem debits points from the user's balance under the tenant's idempotency key, or returns
+	// ErrInsufficientPoints if the balance is lower. If the key was used before, the entry it
+	// redeemed is returned instead, with replayed set; it may be for another user or amount.
+	Redeem(ctx context.Context, tenantID, userID, idempotencyKey string, points int) (entry LedgerEntryModel, replayed bool, err error)
+}
+
+// ledgerRepository is a concrete implementation of ILedgerRepository using GORM.
+type ledgerRepository struct {
+	db *gorm.DB
+}
+
+// NewLedgerRepository creates a new instance of the ledger repository.
+// It performs auto-migration to ensure the schema is up to date.
+func NewLedgerRepository(db *gorm.DB) ILedgerRepository {
+	db.AutoMigrate(&LedgerEntryModel{})
+	return &ledgerRepository{
+		db: db,
+	}
+}
+
+// Balance sums the points of the user's entries.
+func (r *ledgerRepository) Balance(ctx context.Context, tenantID, userID string) (int, error) {
+	var balance int
+	result := r.db.WithContext(ctx).Model(&LedgerEntryModel{}).
+		Where("tenant_id = ? AND user_id = ?", tenantID, userID).
+		Select("COALESCE(SUM(points), 0)").
+		Scan(&balance)
+	return balance, result.Error
+}
+
+// Entries returns the user's most recent entries.
+func (r *ledgerRepository) Entries(ctx context.Context, tenantID, userID string, limit int) ([]LedgerEntryModel, error) {
+	var entries []LedgerEntryModel
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND user_id = ?", tenantID, userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&entries)
+	return entries, result.Error
+}
+
+// Redeem checks the balance and appends the debit in a single statement, so concurrent
+// redemptions cannot overdraw it.
+func (r *ledgerRepository) Redeem(ctx context.Context, tenantID, userID, idempotencyKey string, points int) (LedgerEntryModel, bool, error) {
+	var entry LedgerEntryModel
+	replayed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&entry, "tenant_id = ? AND idempotency_key = ?", tenantID, idempotencyKey).Error
+		if err == nil {
+			replayed = true
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		result := tx.Exec(`INSERT INTO ledger_entry_models (tenant_id, user_id, kind, points, idempotency_key, created_at)
+			SELECT ?, ?, ?, ?, ?, ?
+			WHERE (SELECT COALESCE(SUM(points), 0) FROM ledger_entry_models WHERE tenant_id = ? AND user_id = ?) >= ?`,
+			tenantID, userID, LedgerKindRedeem, -points, idempotencyKey, time.Now(),
+			tenantID, userID, points)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientPoints
+		}
+		return tx.First(&entry, "tenant_id = ? AND idempotency_key = ?", tenantID, idempotencyKey).Error
+	})
+	return entry, replayed, err
+}
+
+// appendEntries adds the entries to the ledger.
+func appendEntries(tx *gorm.DB, entries []LedgerEntryModel) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(&entries, saveBatchSize).Error
+}
+
+// earnedEntries returns the ledger entries granting the points of the receipts
+// that belong to a user.
+func earnedEntries(receipts []ReceiptModel) []LedgerEntryModel {
+	var entries []LedgerEntryModel
+	for _, receipt := range receipts {
+		if receipt.UserID == "" || receipt.Points == 0 {
+			continue
+		}
+		entries = append(entries, LedgerEntryModel{
+			TenantID:  receipt.TenantID,
+			UserID:    receipt.UserID,
+			ReceiptID: receipt.ID,
+			Kind:      LedgerKindEarn,
+			Points:    receipt.Points,
+		})
+	}
+	return entries
+}
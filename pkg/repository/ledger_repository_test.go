@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLedgerRepository(t *testing.T) {
+	db := newTestDB(t, "ledger_test")
+
+	receipts := NewReceiptRepository(db)
+	ledger := NewLedgerRepository(db)
+	ctx := context.Background()
+
+	// Receipts of a user add their points to the user's balance.
+	if err := receipts.Save(ctx, ReceiptModel{ID: "ledger-1", TenantID: testTenant, UserID: "user-1", Points: 30, Hash: "ledger-hash-1"}); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+	batch := []ReceiptModel{
+		{ID: "ledger-2", TenantID: testTenant, UserID: "user-1", Points: 12, Hash: "ledger-hash-2"},
+		{ID: "ledger-3", TenantID: testTenant, UserID: "user-2", Points: 5, Hash: "ledger-hash-3"},
+		{ID: "ledger-4", TenantID: testTenant, Points: 50, Hash: "ledger-hash-4"}, // No user.
+		{ID: "ledger-5", TenantID: "tenant-b", UserID: "user-1", Points: 7, Hash: "ledger-hash-5"},
+	}
+	if err := receipts.SaveAll(ctx, batch); err != nil {
+		t.Fatalf("failed to save batch: %v", err)
+	}
+
+	testCases := []struct {
+		tenantID        string
+		userID          string
+		expectedBalance int
+		expectedEntries []string // Receipt IDs, newest first.
+	}{
+		{tenantID: testTenant, userID: "user-1", expectedBalance: 42, expectedEntries: []string{"ledger-2", "ledger-1"}},
+		{tenantID: testTenant, userID: "user-2", expectedBalance: 5, expectedEntries: []string{"ledger-3"}},
+		{tenantID: "tenant-b", userID: "user-1", expectedBalance: 7, expectedEntries: []string{"ledger-5"}},
+		{tenantID: testTenant, userID: "unknown", expectedBalance: 0},
+	}
+	for _, tc := range testCases {
+		balance, err := ledger.Balance(ctx, tc.tenantID, tc.userID)
+		if err != nil {
+			t.Fatalf("failed to get balance: %v", err)
+		}
+		if balance != tc.expectedBalance {
+			t.Errorf("%s/%s: expected balance %d, got %d", tc.tenantID, tc.userID, tc.expectedBalance, balance)
+		}
+		entries, err := ledger.Entries(ctx, tc.tenantID, tc.userID, 10)
+		if err != nil {
+			t.Fatalf("failed to get entries: %v", err)
+		}
+		if len(entries) != len(tc.expectedEntries) {
+			t.Fatalf("%s/%s: expected %d entries, got %+v", tc.tenantID, tc.userID, len(tc.expectedEntries), entries)
+		}
+		for i, entry := range entries {
+			if entry.ReceiptID != tc.expectedEntries[i] || entry.Kind != LedgerKindEarn {
+				t.Errorf("%s/%s: unexpected entry %d: %+v", tc.tenantID, tc.userID, i, entry)
+			}
+		}
+	}
+
+	// A receipt that fails to save grants no points.
+	duplicate := ReceiptModel{ID: "ledger-6", TenantID: testTenant, UserID: "user-1", Points: 100, Hash: "ledger-hash-1"}
+	if err := receipts.Save(ctx, duplicate); err == nil {
+		t.Fatalf("expected error when saving a duplicate receipt, got nil")
+	}
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 42 {
+		t.Errorf("expected the failed save to be rolled back, got balance %d", balance)
+	}
+
+	// Entries cannot be changed or deleted.
+	if err := db.Model(&LedgerEntryModel{}).Where("user_id = ?", "user-1").Update("points", 1000).Error; !errors.Is(err, ErrLedgerAppendOnly) {
+		t.Errorf("expected ErrLedgerAppendOnly when updating, got %v", err)
+	}
+	if err := db.Where("user_id = ?", "user-1").Delete(&LedgerEntryModel{}).Error; !errors.Is(err, ErrLedgerAppendOnly) {
+		t.Errorf("expected ErrLedgerAppendOnly when deleting, got %v", err)
+	}
+}
+
+func TestLedgerRepository_Redeem(t *testing.T) {
+	db := newTestDB(t, "ledger_redeem_test")
+
+	receipts := NewReceiptRepository(db)
+	ledger := NewLedgerRepository(db)
+	ctx := context.Background()
+	if err := receipts.Save(ctx, ReceiptModel{ID: "redeem-1", TenantID: testTenant, UserID: "user-1", Points: 50, Hash: "redeem-hash-1"}); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+
+	entry, replayed, err := ledger.Redeem(ctx, testTenant, "user-1", "key-1", 30)
+	if err != nil || replayed {
+		t.Fatalf("failed to redeem points: %v (replayed: %v)", err, replayed)
+	}
+	if entry.Kind != LedgerKindRedeem || entry.Points != -30 || entry.UserID != "user-1" {
+		t.Errorf("unexpected redemption entry: %+v", entry)
+	}
+
+	// Retrying with the same key returns the original entry without debiting again.
+	again, replayed, err := ledger.Redeem(ctx, testTenant, "user-1", "key-1", 30)
+	if err != nil || !replayed || again.ID != entry.ID {
+		t.Errorf("expected a replay of entry %d, got %+v (replayed: %v, err: %v)", entry.ID, again, replayed, err)
+	}
+
+	// Redeeming more than the balance is rejected.
+	if _, _, err := ledger.Redeem(ctx, testTenant, "user-1", "key-2", 21); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("expected ErrInsufficientPoints, got %v", err)
+	}
+	if _, _, err := ledger.Redeem(ctx, "tenant-b", "user-1", "key-3", 1); !errors.Is(err, ErrInsufficientPoints) {
+		t.Errorf("expected ErrInsufficientPoints for a user without points in the tenant, got %v", err)
+	}
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 20 {
+		t.Errorf("expected balance 20, got %d", balance)
+	}
+	// A key that failed to redeem can be used again.
+	if _, replayed, err := ledger.Redeem(ctx, testTenant, "user-1", "key-2", 20); err != nil || replayed {
+		t.Errorf("failed to redeem the remaining points: %v (replayed: %v)", err, replayed)
+	}
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 0 {
+		t.Errorf("expected balance 0, got %d", balance)
+	}
+}
+
+func TestLedgerRepository_RedeemConcurrent(t *testing.T) {
+	db := newTestDB(t, "ledger_concurrent_test")
+
+	receipts := NewReceiptRepository(db)
+	ledger := NewLedgerRepository(db)
+	ctx := context.Background()
+	if err := receipts.Save(ctx, ReceiptModel{ID: "concurrent-1", TenantID: testTenant, UserID: "user-1", Points: 100, Hash: "concurrent-hash-1"}); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+
+	// Concurrent redemptions never overdraw the balance.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := ledger.Redeem(ctx, testTenant, "user-1", fmt.Sprintf("key-%d", i), 10); err != nil && !errors.Is(err, ErrInsufficientPoints) {
+				t.Errorf("failed to redeem points: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 0 {
+		t.Errorf("expected exactly the balance to be redeemed, got a balance of %d", balance)
+	}
+}
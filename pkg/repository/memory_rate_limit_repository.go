@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// memoryEntry holds the rate limit state of a single key. Only the fields of the
+// key's algorithm are used.
+type memoryEntry struct {
+	key       string
+	expiresAt time.Time // When the state no longer affects any request.
+
+	requests []time.Time // SlidingWindow: oldest first, never more than the limit.
+	tokens   float64     // TokenBucket: the tokens left at updated.
+	updated  time.Time   // TokenBucket: when tokens were counted.
+	tat      time.Time   // GCRA: the theoretical arrival time of the next request.
+}
+
+// memoryRateLimiterRepository is an in-process rate limiter for single-node deployments.
+// Memory is bounded: each key holds at most its limit of requests, and at most maxKeys
+// keys are kept, evicting the least recently used.
+type memoryRateLimiterRepository struct {
+	mu      sync.Mutex
+	maxKeys int
+	keys    map[string]*list.Element // Values are *memoryEntry.
+	lru     *list.List               // Most recently used first.
+	now     func() time.Time
+}
+
+// NewMemoryRateLimiterRepository creates an in-memory rate limiter that keeps at most maxKeys keys.
+// Every evictionInterval, a background goroutine drops keys whose state has expired.
+// It stops when ctx is done.
+func NewMemoryRateLimiterRepository(ctx context.Context, maxKeys int, evictionInterval time.Duration) IRateLimiterRepository {
+	r := newMemoryRateLimiterRepository(maxKeys, time.Now)
+	go func() {
+		ticker := time.NewTicker(evictionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.evictExpired()
+			}
+		}
+	}()
+	return r
+}
+
+func newMemoryRateLimiterRepository(maxKeys int, now func() time.Time) *memoryRateLimiterRepository {
+	return &memoryRateLimiterRepository{
+		maxKeys: maxKeys,
+		keys:    make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     now,
+	}
+}
+
+// AllowRequest checks the request with the limit's algorithm.
+func (r *memoryRateLimiterRepository) AllowRequest(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	entry := r.entry(key)
+	switch limit.Algorithm {
+	case SlidingWindow, "":
+		return entry.slidingWindow(now, limit), nil
+	case TokenBucket:
+		return entry.tokenBucket(now, limit), nil
+	case GCRA:
+		return entry.gcra(now, limit), nil
+	}
+	return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", limit.Algorithm)
+}
+
+// slidingWindow records every request in the window.
+func (e *memoryEntry) slidingWindow(now time.Time, limit RateLimit) RateLimitResult {
+	// Remove entries older than the current sliding window.
+	cutoff := now.Add(-limit.Window)
+	expired := 0
+	for expired < len(e.requests) && !e.requests[expired].After(cutoff) {
+		expired++
+	}
+	e.requests = e.requests[expired:]
+
+	if len(e.requests) >= limit.Requests {
+		// The next request is allowed once the oldest request leaves the window.
+		retryAfter := limit.Window
+		if len(e.requests) > 0 {
+			retryAfter = e.requests[0].Add(limit.Window).Sub(now)
+		}
+		return RateLimitResult{RetryAfter: retryAfter}
+	}
+
+	e.requests = append(e.requests, now)
+	e.expiresAt = now.Add(limit.Window)
+	return RateLimitResult{Allowed: true, Remaining: limit.Requests - len(e.requests)}
+}
+
+// tokenBucket takes a token from a bucket that refills at the limit's rate.
+func (e *memoryEntry) tokenBucket(now time.Time, limit RateLimit) RateLimitResult {
+	interval := limit.interval()
+	burst := float64(limit.burst())
+	if e.updated.IsZero() {
+		e.tokens = burst
+		e.updated = now
+	}
+
+	// Refill the tokens earned since they were last counted.
+	if elapsed := now.Sub(e.updated); elapsed > 0 {
+		e.tokens = math.Min(burst, e.tokens+float64(elapsed.Microseconds())/1000/interval)
+		e.updated = now
+	}
+	// The state is no longer needed once the bucket is full again.
+	defer func() { e.expiresAt = now.Add(millis((burst - e.tokens) * interval)) }()
+
+	if e.tokens < 1 {
+		return RateLimitResult{RetryAfter: millis((1 - e.tokens) * interval)}
+	}
+	e.tokens--
+	return RateLimitResult{Allowed: true, Remaining: int(e.tokens)}
+}
+
+// gcra allows the request if it does not arrive too far ahead of its theoretical arrival time.
+func (e *memoryEntry) gcra(now time.Time, limit RateLimit) RateLimitResult {
+	interval := millis(limit.interval())
+	tat := e.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-time.Duration(limit.burst()) * interval)
+	if now.Before(allowAt) {
+		return RateLimitResult{RetryAfter: allowAt.Sub(now)}
+	}
+
+	e.tat = newTAT
+	e.expiresAt = newTAT
+	return RateLimitResult{Allowed: true, Remaining: int(now.Sub(allowAt) / interval)}
+}
+
+// millis converts a number of milliseconds to a duration, rounding up to the microsecond.
+func millis(ms float64) time.Duration {
+	return time.Duration(math.Ceil(ms*1000)) * time.Microsecond
+}
+
+// entry returns the state for the key, creating it if necessary, and marks it as
+// most recently used. The caller must hold r.mu.
+func (r *memoryRateLimiterRepository) entry(key string) *memoryEntry {
+	if elem, ok := r.keys[key]; ok {
+		r.lru.MoveToFront(elem)
+		return elem.Value.(*memoryEntry)
+	}
+	for r.maxKeys > 0 && len(r.keys) >= r.maxKeys {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.keys, oldest.Value.(*memoryEntry).key)
+	}
+	e := &memoryEntry{key: key}
+	r.keys[key] = r.lru.PushFront(e)
+	return e
+}
+
+// evictExpired drops the keys whose state no longer affects any request.
+func (r *memoryRateLimiterRepository) evictExpired() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for elem := r.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		e := elem.Value.(*memoryEntry)
+		if !e.expiresAt.After(now) {
+			r.lru.Remove(elem)
+			delete(r.keys, e.key)
+		}
+		elem = prev
+	}
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for testing.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryAllowRequest(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	repo := newMemoryRateLimiterRepository(10, clock.Now)
+	ctx := context.Background()
+	key := "rate_limit:127.0.0.1"
+
+	// ---- First and second requests are allowed.
+	for i, expectedRemaining := range []int{1, 0} {
+		result, _ := repo.AllowRequest(ctx, key, RateLimit{Requests: 2, Window: time.Minute})
+		if !result.Allowed || result.Remaining != expectedRemaining {
+			t.Errorf("expected request %d to be allowed with %d remaining, got %+v", i+1, expectedRemaining, result)
+		}
+		clock.Advance(10 * time.Second)
+	}
+
+	// ---- Third request, 20 seconds after the first, is denied for another 40 seconds.
+	result, _ := repo.AllowRequest(ctx, key, RateLimit{Requests: 2, Window: time.Minute})
+	if result.Allowed || result.RetryAfter != 40*time.Second {
+		t.Errorf("expected denial with a 40s retry, got %+v", result)
+	}
+
+	// ---- Once the first request leaves the window, another is allowed.
+	clock.Advance(40 * time.Second)
+	result, _ = repo.AllowRequest(ctx, key, RateLimit{Requests: 2, Window: time.Minute})
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected request to be allowed with 0 remaining, got %+v", result)
+	}
+}
+
+func TestMemoryBurstAlgorithms(t *testing.T) {
+	for _, algorithm := range []RateLimitAlgorithm{TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(1700000000, 0)}
+			repo := newMemoryRateLimiterRepository(10, clock.Now)
+			ctx := context.Background()
+			// One request a second, with bursts of up to three.
+			limit := RateLimit{Algorithm: algorithm, Requests: 10, Window: 10 * time.Second, Burst: 3}
+
+			// ---- A burst of three requests is allowed at once.
+			for _, expectedRemaining := range []int{2, 1, 0} {
+				result, err := repo.AllowRequest(ctx, "key", limit)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !result.Allowed || result.Remaining != expectedRemaining {
+					t.Errorf("expected request to be allowed with %d remaining, got %+v", expectedRemaining, result)
+				}
+			}
+
+			// ---- The fourth must wait a second for the next request to be earned.
+			result, _ := repo.AllowRequest(ctx, "key", limit)
+			if result.Allowed || result.RetryAfter != time.Second {
+				t.Errorf("expected denial with a 1s retry, got %+v", result)
+			}
+			clock.Advance(400 * time.Millisecond)
+			result, _ = repo.AllowRequest(ctx, "key", limit)
+			if result.Allowed || result.RetryAfter != 600*time.Millisecond {
+				t.Errorf("expected denial with a 600ms retry, got %+v", result)
+			}
+			clock.Advance(600 * time.Millisecond)
+			result, _ = repo.AllowRequest(ctx, "key", limit)
+			if !result.Allowed || result.Remaining != 0 {
+				t.Errorf("expected request to be allowed with 0 remaining, got %+v", result)
+			}
+
+			// ---- After a long pause, the burst is available again but does not grow beyond it.
+			clock.Advance(time.Minute)
+			result, _ = repo.AllowRequest(ctx, "key", limit)
+			if !result.Allowed || result.Remaining != 2 {
+				t.Errorf("expected request to be allowed with 2 remaining, got %+v", result)
+			}
+
+			// ---- The state expires once it no longer affects any request.
+			clock.Advance(3 * time.Second)
+			repo.evictExpired()
+			if len(repo.keys) != 0 {
+				t.Errorf("expected the key to be evicted, got keys %v", keysOf(repo))
+			}
+		})
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	repo := newMemoryRateLimiterRepository(3, clock.Now)
+	ctx := context.Background()
+
+	// The least recently used key is evicted to stay within maxKeys.
+	for _, key := range []string{"a", "b", "c"} {
+		repo.AllowRequest(ctx, key, RateLimit{Requests: 1, Window: time.Minute})
+	}
+	repo.AllowRequest(ctx, "a", RateLimit{Requests: 1, Window: time.Minute}) // "a" is now the most recently used.
+	repo.AllowRequest(ctx, "d", RateLimit{Requests: 1, Window: time.Minute})
+	if _, ok := repo.keys["b"]; ok || len(repo.keys) != 3 {
+		t.Errorf("expected b to be evicted, got keys %v", keysOf(repo))
+	}
+
+	// Keys whose requests have left their window are evicted in the background.
+	repo.AllowRequest(ctx, "short", RateLimit{Requests: 1, Window: time.Second})
+	clock.Advance(2 * time.Second)
+	repo.evictExpired()
+	if _, ok := repo.keys["short"]; ok || len(repo.keys) != 2 {
+		t.Errorf("expected short to be evicted, got keys %v", keysOf(repo))
+	}
+}
+
+func TestMemoryAllowRequestConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := NewMemoryRateLimiterRepository(ctx, 100, time.Millisecond)
+
+	// Many clients race for the same key; exactly maxRequests may get through.
+	const maxRequests = 25
+	for _, algorithm := range []RateLimitAlgorithm{SlidingWindow, TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			limit := RateLimit{Algorithm: algorithm, Requests: maxRequests, Window: time.Hour}
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for w := 0; w < 20; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 10; i++ {
+						if result, _ := repo.AllowRequest(ctx, "shared:"+string(algorithm), limit); result.Allowed {
+							allowed.Add(1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if got := allowed.Load(); got != maxRequests {
+				t.Errorf("expected exactly %d requests to be allowed, got %d", maxRequests, got)
+			}
+		})
+	}
+}
+
+func keysOf(r *memoryRateLimiterRepository) []string {
+	var keys []string
+	for elem := r.lru.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*memoryEntry).key)
+	}
+	return keys
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MerchantModel represents a canonical merchant of the catalog. Receipts are mapped to a
+// merchant by their normalized retailer name, which must match one of its aliases.
+type MerchantModel struct {
+	ID        string               `gorm:"primaryKey;type:varchar(36)"`
+	TenantID  string               `gorm:"type:varchar(64);not null;index"`
+	Name      string               `gorm:"not null"`
+	Aliases   []MerchantAliasModel `gorm:"foreignKey:MerchantID"`
+	CreatedAt time.Time
+}
+
+// MerchantAliasModel records a retailer name of a merchant. A normalized name belongs to
+// at most one merchant of a tenant.
+type MerchantAliasModel struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	TenantID   string `gorm:"type:varchar(64);not null;uniqueIndex:idx_merchant_alias_tenant_key,priority:1"`
+	MerchantID string `gorm:"type:varchar(36);index"`
+	Alias      string // The name as given, e.g. "Target Store #123".
+	Key        string `gorm:"column:alias_key;not null;uniqueIndex:idx_merchant_alias_tenant_key,priority:2"` // The normalized name, e.g. "target".
+}
+
+// IMerchantRepository defines the interface for interacting with the merchant catalog.
+// Every method is scoped to a tenant.
+type IMerchantRepository interface {
+	// Save stores a new merchant and its aliases. It returns ErrAliasTaken if another
+	// merchant already has one of the aliases' keys.
+	Save(ctx context.Context, merchant MerchantModel) error
+	// AddAliases adds aliases to one of the tenant's merchants. Keys the merchant already has
+	// are skipped. It returns gorm.ErrRecordNotFound if the merchant does not exist, and
+	// ErrAliasTaken if another merchant already has one of the keys.
+	AddAliases(ctx context.Context, tenantID, merchantID string, aliases []MerchantAliasModel) error
+	GetByID(ctx context.Context, tenantID, id string) (MerchantModel, error)
+	// FindByKey returns the merchant with an alias of the normalized name. It returns
+	// gorm.ErrRecordNotFound if there is none.
+	FindByKey(ctx context.Context, tenantID, key string) (MerchantModel, error)
+	// List returns the tenant's merchants by name.
+	List(ctx context.Context, tenantID string) ([]MerchantModel, error)
+}
+
+// ErrAliasTaken is returned when adding an alias whose key belongs to another merchant.
+var ErrAliasTaken = errors.New("alias belongs to another merchant")
+
+// merchantRepository is a concrete implementation of IMerchantRepository using GORM.
+type merchantRepository struct {
+	db *gorm.DB
+}
+
+// NewMerchantRepository creates a new instance of the merchant repository.
+// It performs auto-migration to ensure the schema is up to date.
+func NewMerchantRepository(db *gorm.DB) IMerchantRepository {
+	db.AutoMigrate(&MerchantModel{}, &MerchantAliasModel{})
+	return &merchantRepository{
+		db: db,
+	}
+}
+
+// Save checks the aliases' keys and stores the merchant with its aliases in a transaction.
+func (r *merchantRepository) Save(ctx context.Context, merchant MerchantModel) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		keys := make([]string, len(merchant.Aliases))
+		for i := range merchant.Aliases {
+			merchant.Aliases[i].TenantID = merchant.TenantID
+			keys[i] = merchant.Aliases[i].Key
+		}
+		if err := checkAliasKeys(tx, merchant.TenantID, "", keys); err != nil {
+			return err
+		}
+		return tx.Create(&merchant).Error
+	})
+}
+
+// AddAliases checks the merchant and the aliases' keys, then stores the new aliases in a transaction.
+func (r *merchantRepository) AddAliases(ctx context.Context, tenantID, merchantID string, aliases []MerchantAliasModel) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var merchant MerchantModel
+		if err := tx.Preload("Aliases").First(&merchant, "tenant_id = ? AND id = ?", tenantID, merchantID).Error; err != nil {
+			return err
+		}
+		known := make(map[string]bool)
+		for _, alias := range merchant.Aliases {
+			known[alias.Key] = true
+		}
+		var added []MerchantAliasModel
+		var keys []string
+		for _, alias := range aliases {
+			if known[alias.Key] {
+				continue
+			}
+			known[alias.Key] = true
+			alias.TenantID = tenantID
+			alias.MerchantID = merchantID
+			added = append(added, alias)
+			keys = append(keys, alias.Key)
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		if err := checkAliasKeys(tx, tenantID, merchantID, keys); err != nil {
+			return err
+		}
+		return tx.Create(&added).Error
+	})
+}
+
+// GetByID retrieves a merchant of the tenant by its ID, preloading its aliases.
+func (r *merchantRepository) GetByID(ctx context.Context, tenantID, id string) (MerchantModel, error) {
+	var merchant MerchantModel
+	result := r.db.WithContext(ctx).
+		Preload("Aliases", orderByID).
+		First(&merchant, "tenant_id = ? AND id = ?", tenantID, id)
+	return merchant, result.Error
+}
+
+// FindByKey looks the key up among the tenant's aliases.
+func (r *merchantRepository) FindByKey(ctx context.Context, tenantID, key string) (MerchantModel, error) {
+	var merchant MerchantModel
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND id = (?)", tenantID,
+			r.db.Model(&MerchantAliasModel{}).Select("merchant_id").Where("tenant_id = ? AND alias_key = ?", tenantID, key)).
+		First(&merchant)
+	return merchant, result.Error
+}
+
+// List returns every merchant of the tenant, preloading their aliases.
+func (r *merchantRepository) List(ctx context.Context, tenantID string) ([]MerchantModel, error) {
+	var merchants []MerchantModel
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("name").Order("id").
+		Preload("Aliases", orderByID).
+		Find(&merchants)
+	return merchants, result.Error
+}
+
+// checkAliasKeys returns ErrAliasTaken if a merchant of the tenant other than merchantID
+// has an alias with one of the keys.
+func checkAliasKeys(tx *gorm.DB, tenantID, merchantID string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	var taken int64
+	if err := tx.Model(&MerchantAliasModel{}).
+		Where("tenant_id = ? AND alias_key IN ? AND merchant_id <> ?", tenantID, keys, merchantID).
+		Count(&taken).Error; err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrAliasTaken
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestMerchantRepository(t *testing.T) {
+	db := newTestDB(t, "merchant_test")
+	repo := NewMerchantRepository(db)
+	ctx := context.Background()
+
+	target := MerchantModel{ID: "target", TenantID: testTenant, Name: "Target", Aliases: []MerchantAliasModel{
+		{Alias: "Target", Key: "target"},
+		{Alias: "Tgt", Key: "tgt"},
+	}}
+	if err := repo.Save(ctx, target); err != nil {
+		t.Fatalf("failed to save merchant: %v", err)
+	}
+	// Aliases are unique within a tenant only.
+	if err := repo.Save(ctx, MerchantModel{ID: "other-target", TenantID: "tenant-b", Name: "Target", Aliases: []MerchantAliasModel{{Alias: "Target", Key: "target"}}}); err != nil {
+		t.Fatalf("failed to save another tenant's merchant: %v", err)
+	}
+	if err := repo.Save(ctx, MerchantModel{ID: "walmart", TenantID: testTenant, Name: "Walmart", Aliases: []MerchantAliasModel{
+		{Alias: "Walmart", Key: "walmart"},
+		{Alias: "TGT", Key: "tgt"},
+	}}); !errors.Is(err, ErrAliasTaken) {
+		t.Errorf("expected ErrAliasTaken saving a merchant with a taken alias, got %v", err)
+	}
+
+	// Merchants are found by the keys of their aliases.
+	testCases := []struct {
+		tenantID   string
+		key        string
+		expectedID string
+	}{
+		{tenantID: testTenant, key: "target", expectedID: "target"},
+		{tenantID: testTenant, key: "tgt", expectedID: "target"},
+		{tenantID: "tenant-b", key: "target", expectedID: "other-target"},
+		{tenantID: "tenant-b", key: "tgt"},
+		{tenantID: testTenant, key: "walmart"},
+	}
+	for _, tc := range testCases {
+		merchant, err := repo.FindByKey(ctx, tc.tenantID, tc.key)
+		if tc.expectedID == "" {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("%s/%s: expected gorm.ErrRecordNotFound, got %v", tc.tenantID, tc.key, err)
+			}
+			continue
+		}
+		if err != nil || merchant.ID != tc.expectedID {
+			t.Errorf("%s/%s: expected merchant %s, got %+v, %v", tc.tenantID, tc.key, tc.expectedID, merchant, err)
+		}
+	}
+
+	// Adding aliases skips the keys the merchant has, and rejects those of other merchants.
+	if err := repo.Save(ctx, MerchantModel{ID: "walmart", TenantID: testTenant, Name: "Walmart", Aliases: []MerchantAliasModel{{Alias: "Walmart", Key: "walmart"}}}); err != nil {
+		t.Fatalf("failed to save merchant: %v", err)
+	}
+	if err := repo.AddAliases(ctx, testTenant, "target", []MerchantAliasModel{{Alias: "TARGET", Key: "target"}, {Alias: "Target.com", Key: "target.com"}}); err != nil {
+		t.Fatalf("failed to add aliases: %v", err)
+	}
+	if err := repo.AddAliases(ctx, testTenant, "target", []MerchantAliasModel{{Alias: "Walmart", Key: "walmart"}}); !errors.Is(err, ErrAliasTaken) {
+		t.Errorf("expected ErrAliasTaken adding another merchant's alias, got %v", err)
+	}
+	if err := repo.AddAliases(ctx, "tenant-b", "target", []MerchantAliasModel{{Alias: "Tgt", Key: "tgt"}}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected gorm.ErrRecordNotFound adding aliases to another tenant's merchant, got %v", err)
+	}
+	saved, err := repo.GetByID(ctx, testTenant, "target")
+	if err != nil {
+		t.Fatalf("failed to get merchant: %v", err)
+	}
+	var aliases []string
+	for _, alias := range saved.Aliases {
+		aliases = append(aliases, alias.Alias)
+	}
+	if strings.Join(aliases, ",") != "Target,Tgt,Target.com" {
+		t.Errorf("expected the merchant's aliases in the order added, got %v", aliases)
+	}
+
+	merchants, err := repo.List(ctx, testTenant)
+	if err != nil {
+		t.Fatalf("failed to list merchants: %v", err)
+	}
+	if len(merchants) != 2 || merchants[0].ID != "target" || merchants[1].ID != "walmart" || len(merchants[0].Aliases) != 3 {
+		t.Errorf("expected the tenant's merchants by name, got %+v", merchants)
+	}
+}
+
+func TestReceiptRepository_MapMerchant(t *testing.T) {
+	db := newTestDB(t, "map_merchant_test")
+	repo := NewReceiptRepository(db)
+	ctx := context.Background()
+
+	receipts := []ReceiptModel{
+		{ID: "map-1", TenantID: testTenant, Retailer: "Target", Hash: "map-hash-1"},
+		{ID: "map-2", TenantID: testTenant, Retailer: "TARGET Store #12", Hash: "map-hash-2"},
+		{ID: "map-3", TenantID: testTenant, Retailer: "Target", MerchantID: "old-target", Hash: "map-hash-3"},
+		{ID: "map-4", TenantID: testTenant, Retailer: "Walmart", Hash: "map-hash-4"},
+		{ID: "map-5", TenantID: "tenant-b", Retailer: "Target", Hash: "map-hash-5"},
+	}
+	if err := repo.SaveAll(ctx, receipts); err != nil {
+		t.Fatalf("failed to save receipts: %v", err)
+	}
+
+	retailers, err := repo.UnmappedRetailers(ctx, testTenant)
+	if err != nil {
+		t.Fatalf("failed to list unmapped retailers: %v", err)
+	}
+	if strings.Join(retailers, ",") != "TARGET Store #12,Target,Walmart" {
+		t.Errorf("expected the distinct unmapped retailers, got %v", retailers)
+	}
+
+	// Only the tenant's unmapped receipts of the retailers are mapped.
+	mapped, err := repo.MapMerchant(ctx, testTenant, "target", []string{"Target", "TARGET Store #12"})
+	if err != nil {
+		t.Fatalf("failed to map receipts: %v", err)
+	}
+	if mapped != 2 {
+		t.Errorf("expected 2 receipts to be mapped, got %d", mapped)
+	}
+	for id, expected := range map[string]string{"map-1": "target", "map-2": "target", "map-3": "old-target", "map-4": ""} {
+		receipt, err := repo.GetByID(ctx, testTenant, id)
+		if err != nil {
+			t.Fatalf("failed to get receipt: %v", err)
+		}
+		if receipt.MerchantID != expected {
+			t.Errorf("%s: expected merchant %q, got %q", id, expected, receipt.MerchantID)
+		}
+	}
+	other, err := repo.GetByID(ctx, "tenant-b", "map-5")
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if other.MerchantID != "" {
+		t.Errorf("expected another tenant's receipt not to be mapped, got %q", other.MerchantID)
+	}
+
+	// Receipts can be listed by merchant.
+	page, err := repo.List(ctx, testTenant, ReceiptFilter{MerchantID: "target"}, nil, 10)
+	if err != nil {
+		t.Fatalf("failed to list receipts: %v", err)
+	}
+	if len(page) != 2 {
+		t.Errorf("expected 2 receipts of the merchant, got %d", len(page))
+	}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// benchmarkLimits are the algorithms compared by the benchmarks, all with the same rate.
+var benchmarkLimits = []RateLimit{
+	{Algorithm: SlidingWindow, Requests: 1000, Window: time.Minute},
+	{Algorithm: TokenBucket, Requests: 1000, Window: time.Minute, Burst: 100},
+	{Algorithm: GCRA, Requests: 1000, Window: time.Minute, Burst: 100},
+}
+
+// benchmarkAllowRequest checks requests spread over a number of keys, so the results
+// include both allowed and denied requests.
+func benchmarkAllowRequest(b *testing.B, repo IRateLimiterRepository, limit RateLimit) {
+	ctx := context.Background()
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "rate_limit:bench:" + strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.AllowRequest(ctx, keys[i%len(keys)], limit); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkMemoryAllowRequest(b *testing.B) {
+	for _, limit := range benchmarkLimits {
+		b.Run(string(limit.Algorithm), func(b *testing.B) {
+			benchmarkAllowRequest(b, newMemoryRateLimiterRepository(0, time.Now), limit)
+		})
+	}
+}
+
+func BenchmarkRedisAllowRequest(b *testing.B) {
+	for _, limit := range benchmarkLimits {
+		b.Run(string(limit.Algorithm), func(b *testing.B) {
+			_, client := newMiniredisClient(b)
+			benchmarkAllowRequest(b, NewRateLimiterRepository(client), limit)
+		})
+	}
+}
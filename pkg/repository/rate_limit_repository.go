@@ -2,17 +2,69 @@ package repository
 
 import (
 	"context"
-	"strconv"
+	"fmt"
 	"time"
 
+	"github.com/google/uuid"
 	rd "github.com/redis/go-redis/v9"
 )
 
-// IRateLimiterRepository defines the interface for a sliding window rate limiter.
+// RateLimitAlgorithm selects how requests are counted against a rate limit.
+type RateLimitAlgorithm string
+
+const (
+	// SlidingWindow records every request in the window. It is exact, but costs
+	// memory proportional to the number of requests per key.
+	SlidingWindow RateLimitAlgorithm = "sliding_window"
+	// TokenBucket refills a bucket of Burst tokens at Requests per Window; each request
+	// takes a token. It keeps constant state per key.
+	TokenBucket RateLimitAlgorithm = "token_bucket"
+	// GCRA is the generic cell rate algorithm. It allows the same traffic as TokenBucket
+	// but keeps only a single timestamp per key.
+	GCRA RateLimitAlgorithm = "gcra"
+)
+
+// RateLimit describes the rate a key may make requests at.
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm // Defaults to SlidingWindow.
+	Requests  int                // Requests allowed per Window.
+	Window    time.Duration
+	// Burst is the number of requests that may be made at once by TokenBucket and GCRA.
+	// It defaults to Requests.
+	Burst int
+}
+
+// burst returns the burst capacity of the limit.
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// interval returns the time it takes to earn one request, in milliseconds.
+func (l RateLimit) interval() float64 {
+	return float64(l.Window.Microseconds()) / 1000 / float64(l.Requests)
+}
+
+// RateLimitResult is the outcome of a rate limit check.
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of further requests allowed right away.
+	Remaining int
+	// RetryAfter is how long to wait before the next request can be allowed.
+	// It is only set when the request is denied.
+	RetryAfter time.Duration
+	// Degraded reports that the rate limiter was unavailable, so the result comes from
+	// its failure mode and Remaining is not meaningful.
+	Degraded bool
+}
+
+// IRateLimiterRepository defines the interface for a rate limiter.
 type IRateLimiterRepository interface {
-	// AllowRequest returns true if the request is allowed under the rate limit,
-	// or false if the limit has been exceeded.
-	AllowRequest(ctx context.Context, key string, window time.Duration, maxRequests int) (bool, error)
+	// AllowRequest records the request under the key if it is allowed under the rate limit,
+	// and reports whether it was allowed along with the remaining quota.
+	AllowRequest(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
 }
 
 type rateLimiterRepository struct {
@@ -26,38 +78,120 @@ func NewRateLimiterRepository(client *rd.Client) IRateLimiterRepository {
 	}
 }
 
-// AllowRequest implements the sliding window rate limiter.
-// It uses a Redis sorted set to store timestamps (in milliseconds) for each request.
-func (r *rateLimiterRepository) AllowRequest(ctx context.Context, key string, window time.Duration, maxRequests int) (bool, error) {
-	now := time.Now().UnixNano() / int64(time.Millisecond) // current time in ms
-	windowMillis := int64(window / time.Millisecond)
-	cutoff := now - windowMillis
+// The scripts below each check and record a request in a single atomic step, so concurrent
+// requests cannot all pass the check. KEYS[1] is the key. Each returns whether the request
+// is allowed, the remaining quota, and the milliseconds until a request may be allowed.
 
-	// Remove entries older than the current sliding window.
-	if err := r.client.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(cutoff, 10)).Err(); err != nil {
-		return false, err
-	}
+// slidingWindowScript keeps a sorted set of requests scored by their time in milliseconds.
+// ARGV holds the current time and window in milliseconds, the maximum number of requests,
+// and a unique member for the request.
+var slidingWindowScript = rd.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
 
-	// Count the number of requests in the sliding window.
-	count, err := r.client.ZCard(ctx, key).Result()
-	if err != nil {
-		return false, err
-	}
+-- Remove entries older than the current sliding window.
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
 
-	if int(count) >= maxRequests {
-		return false, nil
-	}
+local count = redis.call('ZCARD', key)
+if count >= limit then
+	-- The next request is allowed once the oldest request leaves the window.
+	local retry = window
+	local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+	if #oldest == 2 then
+		retry = tonumber(oldest[2]) + window - now
+	end
+	return {0, 0, retry}
+end
 
-	// Add the current request timestamp.
-	if err := r.client.ZAdd(ctx, key, rd.Z{
-		Score:  float64(now),
-		Member: now,
-	}).Err(); err != nil {
-		return false, err
-	}
+redis.call('ZADD', key, now, ARGV[4])
+redis.call('PEXPIRE', key, window)
+return {1, limit - count - 1, 0}
+`)
+
+// tokenBucketScript keeps a hash of the tokens left and when they were counted.
+// ARGV holds the current time in milliseconds, the milliseconds to earn a token, and the burst.
+var tokenBucketScript = rd.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+-- Refill the tokens earned since they were last counted.
+tokens = math.min(burst, tokens + math.max(0, now - ts) / interval)
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * interval)
+end
+
+redis.call('HSET', key, 'tokens', string.format('%.6f', tokens), 'ts', string.format('%.3f', now))
+-- The key is no longer needed once the bucket is full again.
+redis.call('PEXPIRE', key, math.ceil((burst - tokens) * interval) + 1)
+return {allowed, math.floor(tokens), retry}
+`)
+
+// gcraScript keeps the theoretical arrival time (TAT) of the next request, in milliseconds.
+// ARGV holds the current time in milliseconds, the milliseconds to earn a request, and the burst.
+var gcraScript = rd.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
 
-	// Set an expiration for cleanup.
-	r.client.Expire(ctx, key, window)
+local tat = tonumber(redis.call('GET', key)) or now
+if tat < now then
+	tat = now
+end
 
-	return true, nil
+local new_tat = tat + interval
+local allow_at = new_tat - burst * interval
+if now < allow_at then
+	return {0, 0, math.ceil(allow_at - now)}
+end
+
+redis.call('SET', key, string.format('%.3f', new_tat), 'PX', math.ceil(new_tat - now))
+return {1, math.floor((now - allow_at) / interval + 1e-9), 0}
+`)
+
+// AllowRequest checks the request with the script for the limit's algorithm.
+// With SlidingWindow, each request is stored under a unique member, so requests
+// made in the same millisecond are all counted.
+func (r *rateLimiterRepository) AllowRequest(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	nowMillis := float64(now.UnixMicro()) / 1000
+
+	var script *rd.Script
+	var args []interface{}
+	switch limit.Algorithm {
+	case SlidingWindow, "":
+		script = slidingWindowScript
+		args = []interface{}{now.UnixMilli(), limit.Window.Milliseconds(), limit.Requests, uuid.NewString()}
+	case TokenBucket:
+		script = tokenBucketScript
+		args = []interface{}{nowMillis, limit.interval(), limit.burst()}
+	case GCRA:
+		script = gcraScript
+		args = []interface{}{nowMillis, limit.interval(), limit.burst()}
+	default:
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", limit.Algorithm)
+	}
+
+	values, err := script.Run(ctx, r.client, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
 }
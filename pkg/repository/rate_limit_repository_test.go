@@ -2,17 +2,27 @@ package repository
 
 import (
 	"context"
-	"strconv"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redismock/v9"
 	rd "github.com/redis/go-redis/v9"
 )
 
+// newMiniredisClient starts an in-process Redis server and returns a client for it.
+func newMiniredisClient(t testing.TB) (*miniredis.Miniredis, *rd.Client) {
+	server := miniredis.RunT(t)
+	client := rd.NewClient(&rd.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
 func TestAllowRequest(t *testing.T) {
-	// Create a Redis client and a redismock instance.
-	client, mock := redismock.NewClientMock()
+	server, client := newMiniredisClient(t)
 	repo := NewRateLimiterRepository(client)
 	ctx := context.Background()
 
@@ -21,62 +31,151 @@ func TestAllowRequest(t *testing.T) {
 	window := time.Minute
 	maxRequests := 2
 
-	// Set a cutoff value for the current sliding window.
-	nowMillis := time.Now().UnixNano() / int64(time.Millisecond)
-	windowMillis := int64(window / time.Millisecond)
-	cutoff := nowMillis - windowMillis
-	cutoffStr := strconv.FormatInt(cutoff, 10)
-
-	// ---- First Request: No previous requests.
-	mock.ExpectZRemRangeByScore(key, "0", cutoffStr).SetVal(0)
-	mock.ExpectZCard(key).SetVal(0)
-	// For testing, we don't care about the exact timestamp value passed into ZAdd.
-	mock.ExpectZAdd(key, rd.Z{
-		Score:  float64(nowMillis),
-		Member: nowMillis,
-	}).SetVal(1)
-	mock.ExpectExpire(key, window).SetVal(true)
-
-	allowed, err := repo.AllowRequest(ctx, key, window, maxRequests)
+	// ---- First and second requests are allowed.
+	for i, expectedRemaining := range []int{1, 0} {
+		result, err := repo.AllowRequest(ctx, key, RateLimit{Requests: maxRequests, Window: window})
+		if err != nil {
+			t.Fatalf("unexpected error on request %d: %v", i+1, err)
+		}
+		if !result.Allowed || result.Remaining != expectedRemaining {
+			t.Errorf("expected request %d to be allowed with %d remaining, got %+v", i+1, expectedRemaining, result)
+		}
+	}
+
+	// ---- Third request exceeds the limit.
+	result, err := repo.AllowRequest(ctx, key, RateLimit{Requests: maxRequests, Window: window})
 	if err != nil {
-		t.Fatalf("unexpected error on first request: %v", err)
+		t.Fatalf("unexpected error on third request: %v", err)
+	}
+	if result.Allowed {
+		t.Errorf("expected third request to be denied, but it was allowed")
 	}
-	if !allowed {
-		t.Errorf("expected first request to be allowed, but it was denied")
+	if result.RetryAfter <= 0 || result.RetryAfter > window {
+		t.Errorf("expected to retry within the window, got %v", result.RetryAfter)
 	}
 
-	// ---- Second Request: Count should be 1.
-	nowMillis = time.Now().UnixNano() / int64(time.Millisecond)
-	mock.ExpectZRemRangeByScore(key, "0", cutoffStr).SetVal(0)
-	mock.ExpectZCard(key).SetVal(1)
-	mock.ExpectZAdd(key, rd.Z{
-		Score:  float64(nowMillis),
-		Member: nowMillis,
-	}).SetVal(1)
-	mock.ExpectExpire(key, window).SetVal(true)
-
-	allowed, err = repo.AllowRequest(ctx, key, window, maxRequests)
-	if err != nil {
-		t.Fatalf("unexpected error on second request: %v", err)
+	// Denied requests are not recorded, and the key expires with the window.
+	if n, _ := client.ZCard(ctx, key).Result(); n != 2 {
+		t.Errorf("expected 2 recorded requests, got %d", n)
 	}
-	if !allowed {
-		t.Errorf("expected second request to be allowed, but it was denied")
+	if ttl := server.TTL(key); ttl <= 0 || ttl > window {
+		t.Errorf("expected the key to expire within the window, got %v", ttl)
 	}
+}
 
-	// ---- Third Request: Count is now 2 so should be denied.
-	mock.ExpectZRemRangeByScore(key, "0", cutoffStr).SetVal(0)
-	mock.ExpectZCard(key).SetVal(2)
+func TestAllowRequestSameMillisecond(t *testing.T) {
+	_, client := newMiniredisClient(t)
+	repo := NewRateLimiterRepository(client)
+	ctx := context.Background()
 
-	allowed, err = repo.AllowRequest(ctx, key, window, maxRequests)
-	if err != nil {
-		t.Fatalf("unexpected error on third request: %v", err)
+	// Requests made in quick succession, often within the same millisecond,
+	// must each be counted.
+	for i := 0; i < 10; i++ {
+		if _, err := repo.AllowRequest(ctx, "rate_limit:burst", RateLimit{Requests: 100, Window: time.Minute}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 	}
-	if allowed {
-		t.Errorf("expected third request to be denied, but it was allowed")
+	if n, _ := client.ZCard(ctx, "rate_limit:burst").Result(); n != 10 {
+		t.Errorf("expected 10 recorded requests, got %d", n)
+	}
+}
+
+func TestAllowRequestBurstAlgorithms(t *testing.T) {
+	for _, algorithm := range []RateLimitAlgorithm{TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			server, client := newMiniredisClient(t)
+			repo := NewRateLimiterRepository(client)
+			ctx := context.Background()
+			// One request a minute, with bursts of up to three.
+			limit := RateLimit{Algorithm: algorithm, Requests: 1, Window: time.Minute, Burst: 3}
+
+			// ---- A burst of three requests is allowed at once.
+			for _, expectedRemaining := range []int{2, 1, 0} {
+				result, err := repo.AllowRequest(ctx, "key", limit)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !result.Allowed || result.Remaining != expectedRemaining {
+					t.Errorf("expected request to be allowed with %d remaining, got %+v", expectedRemaining, result)
+				}
+			}
+
+			// ---- The fourth must wait about a minute for the next request to be earned.
+			result, err := repo.AllowRequest(ctx, "key", limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Allowed || result.RetryAfter < 59*time.Second || result.RetryAfter > time.Minute {
+				t.Errorf("expected denial with a retry of about a minute, got %+v", result)
+			}
+
+			// ---- The key expires once it no longer affects any request.
+			if ttl := server.TTL("key"); ttl <= 2*time.Minute || ttl > 3*time.Minute+time.Second {
+				t.Errorf("expected the key to expire in about three minutes, got %v", ttl)
+			}
+		})
+	}
+}
+
+func TestAllowRequestConcurrent(t *testing.T) {
+	const (
+		maxRequests = 25
+		workers     = 20
+		perWorker   = 10
+	)
+
+	for _, algorithm := range []RateLimitAlgorithm{SlidingWindow, TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			_, client := newMiniredisClient(t)
+			repo := NewRateLimiterRepository(client)
+			ctx := context.Background()
+			limit := RateLimit{Algorithm: algorithm, Requests: maxRequests, Window: time.Hour}
+
+			// Many clients race for the same key; exactly maxRequests may get through.
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			errs := make(chan error, workers*perWorker)
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						result, err := repo.AllowRequest(ctx, "rate_limit:shared", limit)
+						if err != nil {
+							errs <- err
+							return
+						}
+						if result.Allowed {
+							allowed.Add(1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := allowed.Load(); got != maxRequests {
+				t.Errorf("expected exactly %d requests to be allowed, got %d", maxRequests, got)
+			}
+		})
+	}
+}
+
+func TestAllowRequestError(t *testing.T) {
+	// Errors from Redis are returned to the caller.
+	client, mock := redismock.NewClientMock()
+	repo := NewRateLimiterRepository(client)
+	mock.Regexp().ExpectEvalSha(".*", []string{"rate_limit:127.0.0.1"}).SetErr(errors.New("redis down"))
+
+	if _, err := repo.AllowRequest(context.Background(), "rate_limit:127.0.0.1", RateLimit{Requests: 2, Window: time.Minute}); err == nil {
+		t.Errorf("expected error but got nil")
 	}
 
-	// Verify that all expectations were met.
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %v", err)
+	// Unknown algorithms are rejected without calling Redis.
+	if _, err := repo.AllowRequest(context.Background(), "rate_limit:127.0.0.1", RateLimit{Algorithm: "leaky", Requests: 2, Window: time.Minute}); err == nil {
+		t.Errorf("expected error for an unknown algorithm but got nil")
 	}
 }
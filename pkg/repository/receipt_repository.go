@@ -3,37 +3,172 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/money"
 
 	"gorm.io/gorm"
 )
 
+// saveBatchSize is the number of receipts inserted per statement by SaveAll.
+const saveBatchSize = 100
+
 // ReceiptModel represents the receipt stored in the database.
 type ReceiptModel struct {
-	ID           string `gorm:"primaryKey;type:varchar(36)"`
-	Retailer     string
-	PurchaseDate string
+	ID string `gorm:"primaryKey;type:varchar(36);index:idx_receipt_tenant_purchase_date_id,priority:3"`
+	// TenantID is the tenant that owns the receipt. Every query is scoped to a tenant.
+	TenantID string `gorm:"type:varchar(64);uniqueIndex:idx_receipt_tenant_hash,priority:1;index:idx_receipt_tenant_purchase_date_id,priority:1"`
+	UserID   string `gorm:"type:varchar(64);index"` // The user who earns the points, if any.
+	Retailer string `gorm:"index"`
+	// MerchantID is the catalog merchant the retailer was mapped to at ingest, if any.
+	MerchantID   string `gorm:"type:varchar(36);index"`
+	PurchaseDate string `gorm:"index:idx_receipt_tenant_purchase_date_id,priority:2"`
 	PurchaseTime string
-	Total        string
-	Points       int
-	Hash         string      `gorm:"uniqueIndex;not null"`
-	Items        []ItemModel `gorm:"foreignKey:ReceiptID"`
+	Timezone     string
+	PurchasedAt  time.Time               `gorm:"index"` // Moment of purchase, in UTC.
+	Total        money.Cents             `gorm:"column:total_cents;index"`
+	Points       int                     `gorm:"index"`
+	RuleVersion  string                  `gorm:"type:varchar(64);index"` // The version of the rules that awarded Points.
+	Hash         string                  `gorm:"uniqueIndex:idx_receipt_tenant_hash,priority:2;not null"`
+	Items        []ItemModel             `gorm:"foreignKey:ReceiptID"`
+	Breakdown    []RuleContributionModel `gorm:"foreignKey:ReceiptID"`
+	Flags        []ReceiptFlagModel      `gorm:"foreignKey:ReceiptID"`
+	// Reversals are the voids and refunds of the receipt. The receipt itself is never changed.
+	Reversals []ReversalModel `gorm:"foreignKey:ReceiptID"`
 }
 
 // ItemModel represents an individual item within a receipt.
 type ItemModel struct {
 	ID               uint   `gorm:"primaryKey;autoIncrement"`
 	ReceiptID        string `gorm:"index;type:varchar(36)"`
+	TenantID         string `gorm:"index;type:varchar(64)"` // The tenant of the receipt.
 	ShortDescription string
-	Price            string
+	Price            money.Cents `gorm:"column:price_cents"`
+}
+
+// RuleContributionModel records the points a single rule, or campaign, contributed to a
+// receipt at the time it was scored.
+type RuleContributionModel struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	ReceiptID  string `gorm:"index;type:varchar(36)"`
+	Rule       string // The name of the rule or campaign.
+	CampaignID string `gorm:"type:varchar(36)"` // Set for the points of a campaign.
+	// Multiplier is the campaign's multiplier of the rules' points, or 0 if it has none.
+	Multiplier float64
+	Points     int
+	Items      []ItemContributionModel `gorm:"foreignKey:ContributionID"`
+}
+
+// ItemContributionModel records a line item that triggered a rule contribution.
+type ItemContributionModel struct {
+	ID               uint `gorm:"primaryKey;autoIncrement"`
+	ContributionID   uint `gorm:"index"`
+	ItemIndex        int
+	ShortDescription string
+	Price            money.Cents `gorm:"column:price_cents"`
+	Points           int
+}
+
+// ReceiptFlagModel records a reason to review a stored receipt, such as item prices
+// that do not add up to the total.
+type ReceiptFlagModel struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement"`
+	ReceiptID string `gorm:"index;type:varchar(36)"`
+	Reason    string
+	CreatedAt time.Time
+}
+
+// The kinds of reversals.
+const (
+	// ReversalVoid reverses every item of a receipt that has not been refunded yet.
+	ReversalVoid = "void"
+	// ReversalRefund reverses some of the items of a receipt.
+	ReversalRefund = "refund"
+)
+
+// ReversalModel records a void or partial refund of a stored receipt, and the points it
+// clawed back from the receipt's user.
+type ReversalModel struct {
+	ID        string `gorm:"primaryKey;type:varchar(36)"`
+	TenantID  string `gorm:"type:varchar(64);index"`
+	ReceiptID string `gorm:"index;type:varchar(36);uniqueIndex:idx_reversal_receipt_sequence,priority:1"`
+	// Sequence is the number of reversals of the receipt stored before this one. The points
+	// are computed from those reversals, so no two reversals of a receipt share a sequence.
+	Sequence  int                 `gorm:"uniqueIndex:idx_reversal_receipt_sequence,priority:2"`
+	Kind      string              `gorm:"type:varchar(16)"`
+	Points    int                 // The receipt's points clawed back, whether or not they had expired.
+	Items     []ReversalItemModel `gorm:"foreignKey:ReversalID"`
+	CreatedAt time.Time
+}
+
+// ReversalItemModel records an item of a receipt reversed by a void or refund. An item
+// can only be reversed once.
+type ReversalItemModel struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement"`
+	ReversalID string `gorm:"index;type:varchar(36)"`
+	ReceiptID  string `gorm:"type:varchar(36);uniqueIndex:idx_reversal_item_receipt_index,priority:1"`
+	ItemIndex  int    `gorm:"uniqueIndex:idx_reversal_item_receipt_index,priority:2"` // Index into the receipt's items.
+}
+
+// ReceiptFilter narrows the receipts returned by List. Empty fields are ignored.
+type ReceiptFilter struct {
+	Retailer         string
+	MerchantID       string
+	PurchaseDateFrom string       // Inclusive, YYYY-MM-DD.
+	PurchaseDateTo   string       // Inclusive, YYYY-MM-DD.
+	MinTotal         *money.Cents // Inclusive.
+	MaxTotal         *money.Cents // Inclusive.
+	MinPoints        *int
+	FlaggedOnly      bool // Only receipts flagged for review.
+}
+
+// ReceiptCursor identifies the last receipt of a page. Receipts are listed newest
+// purchase date first, with ties broken by descending ID.
+type ReceiptCursor struct {
+	PurchaseDate string
+	ID           string
 }
 
 // IReceiptRepository defines the interface for interacting with receipt persistence.
+// Receipts belong to a tenant: they are saved with their TenantID, which must be set,
+// and every lookup only finds the receipts of the given tenant.
 type IReceiptRepository interface {
+	// Save stores a receipt. If it belongs to a user, the points it earned are added to
+	// the user's ledger in the same transaction.
 	Save(ctx context.Context, receipt ReceiptModel) error
-	GetByID(ctx context.Context, id string) (ReceiptModel, error)
-	FindByHash(ctx context.Context, hash string) (ReceiptModel, error)
+	// SaveAll stores several receipts, and their ledger entries, in a single transaction.
+	// Either all receipts are saved or none are.
+	SaveAll(ctx context.Context, receipts []ReceiptModel) error
+	// SaveReversal stores a reversal of one of the tenant's receipts and, if the receipt belongs
+	// to a user, claws the reversal's points back from the user's ledger in the same transaction,
+	// except for those of the receipt's points that have expired.
+	// It returns ErrReversalConflict if the receipt does not have exactly Sequence reversals
+	// stored, and ErrAlreadyReversed if any of its items has been reversed before.
+	SaveReversal(ctx context.Context, reversal ReversalModel) error
+	GetByID(ctx context.Context, tenantID, id string) (ReceiptModel, error)
+	FindByHash(ctx context.Context, tenantID, hash string) (ReceiptModel, error)
+	// List returns up to limit receipts of the tenant matching the filter, starting after
+	// the given cursor. A nil cursor starts from the first receipt.
+	List(ctx context.Context, tenantID string, filter ReceiptFilter, after *ReceiptCursor, limit int) ([]ReceiptModel, error)
+	// UnmappedRetailers returns the distinct retailer names of the tenant's receipts that
+	// are not mapped to a merchant.
+	UnmappedRetailers(ctx context.Context, tenantID string) ([]string, error)
+	// MapMerchant maps the tenant's receipts of the retailers that are not mapped to a
+	// merchant yet to the merchant, and returns the number of receipts mapped.
+	MapMerchant(ctx context.Context, tenantID, merchantID string, retailers []string) (int64, error)
 }
 
+var (
+	// ErrMissingTenant is returned when saving a receipt without a tenant.
+	ErrMissingTenant = errors.New("receipt has no tenant")
+	// ErrAlreadyReversed is returned when reversing an item of a receipt that was already reversed.
+	ErrAlreadyReversed = errors.New("receipt item was already reversed")
+	// ErrReversalConflict is returned when reversing a receipt whose reversals changed since
+	// the reversal's points were computed.
+	ErrReversalConflict = errors.New("receipt was reversed concurrently")
+)
+
 // receiptRepository is a concrete implementation of IReceiptRepository using GORM.
 type receiptRepository struct {
 	db *gorm.DB
@@ -42,32 +177,264 @@ type receiptRepository struct {
 // NewReceiptRepository creates a new instance of the receipt repository.
 // It performs auto-migration to ensure the schema is up to date.
 func NewReceiptRepository(db *gorm.DB) IReceiptRepository {
-	// AutoMigrate ReceiptModel and ItemModel.
-	db.AutoMigrate(&ReceiptModel{}, &ItemModel{})
+	// AutoMigrate ReceiptModel, ItemModel, the points breakdown models, review flags, reversals
+	// and the points ledger receipts are saved with.
+	db.AutoMigrate(&ReceiptModel{}, &ItemModel{}, &RuleContributionModel{}, &ItemContributionModel{}, &ReceiptFlagModel{},
+		&ReversalModel{}, &ReversalItemModel{}, &LedgerEntryModel{})
+	// Amounts used to be stored as decimal strings; convert any such rows to cents.
+	backfillCents(db, &ReceiptModel{}, "total", "total_cents")
+	backfillCents(db, &ItemModel{}, "price", "price_cents")
+	backfillCents(db, &ItemContributionModel{}, "price", "price_cents")
+	// Receipts stored before timezones were supported were purchased in UTC.
+	db.Model(&ReceiptModel{}).
+		Where("purchased_at IS NULL").
+		Update("purchased_at", gorm.Expr("purchase_date || ' ' || purchase_time || ':00+00:00'"))
+	// Receipts stored before tenants were introduced belong to the default tenant, and
+	// the indexes they were unique and listed by are replaced by per-tenant ones.
+	db.Model(&ReceiptModel{}).Where("tenant_id IS NULL").Update("tenant_id", auth.DefaultTenant)
+	db.Model(&ItemModel{}).
+		Where("tenant_id IS NULL").
+		Update("tenant_id", gorm.Expr("(SELECT tenant_id FROM receipt_models WHERE receipt_models.id = item_models.receipt_id)"))
+	for _, index := range []string{"idx_receipt_models_hash", "idx_receipt_purchase_date_id"} {
+		if db.Migrator().HasIndex(&ReceiptModel{}, index) {
+			db.Migrator().DropIndex(&ReceiptModel{}, index)
+		}
+	}
+	// Reversals stored before they were sequenced are numbered in the order they were stored.
+	db.Model(&ReversalModel{}).
+		Where("sequence IS NULL").
+		Update("sequence", gorm.Expr(`(SELECT COUNT(*) FROM reversal_models earlier
+			WHERE earlier.receipt_id = reversal_models.receipt_id
+			AND (earlier.created_at < reversal_models.created_at OR (earlier.created_at = reversal_models.created_at AND earlier.id < reversal_models.id)))`))
 	return &receiptRepository{
 		db: db,
 	}
 }
 
-// Save stores a receipt and its items in the database.
+// Save stores a receipt and its items, under the receipt's tenant, and its ledger entry in a transaction.
 func (r *receiptRepository) Save(ctx context.Context, receipt ReceiptModel) error {
-	result := r.db.WithContext(ctx).Create(&receipt)
-	return result.Error
+	if err := assignTenant(&receipt); err != nil {
+		return err
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&receipt).Error; err != nil {
+			return err
+		}
+		return appendEntries(tx, earnedEntries([]ReceiptModel{receipt}))
+	})
 }
 
-// GetByID retrieves a receipt by its ID, preloading associated items.
-func (r *receiptRepository) GetByID(ctx context.Context, id string) (ReceiptModel, error) {
+// SaveAll stores receipts and their items in a single transaction, inserting in batches.
+func (r *receiptRepository) SaveAll(ctx context.Context, receipts []ReceiptModel) error {
+	if len(receipts) == 0 {
+		return nil
+	}
+	for i := range receipts {
+		if err := assignTenant(&receipts[i]); err != nil {
+			return err
+		}
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&receipts, saveBatchSize).Error; err != nil {
+			return err
+		}
+		return appendEntries(tx, earnedEntries(receipts))
+	})
+}
+
+// SaveReversal checks the receipt, its reversals and its reversed items, then stores the
+// reversal and its ledger entry in a transaction. A concurrent reversal may be stored after
+// the checks, in which case the reversal fails on the unique index of its sequence or items.
+func (r *receiptRepository) SaveReversal(ctx context.Context, reversal ReversalModel) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var receipt ReceiptModel
+		if err := tx.First(&receipt, "tenant_id = ? AND id = ?", reversal.TenantID, reversal.ReceiptID).Error; err != nil {
+			return err
+		}
+		indexes := make([]int, len(reversal.Items))
+		for i := range reversal.Items {
+			reversal.Items[i].ReceiptID = reversal.ReceiptID
+			reversal.Items[i].ReversalID = reversal.ID
+			indexes[i] = reversal.Items[i].ItemIndex
+		}
+		var reversals int64
+		if err := tx.Model(&ReversalModel{}).Where("receipt_id = ?", reversal.ReceiptID).Count(&reversals).Error; err != nil {
+			return err
+		}
+		if reversals != int64(reversal.Sequence) {
+			return ErrReversalConflict
+		}
+		var reversed int64
+		if err := tx.Model(&ReversalItemModel{}).
+			Where("receipt_id = ? AND item_index IN ?", reversal.ReceiptID, indexes).
+			Count(&reversed).Error; err != nil {
+			return err
+		}
+		if reversed > 0 {
+			return ErrAlreadyReversed
+		}
+		if err := tx.Omit("Items").Create(&reversal).Error; err != nil {
+			if isDuplicateKey(tx, err) {
+				return ErrReversalConflict
+			}
+			return err
+		}
+		if len(reversal.Items) > 0 {
+			if err := tx.Create(&reversal.Items).Error; err != nil {
+				if isDuplicateKey(tx, err) {
+					return ErrAlreadyReversed
+				}
+				return err
+			}
+		}
+		if receipt.UserID == "" || reversal.Points == 0 {
+			return nil
+		}
+		// Points of the receipt that expired were already debited, so at most what is left of
+		// the receipt's points in the ledger is clawed back.
+		return tx.Exec(`INSERT INTO ledger_entry_models (tenant_id, user_id, receipt_id, kind, points, created_at)
+			SELECT ?, ?, ?, ?, -MIN(?, remaining), ? FROM (
+				SELECT COALESCE(SUM(points), 0) AS remaining FROM ledger_entry_models WHERE tenant_id = ? AND receipt_id = ?
+			) WHERE remaining > 0`,
+			receipt.TenantID, receipt.UserID, receipt.ID, LedgerKindReverse, reversal.Points, time.Now(),
+			receipt.TenantID, receipt.ID).Error
+	})
+}
+
+// isDuplicateKey reports whether err is a violation of a unique index, as translated by the
+// database's dialect.
+func isDuplicateKey(db *gorm.DB, err error) bool {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// GetByID retrieves a receipt of the tenant by its ID, preloading associated items, its points
+// breakdown, review flags and reversals.
+func (r *receiptRepository) GetByID(ctx context.Context, tenantID, id string) (ReceiptModel, error) {
 	var receipt ReceiptModel
-	result := r.db.WithContext(ctx).Preload("Items").First(&receipt, "id = ?", id)
+	result := r.db.WithContext(ctx).
+		Preload("Items", itemsOf(tenantID)).
+		Preload("Breakdown", orderByID).
+		Preload("Breakdown.Items", orderByID).
+		Preload("Flags", orderByID).
+		Preload("Reversals", orderByCreation).
+		Preload("Reversals.Items", orderByID).
+		First(&receipt, "tenant_id = ? AND id = ?", tenantID, id)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return receipt, result.Error
 	}
 	return receipt, result.Error
 }
 
-// FindByHash retrieves a receipt by its computed hash. If not found, returns an error.
-func (r *receiptRepository) FindByHash(ctx context.Context, hash string) (ReceiptModel, error) {
+// FindByHash retrieves a receipt of the tenant by its computed hash. If not found, returns an error.
+func (r *receiptRepository) FindByHash(ctx context.Context, tenantID, hash string) (ReceiptModel, error) {
 	var receipt ReceiptModel
-	result := r.db.WithContext(ctx).Where("hash = ?", hash).Preload("Items").First(&receipt)
+	result := r.db.WithContext(ctx).Where("tenant_id = ? AND hash = ?", tenantID, hash).Preload("Items", itemsOf(tenantID)).First(&receipt)
 	return receipt, result.Error
 }
+
+// List returns a page of the tenant's receipts matching the filter, preloading associated items and review flags.
+func (r *receiptRepository) List(ctx context.Context, tenantID string, filter ReceiptFilter, after *ReceiptCursor, limit int) ([]ReceiptModel, error) {
+	query := r.db.WithContext(ctx).Model(&ReceiptModel{}).Where("tenant_id = ?", tenantID)
+	if filter.Retailer != "" {
+		query = query.Where("retailer = ?", filter.Retailer)
+	}
+	if filter.MerchantID != "" {
+		query = query.Where("merchant_id = ?", filter.MerchantID)
+	}
+	if filter.PurchaseDateFrom != "" {
+		query = query.Where("purchase_date >= ?", filter.PurchaseDateFrom)
+	}
+	if filter.PurchaseDateTo != "" {
+		query = query.Where("purchase_date <= ?", filter.PurchaseDateTo)
+	}
+	if filter.MinTotal != nil {
+		query = query.Where("total_cents >= ?", *filter.MinTotal)
+	}
+	if filter.MaxTotal != nil {
+		query = query.Where("total_cents <= ?", *filter.MaxTotal)
+	}
+	if filter.MinPoints != nil {
+		query = query.Where("points >= ?", *filter.MinPoints)
+	}
+	if filter.FlaggedOnly {
+		query = query.Where("EXISTS (SELECT 1 FROM receipt_flag_models WHERE receipt_flag_models.receipt_id = receipt_models.id)")
+	}
+	if after != nil {
+		query = query.Where("purchase_date < ? OR (purchase_date = ? AND id < ?)",
+			after.PurchaseDate, after.PurchaseDate, after.ID)
+	}
+
+	var receipts []ReceiptModel
+	result := query.
+		Order("purchase_date DESC").
+		Order("id DESC").
+		Limit(limit).
+		Preload("Items", itemsOf(tenantID)).
+		Preload("Breakdown", orderByID).
+		Preload("Flags", orderByID).
+		Find(&receipts)
+	return receipts, result.Error
+}
+
+// UnmappedRetailers lists the retailers of receipts with an empty merchant ID.
+func (r *receiptRepository) UnmappedRetailers(ctx context.Context, tenantID string) ([]string, error) {
+	var retailers []string
+	result := r.db.WithContext(ctx).Model(&ReceiptModel{}).
+		Where("tenant_id = ? AND (merchant_id = '' OR merchant_id IS NULL)", tenantID).
+		Distinct().Order("retailer").
+		Pluck("retailer", &retailers)
+	return retailers, result.Error
+}
+
+// MapMerchant sets the merchant ID of the unmapped receipts of the retailers.
+func (r *receiptRepository) MapMerchant(ctx context.Context, tenantID, merchantID string, retailers []string) (int64, error) {
+	if len(retailers) == 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).Model(&ReceiptModel{}).
+		Where("tenant_id = ? AND (merchant_id = '' OR merchant_id IS NULL) AND retailer IN ?", tenantID, retailers).
+		Update("merchant_id", merchantID)
+	return result.RowsAffected, result.Error
+}
+
+// backfillCents fills an integer cents column from a legacy decimal string column,
+// if the legacy column exists, for rows that have not been converted yet.
+func backfillCents(db *gorm.DB, model interface{}, legacyColumn, column string) {
+	if !db.Migrator().HasColumn(model, legacyColumn) {
+		return
+	}
+	db.Model(model).
+		Where(column+" IS NULL AND "+legacyColumn+" IS NOT NULL").
+		Update(column, gorm.Expr("CAST(ROUND(CAST("+legacyColumn+" AS REAL) * 100) AS INTEGER)"))
+}
+
+// assignTenant checks that the receipt has a tenant and assigns its items to it.
+func assignTenant(receipt *ReceiptModel) error {
+	if receipt.TenantID == "" {
+		return ErrMissingTenant
+	}
+	for i := range receipt.Items {
+		receipt.Items[i].TenantID = receipt.TenantID
+	}
+	return nil
+}
+
+// itemsOf scopes preloaded items to the tenant, in insertion order.
+func itemsOf(tenantID string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantID).Order("id")
+	}
+}
+
+// orderByID orders preloaded associations by insertion order.
+func orderByID(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
+
+// orderByCreation orders preloaded associations with random IDs by creation time.
+func orderByCreation(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at").Order("id")
+}
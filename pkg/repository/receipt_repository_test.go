@@ -2,11 +2,31 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
 	"testing"
 
 	"receipt_processor/pkg/database"
+	"receipt_processor/pkg/money"
+
+	"gorm.io/gorm"
 )
 
+// testTenant owns the receipts saved by the tests.
+const testTenant = "tenant-a"
+
+// newTestDB returns a dedicated in-memory database of the given name, so the data of other
+// tests does not interfere.
+func newTestDB(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	db, err := database.New("file:" + name + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+	return db
+}
+
 func TestReceiptRepository_SaveAndGet(t *testing.T) {
 	// Set up an in-memory SQLite database.
 	db, err := database.New("file::memory:?cache=shared")
@@ -26,15 +46,16 @@ func TestReceiptRepository_SaveAndGet(t *testing.T) {
 			name: "Valid receipt 1",
 			receipt: ReceiptModel{
 				ID:           "id1",
+				TenantID:     testTenant,
 				Retailer:     "Target",
 				PurchaseDate: "2022-01-01",
 				PurchaseTime: "13:01",
-				Total:        "35.35",
+				Total:        money.MustParse("35.35"),
 				Points:       33,
 				Hash:         "hash1",
 				Items: []ItemModel{
-					{ShortDescription: "Item A", Price: "10.00"},
-					{ShortDescription: "Item B", Price: "25.35"},
+					{ShortDescription: "Item A", Price: money.MustParse("10.00")},
+					{ShortDescription: "Item B", Price: money.MustParse("25.35")},
 				},
 			},
 		},
@@ -42,17 +63,18 @@ func TestReceiptRepository_SaveAndGet(t *testing.T) {
 			name: "Valid receipt 2",
 			receipt: ReceiptModel{
 				ID:           "id2",
+				TenantID:     testTenant,
 				Retailer:     "M&M Corner Market",
 				PurchaseDate: "2022-03-20",
 				PurchaseTime: "14:33",
-				Total:        "9.00",
+				Total:        money.MustParse("9.00"),
 				Points:       109,
 				Hash:         "hash2",
 				Items: []ItemModel{
-					{ShortDescription: "Gatorade", Price: "2.25"},
-					{ShortDescription: "Gatorade", Price: "2.25"},
-					{ShortDescription: "Gatorade", Price: "2.25"},
-					{ShortDescription: "Gatorade", Price: "2.25"},
+					{ShortDescription: "Gatorade", Price: money.MustParse("2.25")},
+					{ShortDescription: "Gatorade", Price: money.MustParse("2.25")},
+					{ShortDescription: "Gatorade", Price: money.MustParse("2.25")},
+					{ShortDescription: "Gatorade", Price: money.MustParse("2.25")},
 				},
 			},
 		},
@@ -66,7 +88,7 @@ func TestReceiptRepository_SaveAndGet(t *testing.T) {
 			}
 
 			// Retrieve the receipt by ID.
-			saved, err := repo.GetByID(ctx, tc.receipt.ID)
+			saved, err := repo.GetByID(ctx, testTenant, tc.receipt.ID)
 			if err != nil {
 				t.Fatalf("failed to get receipt by ID: %v", err)
 			}
@@ -81,7 +103,7 @@ func TestReceiptRepository_SaveAndGet(t *testing.T) {
 			}
 
 			// Retrieve the receipt by Hash.
-			found, err := repo.FindByHash(ctx, tc.receipt.Hash)
+			found, err := repo.FindByHash(ctx, testTenant, tc.receipt.Hash)
 			if err != nil {
 				t.Fatalf("failed to find receipt by hash: %v", err)
 			}
@@ -105,14 +127,15 @@ func TestReceiptRepository_Duplicate(t *testing.T) {
 	// Prepare a receipt.
 	receipt := ReceiptModel{
 		ID:           "dup1",
+		TenantID:     testTenant,
 		Retailer:     "Target",
 		PurchaseDate: "2022-01-01",
 		PurchaseTime: "13:01",
-		Total:        "35.35",
+		Total:        money.MustParse("35.35"),
 		Points:       33,
 		Hash:         "dup-hash",
 		Items: []ItemModel{
-			{ShortDescription: "Item A", Price: "10.00"},
+			{ShortDescription: "Item A", Price: money.MustParse("10.00")},
 		},
 	}
 
@@ -129,3 +152,308 @@ func TestReceiptRepository_Duplicate(t *testing.T) {
 		t.Fatalf("expected error when saving duplicate receipt, got nil")
 	}
 }
+
+func TestReceiptRepository_List(t *testing.T) {
+	db := newTestDB(t, "list_test")
+
+	repo := NewReceiptRepository(db)
+	ctx := context.Background()
+
+	receipts := []ReceiptModel{
+		{ID: "list-a", TenantID: testTenant, Retailer: "Target", PurchaseDate: "2022-01-01", Total: money.MustParse("35.35"), Points: 33, Hash: "list-hash-a"},
+		{ID: "list-b", TenantID: testTenant, Retailer: "Target", PurchaseDate: "2022-02-01", Total: money.MustParse("9.00"), Points: 109, Hash: "list-hash-b"},
+		{ID: "list-c", TenantID: testTenant, Retailer: "Walgreens", PurchaseDate: "2022-02-01", Total: money.MustParse("120.00"), Points: 80, Hash: "list-hash-c"},
+		{ID: "list-d", TenantID: testTenant, Retailer: "Walgreens", PurchaseDate: "2022-03-01", Total: money.MustParse("2.65"), Points: 15, Hash: "list-hash-d"},
+	}
+	for _, receipt := range receipts {
+		if err := repo.Save(ctx, receipt); err != nil {
+			t.Fatalf("failed to save receipt: %v", err)
+		}
+	}
+
+	minPoints := 50
+	minTotal, maxTotal := money.MustParse("5.00"), money.MustParse("100.00")
+	testCases := []struct {
+		name        string
+		filter      ReceiptFilter
+		after       *ReceiptCursor
+		limit       int
+		expectedIDs []string
+	}{
+		{
+			name:        "No filter",
+			limit:       10,
+			expectedIDs: []string{"list-d", "list-c", "list-b", "list-a"},
+		},
+		{
+			name:        "Limit",
+			limit:       2,
+			expectedIDs: []string{"list-d", "list-c"},
+		},
+		{
+			name:        "After cursor",
+			after:       &ReceiptCursor{PurchaseDate: "2022-02-01", ID: "list-c"},
+			limit:       10,
+			expectedIDs: []string{"list-b", "list-a"},
+		},
+		{
+			name:        "Retailer",
+			filter:      ReceiptFilter{Retailer: "Target"},
+			limit:       10,
+			expectedIDs: []string{"list-b", "list-a"},
+		},
+		{
+			name:        "Purchase date range",
+			filter:      ReceiptFilter{PurchaseDateFrom: "2022-02-01", PurchaseDateTo: "2022-02-28"},
+			limit:       10,
+			expectedIDs: []string{"list-c", "list-b"},
+		},
+		{
+			name:        "Total range",
+			filter:      ReceiptFilter{MinTotal: &minTotal, MaxTotal: &maxTotal},
+			limit:       10,
+			expectedIDs: []string{"list-b", "list-a"},
+		},
+		{
+			name:        "Minimum points",
+			filter:      ReceiptFilter{MinPoints: &minPoints},
+			limit:       10,
+			expectedIDs: []string{"list-c", "list-b"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := repo.List(ctx, testTenant, tc.filter, tc.after, tc.limit)
+			if err != nil {
+				t.Fatalf("failed to list receipts: %v", err)
+			}
+			var ids []string
+			for _, receipt := range found {
+				ids = append(ids, receipt.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tc.expectedIDs, ",") {
+				t.Errorf("expected %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+}
+
+func TestReceiptRepository_SaveAll(t *testing.T) {
+	// Set up an in-memory SQLite database.
+	db, err := database.New("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+
+	repo := NewReceiptRepository(db)
+	ctx := context.Background()
+
+	// All receipts of a valid batch are saved.
+	batch := []ReceiptModel{
+		{ID: "batch1", TenantID: testTenant, Retailer: "Target", Total: money.MustParse("1.00"), Hash: "batch-hash1", Items: []ItemModel{{ShortDescription: "Item A", Price: money.MustParse("1.00")}}},
+		{ID: "batch2", TenantID: testTenant, Retailer: "Target", Total: money.MustParse("2.00"), Hash: "batch-hash2", Items: []ItemModel{{ShortDescription: "Item B", Price: money.MustParse("2.00")}}},
+	}
+	if err := repo.SaveAll(ctx, batch); err != nil {
+		t.Fatalf("failed to save batch: %v", err)
+	}
+	for _, receipt := range batch {
+		saved, err := repo.GetByID(ctx, testTenant, receipt.ID)
+		if err != nil {
+			t.Fatalf("failed to get receipt %s: %v", receipt.ID, err)
+		}
+		if len(saved.Items) != 1 {
+			t.Errorf("expected 1 item for %s, got %d", receipt.ID, len(saved.Items))
+		}
+	}
+
+	// A batch containing a duplicate hash is rolled back entirely.
+	failing := []ReceiptModel{
+		{ID: "batch3", TenantID: testTenant, Retailer: "Target", Total: money.MustParse("3.00"), Hash: "batch-hash3"},
+		{ID: "batch4", TenantID: testTenant, Retailer: "Target", Total: money.MustParse("4.00"), Hash: "batch-hash1"},
+	}
+	if err := repo.SaveAll(ctx, failing); err == nil {
+		t.Fatalf("expected error when saving a batch with a duplicate hash, got nil")
+	}
+	if _, err := repo.GetByID(ctx, testTenant, "batch3"); err == nil {
+		t.Errorf("expected batch3 to be rolled back, but it was saved")
+	}
+}
+
+func TestReceiptRepository_Tenants(t *testing.T) {
+	db := newTestDB(t, "tenant_test")
+
+	repo := NewReceiptRepository(db)
+	ctx := context.Background()
+
+	// The same hash may be stored once per tenant.
+	receipts := []ReceiptModel{
+		{ID: "tenant-a1", TenantID: "tenant-a", Retailer: "Target", PurchaseDate: "2022-01-01", Hash: "shared-hash", Items: []ItemModel{{ShortDescription: "Item A", Price: money.MustParse("1.00")}}},
+		{ID: "tenant-b1", TenantID: "tenant-b", Retailer: "Target", PurchaseDate: "2022-01-01", Hash: "shared-hash", Items: []ItemModel{{ShortDescription: "Item A", Price: money.MustParse("1.00")}}},
+	}
+	for _, receipt := range receipts {
+		if err := repo.Save(ctx, receipt); err != nil {
+			t.Fatalf("failed to save receipt %s: %v", receipt.ID, err)
+		}
+	}
+
+	// Every query only sees the receipts of its tenant.
+	found, err := repo.FindByHash(ctx, "tenant-b", "shared-hash")
+	if err != nil || found.ID != "tenant-b1" {
+		t.Errorf("expected tenant-b1 by hash, got %q (err: %v)", found.ID, err)
+	}
+	saved, err := repo.GetByID(ctx, "tenant-a", "tenant-a1")
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if len(saved.Items) != 1 || saved.Items[0].TenantID != "tenant-a" {
+		t.Errorf("expected one item of tenant-a, got %+v", saved.Items)
+	}
+	if _, err := repo.GetByID(ctx, "tenant-b", "tenant-a1"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected gorm.ErrRecordNotFound for another tenant's receipt, got %v", err)
+	}
+	list, err := repo.List(ctx, "tenant-a", ReceiptFilter{}, nil, 10)
+	if err != nil {
+		t.Fatalf("failed to list receipts: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != "tenant-a1" {
+		t.Errorf("expected only tenant-a1, got %+v", list)
+	}
+
+	// Receipts without a tenant are rejected.
+	if err := repo.Save(ctx, ReceiptModel{ID: "no-tenant", Hash: "no-tenant-hash"}); !errors.Is(err, ErrMissingTenant) {
+		t.Errorf("expected ErrMissingTenant, got %v", err)
+	}
+}
+
+func TestReceiptRepository_SaveReversal(t *testing.T) {
+	db := newTestDB(t, "reversal_test")
+
+	repo := NewReceiptRepository(db)
+	ledger := NewLedgerRepository(db)
+	ctx := context.Background()
+
+	receipt := ReceiptModel{
+		ID: "reversal-1", TenantID: testTenant, UserID: "user-1", Retailer: "Target", PurchaseDate: "2022-01-01",
+		Points: 40, Hash: "reversal-hash-1",
+		Items: []ItemModel{
+			{ShortDescription: "Item A", Price: money.MustParse("1.00")},
+			{ShortDescription: "Item B", Price: money.MustParse("2.00")},
+		},
+	}
+	if err := repo.Save(ctx, receipt); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+
+	// A refund is stored with the receipt and claws its points back from the user.
+	refund := ReversalModel{
+		ID: "refund-1", TenantID: testTenant, ReceiptID: "reversal-1", Kind: ReversalRefund, Points: 15,
+		Items: []ReversalItemModel{{ItemIndex: 1}},
+	}
+	if err := repo.SaveReversal(ctx, refund); err != nil {
+		t.Fatalf("failed to save reversal: %v", err)
+	}
+	saved, err := repo.GetByID(ctx, testTenant, "reversal-1")
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if saved.Points != 40 || len(saved.Reversals) != 1 || saved.Reversals[0].Points != 15 ||
+		len(saved.Reversals[0].Items) != 1 || saved.Reversals[0].Items[0].ItemIndex != 1 {
+		t.Errorf("expected the receipt to keep its points and have one reversal of item 1, got %+v", saved)
+	}
+	entries, err := ledger.Entries(ctx, testTenant, "user-1", 1)
+	if err != nil || len(entries) != 1 || entries[0].Kind != LedgerKindReverse || entries[0].Points != -15 || entries[0].ReceiptID != "reversal-1" {
+		t.Errorf("expected the latest entry to claw back 15 points, got %+v (err: %v)", entries, err)
+	}
+
+	// An item can only be reversed once, and a failed reversal claws nothing back.
+	void := ReversalModel{
+		ID: "void-1", TenantID: testTenant, ReceiptID: "reversal-1", Sequence: 1, Kind: ReversalVoid, Points: 25,
+		Items: []ReversalItemModel{{ItemIndex: 0}, {ItemIndex: 1}},
+	}
+	if err := repo.SaveReversal(ctx, void); !errors.Is(err, ErrAlreadyReversed) {
+		t.Errorf("expected ErrAlreadyReversed, got %v", err)
+	}
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 25 {
+		t.Errorf("expected balance 25, got %d", balance)
+	}
+
+	// Receipts of other tenants cannot be reversed.
+	other := ReversalModel{ID: "void-2", TenantID: "tenant-b", ReceiptID: "reversal-1", Kind: ReversalVoid, Items: []ReversalItemModel{{ItemIndex: 0}}}
+	if err := repo.SaveReversal(ctx, other); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected gorm.ErrRecordNotFound for another tenant's receipt, got %v", err)
+	}
+}
+
+func TestReceiptRepository_SaveReversalRace(t *testing.T) {
+	db := newTestDB(t, "reversal_race_test")
+	// SQLite fails one of two overlapping writers on a shared in-memory database, so run the
+	// transactions one at a time: whichever starts first, the outcome is the same.
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get the connection pool: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	repo := NewReceiptRepository(db)
+	ledger := NewLedgerRepository(db)
+	ctx := context.Background()
+
+	receipt := ReceiptModel{
+		ID: "race-1", TenantID: testTenant, UserID: "user-1", Retailer: "Target", PurchaseDate: "2022-01-01",
+		Points: 40, Hash: "race-hash-1",
+		Items: []ItemModel{
+			{ShortDescription: "Item A", Price: money.MustParse("1.00")},
+			{ShortDescription: "Item B", Price: money.MustParse("2.00")},
+			{ShortDescription: "Item C", Price: money.MustParse("3.00")},
+		},
+	}
+	if err := repo.Save(ctx, receipt); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+
+	// race saves the reversals at once, and checks that exactly one is stored and the other
+	// fails with the expected error.
+	race := func(expected error, reversals ...ReversalModel) {
+		t.Helper()
+		start := make(chan struct{})
+		errs := make(chan error, len(reversals))
+		var wg sync.WaitGroup
+		for _, reversal := range reversals {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				errs <- repo.SaveReversal(ctx, reversal)
+			}()
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+		saved := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				saved++
+			case !errors.Is(err, expected):
+				t.Errorf("expected %v, got %v", expected, err)
+			}
+		}
+		if saved != 1 {
+			t.Errorf("expected exactly one reversal to be stored, got %d", saved)
+		}
+	}
+	refund := func(id string, sequence, item int) ReversalModel {
+		return ReversalModel{
+			ID: id, TenantID: testTenant, ReceiptID: "race-1", Sequence: sequence, Kind: ReversalRefund, Points: 10,
+			Items: []ReversalItemModel{{ItemIndex: item}},
+		}
+	}
+
+	// Reversals computed from the same stored reversals conflict, since the points of the one
+	// stored second no longer follow from them, whether they reverse the same items or not.
+	race(ErrReversalConflict, refund("race-refund-1", 0, 0), refund("race-refund-2", 0, 0))
+	race(ErrReversalConflict, refund("race-refund-3", 1, 1), refund("race-refund-4", 1, 2))
+	if balance, _ := ledger.Balance(ctx, testTenant, "user-1"); balance != 20 {
+		t.Errorf("expected two clawbacks, leaving a balance of 20, got %d", balance)
+	}
+}
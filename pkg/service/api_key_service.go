@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix starts every issued API key, so keys are easy to recognize, e.g. in leaked secrets.
+const APIKeyPrefix = "rp_"
+
+// apiKeyDisplayLength is the number of leading characters of a key stored to recognize it by.
+const apiKeyDisplayLength = len(APIKeyPrefix) + 8
+
+// ErrInvalidAPIKey is returned when an API key is unknown or has been revoked.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyDTO describes an issued API key, without the key itself.
+type APIKeyDTO struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	TenantID  string     `json:"tenantId"`
+	Admin     bool       `json:"admin"`
+	Prefix    string     `json:"prefix"` // The first characters of the key.
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// IAPIKeyService issues, revokes and checks API keys.
+type IAPIKeyService interface {
+	// Create issues a new key to the named client of a tenant, which may use the admin API
+	// if admin is set. The key itself is only returned here; just its hash is stored.
+	Create(ctx context.Context, name, tenantID string, admin bool) (string, APIKeyDTO, error)
+	// Revoke revokes the key with the given ID, so it no longer authenticates.
+	Revoke(ctx context.Context, id string) error
+	// List returns every issued key, oldest first.
+	List(ctx context.Context) ([]APIKeyDTO, error)
+	// Authenticate returns the identity of the client the key was issued to,
+	// or ErrInvalidAPIKey if the key is unknown or revoked.
+	Authenticate(ctx context.Context, key string) (auth.Identity, error)
+}
+
+// apiKeyService is the concrete implementation of IAPIKeyService.
+type apiKeyService struct {
+	apiKeyRepo repository.IAPIKeyRepository
+}
+
+// NewAPIKeyService creates a new instance of the API key service.
+func NewAPIKeyService(apiKeyRepo repository.IAPIKeyRepository) IAPIKeyService {
+	return &apiKeyService{
+		apiKeyRepo: apiKeyRepo,
+	}
+}
+
+// HashAPIKey returns the hash an API key is stored under. Keys are random and long,
+// so a fast hash is enough to keep them secret and still look them up directly.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a key from 32 random bytes and stores its hash.
+func (s *apiKeyService) Create(ctx context.Context, name, tenantID string, admin bool) (string, APIKeyDTO, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", APIKeyDTO{}, errors.New("API key name is required")
+	}
+	tenantID = strings.TrimSpace(tenantID)
+	if tenantID == "" {
+		return "", APIKeyDTO{}, errors.New("API key tenant is required")
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKeyDTO{}, err
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	model := repository.APIKeyModel{
+		ID:        uuid.New().String(),
+		Name:      name,
+		TenantID:  tenantID,
+		Admin:     admin,
+		Prefix:    key[:apiKeyDisplayLength],
+		Hash:      HashAPIKey(key),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.apiKeyRepo.Save(ctx, model); err != nil {
+		return "", APIKeyDTO{}, err
+	}
+	return key, convertAPIKeyModel(model), nil
+}
+
+// Revoke revokes the key now.
+func (s *apiKeyService) Revoke(ctx context.Context, id string) error {
+	return s.apiKeyRepo.Revoke(ctx, id, time.Now().UTC())
+}
+
+// List returns every issued key.
+func (s *apiKeyService) List(ctx context.Context) ([]APIKeyDTO, error) {
+	models, err := s.apiKeyRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKeyDTO, len(models))
+	for i, model := range models {
+		keys[i] = convertAPIKeyModel(model)
+	}
+	return keys, nil
+}
+
+// Authenticate looks the key up by its hash.
+func (s *apiKeyService) Authenticate(ctx context.Context, key string) (auth.Identity, error) {
+	model, err := s.apiKeyRepo.FindByHash(ctx, HashAPIKey(key))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return auth.Identity{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	if model.RevokedAt != nil {
+		return auth.Identity{}, ErrInvalidAPIKey
+	}
+	return auth.Identity{ClientID: model.ID, Name: model.Name, Method: auth.MethodAPIKey, TenantID: model.TenantID, Admin: model.Admin}, nil
+}
+
+// convertAPIKeyModel converts a stored API key to its DTO.
+func convertAPIKeyModel(model repository.APIKeyModel) APIKeyDTO {
+	return APIKeyDTO{
+		ID:        model.ID,
+		Name:      model.Name,
+		TenantID:  model.TenantID,
+		Admin:     model.Admin,
+		Prefix:    model.Prefix,
+		CreatedAt: model.CreatedAt,
+		RevokedAt: model.RevokedAt,
+	}
+}
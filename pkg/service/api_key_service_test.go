@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+)
+
+func TestAPIKeyService(t *testing.T) {
+	db := newTestDB(t, "api_key_service_test")
+	svc := NewAPIKeyService(repository.NewAPIKeyRepository(db))
+	ctx := context.Background()
+
+	// ---- A new key authenticates as the client it was issued to.
+	key, info, err := svc.Create(ctx, "  Acme Corp ", "acme", false)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || !strings.HasPrefix(key, info.Prefix) || len(key) != 46 {
+		t.Errorf("unexpected key %q with prefix %q", key, info.Prefix)
+	}
+	identity, err := svc.Authenticate(ctx, key)
+	expected := auth.Identity{ClientID: info.ID, Name: "Acme Corp", Method: auth.MethodAPIKey, TenantID: "acme"}
+	if err != nil || identity != expected {
+		t.Errorf("expected identity %+v, got %+v, %v", expected, identity, err)
+	}
+
+	// ---- Admin keys authenticate as admins of their tenant.
+	adminKey, adminInfo, err := svc.Create(ctx, "Acme Marketing", "acme", true)
+	if err != nil {
+		t.Fatalf("failed to create admin key: %v", err)
+	}
+	if identity, err := svc.Authenticate(ctx, adminKey); err != nil || !identity.Admin || !adminInfo.Admin || identity.TenantID != "acme" {
+		t.Errorf("expected an admin of acme, got %+v, %v", identity, err)
+	}
+
+	// ---- Only the hash of the key is stored.
+	keys, err := svc.List(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("expected two keys, got %+v, %v", keys, err)
+	}
+	var stored repository.APIKeyModel
+	db.First(&stored, "id = ?", info.ID)
+	if stored.Hash != HashAPIKey(key) || strings.Contains(stored.Hash, key) {
+		t.Errorf("expected only the hash of the key to be stored, got %+v", stored)
+	}
+
+	// ---- Unknown and revoked keys do not authenticate.
+	if _, err := svc.Authenticate(ctx, key+"x"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey for an unknown key, got %v", err)
+	}
+	if err := svc.Revoke(ctx, info.ID); err != nil {
+		t.Fatalf("failed to revoke key: %v", err)
+	}
+	if _, err := svc.Authenticate(ctx, key); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey for a revoked key, got %v", err)
+	}
+
+	// ---- Keys need a name and a tenant.
+	if _, _, err := svc.Create(ctx, " ", "acme", false); err == nil {
+		t.Errorf("expected error for a key without a name")
+	}
+	if _, _, err := svc.Create(ctx, "Acme Corp", "", false); err == nil {
+		t.Errorf("expected error for a key without a tenant")
+	}
+}
@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrCampaignNotFound is returned when a campaign does not exist in the client's tenant.
+	ErrCampaignNotFound = errors.New("campaign not found")
+	// ErrInvalidCampaign is returned when a campaign's fields are inconsistent.
+	ErrInvalidCampaign = errors.New("invalid campaign")
+)
+
+// CampaignDTO represents a promotional campaign as managed through the admin API.
+type CampaignDTO struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Retailer matches the retailer name once both are normalized, so "Target" matches
+	// "TARGET Store #123". Empty matches every retailer.
+	Retailer string `json:"retailer,omitempty"`
+	// StartDate and EndDate bound the purchase dates, inclusive. Format: YYYY-MM-DD
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	// Weekdays restricts the purchase days, e.g. ["sat", "sun"]. Empty for every day.
+	Weekdays []string `json:"weekdays,omitempty"`
+	// StartTime and EndTime restrict the purchase time, in the store's local time, to
+	// [StartTime, EndTime). Format: HH:MM (24-hour). Empty for the whole day.
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+	// ItemMatchers restricts the campaign to receipts with an item whose description
+	// contains one of them, case-insensitively. Empty for every receipt.
+	ItemMatchers []string `json:"itemMatchers,omitempty"`
+	// Multiplier multiplies the points awarded by the rules, so 2 doubles them. Precise
+	// to four decimal places; 0 for none.
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// BonusPoints are added once, or once for every matching item if there are item matchers.
+	BonusPoints int       `json:"bonusPoints,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// CampaignPointsDTO holds the points a campaign awarded a receipt.
+type CampaignPointsDTO struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+}
+
+// ICampaignService defines the interface for managing the campaigns of the client's tenant.
+type ICampaignService interface {
+	// Create stores a new campaign. It returns an error wrapping ErrInvalidCampaign if its
+	// fields are inconsistent.
+	Create(ctx context.Context, campaign CampaignDTO) (CampaignDTO, error)
+	// Get returns the campaign with the given ID, or ErrCampaignNotFound.
+	Get(ctx context.Context, id string) (CampaignDTO, error)
+	// List returns every campaign, oldest first.
+	List(ctx context.Context) ([]CampaignDTO, error)
+	// Update replaces the campaign with the given ID, or returns ErrCampaignNotFound.
+	// Receipts that were already scored keep their points.
+	Update(ctx context.Context, id string, campaign CampaignDTO) (CampaignDTO, error)
+	// Delete deletes the campaign with the given ID, or returns ErrCampaignNotFound.
+	// Receipts that were already scored keep their points.
+	Delete(ctx context.Context, id string) error
+}
+
+// campaignService is the concrete implementation of ICampaignService.
+type campaignService struct {
+	campaignRepo repository.ICampaignRepository
+}
+
+// NewCampaignService creates a new instance of the campaign service.
+func NewCampaignService(campaignRepo repository.ICampaignRepository) ICampaignService {
+	return &campaignService{
+		campaignRepo: campaignRepo,
+	}
+}
+
+// Create validates the campaign and stores it under a new ID in the client's tenant.
+func (s *campaignService) Create(ctx context.Context, campaign CampaignDTO) (CampaignDTO, error) {
+	if err := validateCampaign(&campaign); err != nil {
+		return CampaignDTO{}, err
+	}
+	model := convertCampaignDTO(auth.TenantID(ctx), campaign)
+	model.ID = uuid.New().String()
+	if err := s.campaignRepo.Save(ctx, model); err != nil {
+		return CampaignDTO{}, err
+	}
+	return s.Get(ctx, model.ID)
+}
+
+// Get retrieves a campaign of the client's tenant.
+func (s *campaignService) Get(ctx context.Context, id string) (CampaignDTO, error) {
+	model, err := s.campaignRepo.GetByID(ctx, auth.TenantID(ctx), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return CampaignDTO{}, ErrCampaignNotFound
+	}
+	if err != nil {
+		return CampaignDTO{}, err
+	}
+	return convertCampaignModel(model), nil
+}
+
+// List retrieves the campaigns of the client's tenant.
+func (s *campaignService) List(ctx context.Context) ([]CampaignDTO, error) {
+	models, err := s.campaignRepo.List(ctx, auth.TenantID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	campaigns := make([]CampaignDTO, len(models))
+	for i, model := range models {
+		campaigns[i] = convertCampaignModel(model)
+	}
+	return campaigns, nil
+}
+
+// Update validates the campaign and replaces the stored one.
+func (s *campaignService) Update(ctx context.Context, id string, campaign CampaignDTO) (CampaignDTO, error) {
+	if err := validateCampaign(&campaign); err != nil {
+		return CampaignDTO{}, err
+	}
+	model := convertCampaignDTO(auth.TenantID(ctx), campaign)
+	model.ID = id
+	err := s.campaignRepo.Update(ctx, model)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return CampaignDTO{}, ErrCampaignNotFound
+	}
+	if err != nil {
+		return CampaignDTO{}, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Delete deletes a campaign of the client's tenant.
+func (s *campaignService) Delete(ctx context.Context, id string) error {
+	err := s.campaignRepo.Delete(ctx, auth.TenantID(ctx), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCampaignNotFound
+	}
+	return err
+}
+
+// weekdays maps the days of the week to the names campaigns use for them.
+var weekdays = map[time.Weekday]string{
+	time.Sunday:    "sun",
+	time.Monday:    "mon",
+	time.Tuesday:   "tue",
+	time.Wednesday: "wed",
+	time.Thursday:  "thu",
+	time.Friday:    "fri",
+	time.Saturday:  "sat",
+}
+
+// validateCampaign checks the rules that the spec cannot express, and trims the names
+// and matchers of the campaign.
+func validateCampaign(campaign *CampaignDTO) error {
+	campaign.Name = strings.TrimSpace(campaign.Name)
+	campaign.Retailer = strings.TrimSpace(campaign.Retailer)
+	if campaign.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	}
+	start, err := time.Parse("2006-01-02", campaign.StartDate)
+	if err != nil {
+		return fmt.Errorf("%w: invalid startDate", ErrInvalidCampaign)
+	}
+	end, err := time.Parse("2006-01-02", campaign.EndDate)
+	if err != nil {
+		return fmt.Errorf("%w: invalid endDate", ErrInvalidCampaign)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("%w: endDate is before startDate", ErrInvalidCampaign)
+	}
+	for _, day := range campaign.Weekdays {
+		known := false
+		for _, name := range weekdays {
+			known = known || day == name
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown weekday %q", ErrInvalidCampaign, day)
+		}
+	}
+	if (campaign.StartTime == "") != (campaign.EndTime == "") {
+		return fmt.Errorf("%w: startTime and endTime must be given together", ErrInvalidCampaign)
+	}
+	if campaign.StartTime != "" && campaign.StartTime >= campaign.EndTime {
+		return fmt.Errorf("%w: startTime must be before endTime", ErrInvalidCampaign)
+	}
+	var matchers []string
+	for _, matcher := range campaign.ItemMatchers {
+		if matcher = strings.TrimSpace(matcher); matcher != "" {
+			matchers = append(matchers, matcher)
+		}
+	}
+	campaign.ItemMatchers = matchers
+	if campaign.Multiplier != 0 && campaign.Multiplier < 1 {
+		return fmt.Errorf("%w: multiplier must be at least 1", ErrInvalidCampaign)
+	}
+	if campaign.BonusPoints < 0 {
+		return fmt.Errorf("%w: bonusPoints must not be negative", ErrInvalidCampaign)
+	}
+	if campaign.Multiplier <= 1 && campaign.BonusPoints == 0 {
+		return fmt.Errorf("%w: a multiplier above 1 or bonusPoints is required", ErrInvalidCampaign)
+	}
+	return nil
+}
+
+// applyCampaigns evaluates the campaigns after the rules, adding a contribution to the
+// breakdown for each campaign that awards the receipt points. Multipliers apply to the
+// points of the rules only, so campaigns never multiply each other's points.
+func applyCampaigns(breakdown *PointsBreakdown, receipt Receipt, campaigns []repository.CampaignModel) {
+	base := breakdown.Points
+	for _, campaign := range campaigns {
+		contribution, ok := evaluateCampaign(campaign, receipt, base)
+		if !ok || contribution.Points == 0 {
+			continue
+		}
+		breakdown.Points += contribution.Points
+		breakdown.Rules = append(breakdown.Rules, contribution)
+	}
+}
+
+// evaluateCampaign returns the points the campaign awards the receipt, whose rules awarded
+// base points, or false if the receipt does not match the campaign.
+func evaluateCampaign(campaign repository.CampaignModel, receipt Receipt, base int) (RuleContribution, bool) {
+	if campaign.Retailer != "" && normalizeRetailer(campaign.Retailer) != normalizeRetailer(receipt.Retailer) {
+		return RuleContribution{}, false
+	}
+	if receipt.PurchaseDate < campaign.StartDate || receipt.PurchaseDate > campaign.EndDate {
+		return RuleContribution{}, false
+	}
+	if len(campaign.Weekdays) > 0 && !containsString(campaign.Weekdays, weekdays[receipt.PurchasedAt.Weekday()]) {
+		return RuleContribution{}, false
+	}
+	if campaign.StartTime != "" {
+		purchaseTime := receipt.PurchasedAt.Format("15:04")
+		if purchaseTime < campaign.StartTime || purchaseTime >= campaign.EndTime {
+			return RuleContribution{}, false
+		}
+	}
+
+	contribution := RuleContribution{Rule: campaign.Name, Campaign: campaign.ID, Multiplier: campaign.Multiplier}
+	if len(campaign.ItemMatchers) > 0 {
+		for i, item := range receipt.Items {
+			if !matchesItem(campaign.ItemMatchers, item.ShortDescription) {
+				continue
+			}
+			contribution.Points += campaign.BonusPoints
+			contribution.Items = append(contribution.Items, ItemContribution{
+				Index:            i,
+				ShortDescription: item.ShortDescription,
+				Price:            item.Price,
+				Points:           campaign.BonusPoints,
+			})
+		}
+		if len(contribution.Items) == 0 {
+			return RuleContribution{}, false
+		}
+	} else {
+		contribution.Points += campaign.BonusPoints
+	}
+	contribution.Points += multipliedPoints(base, campaign.Multiplier)
+	return contribution, true
+}
+
+// multipliedPoints returns the points a multiplier adds to the base points of the rules,
+// rounded down. A multiplier of 0 adds none.
+func multipliedPoints(base int, multiplier float64) int {
+	if multiplier <= 0 {
+		return 0
+	}
+	scaled := int64(math.Round(multiplier * multiplierScale))
+	return int(int64(base) * (scaled - multiplierScale) / multiplierScale)
+}
+
+// remainingCampaignPoints returns the points the campaigns that awarded a stored receipt
+// points award it without the reversed items, whose rules now award base points. The
+// campaigns are rescored from their contributions recorded when the receipt was scored,
+// since they may have been changed or deleted since: the item bonuses of reversed items are
+// dropped, a campaign none of whose matched items remain awards nothing, and multipliers
+// apply to the new base.
+func remainingCampaignPoints(model repository.ReceiptModel, base int, reversed map[int]bool) int {
+	scoredBase := 0
+	for _, c := range model.Breakdown {
+		if c.CampaignID == "" {
+			scoredBase += c.Points
+		}
+	}
+	points := 0
+	for _, c := range model.Breakdown {
+		if c.CampaignID == "" {
+			continue
+		}
+		// What is left after the multiplier and item bonuses is the campaign's receipt bonus.
+		bonus := c.Points - multipliedPoints(scoredBase, c.Multiplier)
+		if len(c.Items) > 0 {
+			matched := false
+			for _, item := range c.Items {
+				bonus -= item.Points
+				if !reversed[item.ItemIndex] {
+					points += item.Points
+					matched = true
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		points += bonus + multipliedPoints(base, c.Multiplier)
+	}
+	return points
+}
+
+// matchesItem reports whether the item description contains one of the matchers,
+// case-insensitively.
+func matchesItem(matchers []string, description string) bool {
+	description = strings.ToLower(description)
+	for _, matcher := range matchers {
+		if strings.Contains(description, strings.ToLower(matcher)) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether values contains s.
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// campaignContributions returns the points campaigns awarded a stored receipt.
+func campaignContributions(model repository.ReceiptModel) []CampaignPointsDTO {
+	var campaigns []CampaignPointsDTO
+	for _, c := range model.Breakdown {
+		if c.CampaignID != "" {
+			campaigns = append(campaigns, CampaignPointsDTO{ID: c.CampaignID, Name: c.Rule, Points: c.Points})
+		}
+	}
+	return campaigns
+}
+
+// convertCampaignDTO transforms a CampaignDTO into a repository.CampaignModel of the tenant.
+func convertCampaignDTO(tenantID string, campaign CampaignDTO) repository.CampaignModel {
+	return repository.CampaignModel{
+		TenantID:     tenantID,
+		Name:         campaign.Name,
+		Retailer:     campaign.Retailer,
+		StartDate:    campaign.StartDate,
+		EndDate:      campaign.EndDate,
+		Weekdays:     campaign.Weekdays,
+		StartTime:    campaign.StartTime,
+		EndTime:      campaign.EndTime,
+		ItemMatchers: campaign.ItemMatchers,
+		Multiplier:   campaign.Multiplier,
+		BonusPoints:  campaign.BonusPoints,
+	}
+}
+
+// convertCampaignModel transforms a repository.CampaignModel into a CampaignDTO.
+func convertCampaignModel(model repository.CampaignModel) CampaignDTO {
+	return CampaignDTO{
+		ID:           model.ID,
+		Name:         model.Name,
+		Retailer:     model.Retailer,
+		StartDate:    model.StartDate,
+		EndDate:      model.EndDate,
+		Weekdays:     model.Weekdays,
+		StartTime:    model.StartTime,
+		EndTime:      model.EndTime,
+		ItemMatchers: model.ItemMatchers,
+		Multiplier:   model.Multiplier,
+		BonusPoints:  model.BonusPoints,
+		CreatedAt:    model.CreatedAt,
+		UpdatedAt:    model.UpdatedAt,
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+)
+
+func TestValidateCampaign(t *testing.T) {
+	valid := CampaignDTO{Name: "Double points", StartDate: "2022-12-01", EndDate: "2022-12-31", Multiplier: 2}
+
+	testCases := []struct {
+		name   string
+		modify func(c *CampaignDTO)
+		valid  bool
+	}{
+		{name: "Valid", modify: func(c *CampaignDTO) {}, valid: true},
+		{name: "Bonus Only", modify: func(c *CampaignDTO) { c.Multiplier = 0; c.BonusPoints = 10 }, valid: true},
+		{name: "Single Day", modify: func(c *CampaignDTO) { c.EndDate = c.StartDate }, valid: true},
+		{name: "Time Window", modify: func(c *CampaignDTO) { c.StartTime, c.EndTime = "14:00", "16:00" }, valid: true},
+		{name: "Blank Name", modify: func(c *CampaignDTO) { c.Name = "  " }},
+		{name: "Invalid Date", modify: func(c *CampaignDTO) { c.EndDate = "2022-12-32" }},
+		{name: "End Before Start", modify: func(c *CampaignDTO) { c.EndDate = "2022-11-30" }},
+		{name: "Unknown Weekday", modify: func(c *CampaignDTO) { c.Weekdays = []string{"sat", "caturday"} }},
+		{name: "Start Time Only", modify: func(c *CampaignDTO) { c.StartTime = "14:00" }},
+		{name: "Empty Time Window", modify: func(c *CampaignDTO) { c.StartTime, c.EndTime = "16:00", "14:00" }},
+		{name: "Multiplier Below 1", modify: func(c *CampaignDTO) { c.Multiplier = 0.5 }},
+		{name: "Negative Bonus", modify: func(c *CampaignDTO) { c.BonusPoints = -1 }},
+		{name: "No Points", modify: func(c *CampaignDTO) { c.Multiplier = 1 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			campaign := valid
+			tc.modify(&campaign)
+			err := validateCampaign(&campaign)
+			if tc.valid && err != nil {
+				t.Errorf("expected the campaign to be valid, got %v", err)
+			}
+			if !tc.valid && !errors.Is(err, ErrInvalidCampaign) {
+				t.Errorf("expected ErrInvalidCampaign, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEvaluateCampaign(t *testing.T) {
+	// A Saturday afternoon receipt with two Gatorades, whose rules awarded 100 points.
+	receipt, err := parseReceipt(ReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "2022-12-03",
+		PurchaseTime: "14:33",
+		Total:        "6.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Doritos", Price: "1.50"},
+			{ShortDescription: "GATORADE Zero", Price: "2.25"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse receipt: %v", err)
+	}
+	const base = 100
+	december := repository.CampaignModel{StartDate: "2022-12-01", EndDate: "2022-12-31"}
+
+	testCases := []struct {
+		name           string
+		modify         func(c *repository.CampaignModel)
+		expectedMatch  bool
+		expectedPoints int
+		expectedItems  int
+	}{
+		{name: "Double Points", modify: func(c *repository.CampaignModel) { c.Multiplier = 2 }, expectedMatch: true, expectedPoints: 100},
+		{name: "Fractional Multiplier", modify: func(c *repository.CampaignModel) { c.Multiplier = 1.255 }, expectedMatch: true, expectedPoints: 25},
+		{name: "Bonus", modify: func(c *repository.CampaignModel) { c.BonusPoints = 10 }, expectedMatch: true, expectedPoints: 10},
+		{name: "Retailer Matches", modify: func(c *repository.CampaignModel) { c.Retailer = "TARGET"; c.BonusPoints = 10 }, expectedMatch: true, expectedPoints: 10},
+		{name: "Other Retailer", modify: func(c *repository.CampaignModel) { c.Retailer = "Walmart"; c.BonusPoints = 10 }},
+		{name: "Before Start", modify: func(c *repository.CampaignModel) { c.StartDate = "2022-12-04"; c.BonusPoints = 10 }},
+		{name: "After End", modify: func(c *repository.CampaignModel) { c.EndDate = "2022-12-02"; c.BonusPoints = 10 }},
+		{name: "Weekend", modify: func(c *repository.CampaignModel) { c.Weekdays = []string{"sat", "sun"}; c.Multiplier = 2 }, expectedMatch: true, expectedPoints: 100},
+		{name: "Weekdays", modify: func(c *repository.CampaignModel) { c.Weekdays = []string{"mon", "fri"}; c.Multiplier = 2 }},
+		{name: "In Time Window", modify: func(c *repository.CampaignModel) { c.StartTime, c.EndTime = "14:00", "16:00"; c.BonusPoints = 5 }, expectedMatch: true, expectedPoints: 5},
+		{name: "Window Ends Before", modify: func(c *repository.CampaignModel) { c.StartTime, c.EndTime = "12:00", "14:33"; c.BonusPoints = 5 }},
+		{name: "Bonus Per Item", modify: func(c *repository.CampaignModel) { c.ItemMatchers = []string{"gatorade"}; c.BonusPoints = 10 }, expectedMatch: true, expectedPoints: 20, expectedItems: 2},
+		{name: "Bonus Per Item With Multiplier", modify: func(c *repository.CampaignModel) {
+			c.ItemMatchers = []string{"doritos"}
+			c.BonusPoints = 10
+			c.Multiplier = 1.5
+		}, expectedMatch: true, expectedPoints: 60, expectedItems: 1},
+		{name: "No Matching Item", modify: func(c *repository.CampaignModel) { c.ItemMatchers = []string{"pepsi"}; c.Multiplier = 2 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			campaign := december
+			campaign.Name = tc.name
+			tc.modify(&campaign)
+			contribution, ok := evaluateCampaign(campaign, receipt, base)
+			if ok != tc.expectedMatch {
+				t.Fatalf("expected match %v, got %v", tc.expectedMatch, ok)
+			}
+			if contribution.Points != tc.expectedPoints || len(contribution.Items) != tc.expectedItems {
+				t.Errorf("expected %d points for %d items, got %+v", tc.expectedPoints, tc.expectedItems, contribution)
+			}
+		})
+	}
+}
+
+func TestProcessReceiptWithCampaigns(t *testing.T) {
+	db := newTestDB(t, "campaign_service_test")
+	campaignRepo := repository.NewCampaignRepository(db)
+	campaigns := NewCampaignService(campaignRepo)
+	receipts := NewReceiptService(repository.NewReceiptRepository(db), campaignRepo, repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	ctx := auth.NewContext(context.Background(), auth.Identity{ClientID: "admin-key", TenantID: "tenant-a", Admin: true})
+	otherCtx := auth.NewContext(context.Background(), auth.Identity{ClientID: "other-key", TenantID: "tenant-b", Admin: true})
+
+	weekends, err := campaigns.Create(ctx, CampaignDTO{Name: " Double December weekends ", Retailer: "Target", StartDate: "2022-12-01", EndDate: "2022-12-31", Weekdays: []string{"sat", "sun"}, Multiplier: 2})
+	if err != nil {
+		t.Fatalf("failed to create campaign: %v", err)
+	}
+	if weekends.ID == "" || weekends.Name != "Double December weekends" || weekends.CreatedAt.IsZero() {
+		t.Errorf("expected the created campaign with a trimmed name, got %+v", weekends)
+	}
+	gatorade, err := campaigns.Create(ctx, CampaignDTO{Name: "Gatorade bonus", StartDate: "2022-12-01", EndDate: "2022-12-31", ItemMatchers: []string{"gatorade"}, BonusPoints: 10})
+	if err != nil {
+		t.Fatalf("failed to create campaign: %v", err)
+	}
+	// Campaigns of another tenant never apply.
+	if _, err := campaigns.Create(otherCtx, CampaignDTO{Name: "Everything", StartDate: "2022-01-01", EndDate: "2022-12-31", BonusPoints: 1000}); err != nil {
+		t.Fatalf("failed to create campaign: %v", err)
+	}
+	if _, err := campaigns.Create(ctx, CampaignDTO{Name: "Nothing", StartDate: "2022-12-01", EndDate: "2022-12-31"}); !errors.Is(err, ErrInvalidCampaign) {
+		t.Errorf("expected ErrInvalidCampaign, got %v", err)
+	}
+
+	// A Saturday receipt at Target with a Gatorade matches both campaigns.
+	saturday := ReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "2022-12-03",
+		PurchaseTime: "10:00",
+		Total:        "4.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Doritos", Price: "1.75"},
+		},
+	}
+	id, err := receipts.ProcessReceipt(ctx, saturday)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	breakdown, err := receipts.GetBreakdown(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get breakdown: %v", err)
+	}
+	rulePoints := 0
+	for _, rule := range breakdown.Rules {
+		if rule.Campaign == "" {
+			rulePoints += rule.Points
+		}
+	}
+	// The multiplier doubles the rules' points only, not the Gatorade bonus.
+	if breakdown.Points != 2*rulePoints+10 {
+		t.Errorf("expected %d points, got %d", 2*rulePoints+10, breakdown.Points)
+	}
+	points, err := receipts.GetPoints(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	if len(points.Campaigns) != 2 ||
+		points.Campaigns[0] != (CampaignPointsDTO{ID: weekends.ID, Name: weekends.Name, Points: rulePoints}) ||
+		points.Campaigns[1] != (CampaignPointsDTO{ID: gatorade.ID, Name: gatorade.Name, Points: 10}) {
+		t.Errorf("expected the points of both campaigns, got %+v", points.Campaigns)
+	}
+	if points.AwardedPoints != breakdown.Points {
+		t.Errorf("expected the campaigns' points to be awarded, got %d", points.AwardedPoints)
+	}
+
+	// Deleting or moving a campaign stops it applying to new receipts, but the receipts it
+	// already applied to keep their points.
+	if err := campaigns.Delete(ctx, gatorade.ID); err != nil {
+		t.Fatalf("failed to delete campaign: %v", err)
+	}
+	weekends.StartDate = "2023-12-01"
+	weekends.EndDate = "2023-12-31"
+	if _, err := campaigns.Update(ctx, weekends.ID, weekends); err != nil {
+		t.Fatalf("failed to update campaign: %v", err)
+	}
+	saturday.PurchaseTime = "10:01"
+	id2, err := receipts.ProcessReceipt(ctx, saturday)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	points2, err := receipts.GetPoints(ctx, id2)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	if len(points2.Campaigns) != 0 || points2.AwardedPoints != rulePoints {
+		t.Errorf("expected no campaigns to apply, got %+v", points2)
+	}
+	if kept, err := receipts.GetPoints(ctx, id); err != nil || kept.AwardedPoints != breakdown.Points {
+		t.Errorf("expected the first receipt to keep its points, got %+v, %v", kept, err)
+	}
+
+	// Refunds rescore the campaigns as they were when the receipt was scored. Without its
+	// Gatorade, the receipt no longer matches the Gatorade bonus, but its weekend points
+	// are still doubled.
+	doritos, err := receipts.ProcessReceipt(ctx, ReceiptDTO{Retailer: "Target", PurchaseDate: "2022-12-03", PurchaseTime: "10:02", Total: "1.75", Items: []ItemDTO{{ShortDescription: "Doritos", Price: "1.75"}}})
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	remaining, err := receipts.GetPoints(ctx, doritos)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	refund, err := receipts.RefundItems(ctx, id, []int{0})
+	if err != nil {
+		t.Fatalf("failed to refund item: %v", err)
+	}
+	if expected := breakdown.Points - 2*remaining.AwardedPoints; refund.Points != expected {
+		t.Errorf("expected the refund to claw back %d points, got %d", expected, refund.Points)
+	}
+
+	// Campaigns are managed per tenant.
+	listed, err := campaigns.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list campaigns: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != weekends.ID || listed[0].StartDate != "2023-12-01" {
+		t.Errorf("expected the updated campaign only, got %+v", listed)
+	}
+	if _, err := campaigns.Get(otherCtx, weekends.ID); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("expected ErrCampaignNotFound for another tenant, got %v", err)
+	}
+	if _, err := campaigns.Update(otherCtx, weekends.ID, weekends); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("expected ErrCampaignNotFound updating another tenant's campaign, got %v", err)
+	}
+	if err := campaigns.Delete(ctx, gatorade.ID); !errors.Is(err, ErrCampaignNotFound) {
+		t.Errorf("expected ErrCampaignNotFound deleting again, got %v", err)
+	}
+}
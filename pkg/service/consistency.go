@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+
+	"receipt_processor/pkg/money"
+
+	"github.com/spf13/viper"
+)
+
+// ConsistencyMode determines how receipts whose items do not add up to the total are handled.
+type ConsistencyMode string
+
+const (
+	// ConsistencyOff disables the check.
+	ConsistencyOff ConsistencyMode = "off"
+	// ConsistencyStrict rejects any receipt whose items do not add up to the total exactly.
+	ConsistencyStrict ConsistencyMode = "strict"
+	// ConsistencyTolerance rejects receipts whose discrepancy exceeds the tolerance,
+	// and flags smaller discrepancies for review.
+	ConsistencyTolerance ConsistencyMode = "tolerance"
+	// ConsistencyWarn never rejects receipts, but flags every discrepancy for review.
+	ConsistencyWarn ConsistencyMode = "warn"
+)
+
+// ConsistencyPolicy configures the check of a receipt's total against the sum of its item prices.
+type ConsistencyPolicy struct {
+	Mode      ConsistencyMode
+	Tolerance money.Cents // Only used in ConsistencyTolerance mode.
+}
+
+// TotalMismatchError is returned when a receipt's item prices do not add up to its total.
+type TotalMismatchError struct {
+	Total      money.Cents `json:"total"`
+	ItemsTotal money.Cents `json:"itemsTotal"`
+	Difference money.Cents `json:"difference"` // Total minus ItemsTotal.
+	Tolerance  money.Cents `json:"tolerance"`
+}
+
+// Error implements the error interface.
+func (e *TotalMismatchError) Error() string {
+	return fmt.Sprintf("total %s does not match sum of item prices %s (difference %s, tolerance %s)",
+		e.Total, e.ItemsTotal, e.Difference, e.Tolerance)
+}
+
+// LoadConsistencyPolicy reads the policy from the "consistency.policy" and
+// "consistency.tolerance" configuration keys. The check is off when no policy is configured.
+func LoadConsistencyPolicy() (ConsistencyPolicy, error) {
+	policy := ConsistencyPolicy{Mode: ConsistencyMode(viper.GetString("consistency.policy"))}
+	switch policy.Mode {
+	case "":
+		policy.Mode = ConsistencyOff
+	case ConsistencyOff, ConsistencyStrict, ConsistencyWarn:
+	case ConsistencyTolerance:
+		tolerance, err := money.Parse(viper.GetString("consistency.tolerance"))
+		if err != nil || tolerance < 0 {
+			return ConsistencyPolicy{}, fmt.Errorf("invalid consistency tolerance %q", viper.GetString("consistency.tolerance"))
+		}
+		policy.Tolerance = tolerance
+	default:
+		return ConsistencyPolicy{}, fmt.Errorf("unknown consistency policy %q", policy.Mode)
+	}
+	return policy, nil
+}
+
+// Check compares the receipt's total with the sum of its item prices.
+// It returns a *TotalMismatchError if the receipt must be rejected, and otherwise
+// a non-empty flag describing any discrepancy that should be recorded for review.
+func (p ConsistencyPolicy) Check(receipt Receipt) (string, error) {
+	if p.Mode == ConsistencyOff || p.Mode == "" {
+		return "", nil
+	}
+
+	var itemsTotal money.Cents
+	for _, item := range receipt.Items {
+		itemsTotal += item.Price
+	}
+	if itemsTotal == receipt.Total {
+		return "", nil
+	}
+
+	mismatch := &TotalMismatchError{
+		Total:      receipt.Total,
+		ItemsTotal: itemsTotal,
+		Difference: receipt.Total - itemsTotal,
+		Tolerance:  p.Tolerance,
+	}
+	difference := mismatch.Difference
+	if difference < 0 {
+		difference = -difference
+	}
+	if p.Mode == ConsistencyStrict || (p.Mode == ConsistencyTolerance && difference > p.Tolerance) {
+		return "", mismatch
+	}
+	return mismatch.Error(), nil
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"receipt_processor/pkg/money"
+	"receipt_processor/pkg/repository"
+
+	"github.com/spf13/viper"
+)
+
+func TestConsistencyPolicyCheck(t *testing.T) {
+	// Items add up to 10.00.
+	items := []Item{{Price: money.MustParse("6.00")}, {Price: money.MustParse("4.00")}}
+
+	testCases := []struct {
+		name         string
+		policy       ConsistencyPolicy
+		total        string
+		expectReject bool
+		expectFlag   bool
+	}{
+		{name: "Off ignores mismatch", policy: ConsistencyPolicy{Mode: ConsistencyOff}, total: "12.00"},
+		{name: "Strict accepts match", policy: ConsistencyPolicy{Mode: ConsistencyStrict}, total: "10.00"},
+		{name: "Strict rejects mismatch", policy: ConsistencyPolicy{Mode: ConsistencyStrict}, total: "10.01", expectReject: true},
+		{name: "Tolerance flags small mismatch", policy: ConsistencyPolicy{Mode: ConsistencyTolerance, Tolerance: 5}, total: "9.95", expectFlag: true},
+		{name: "Tolerance rejects large mismatch", policy: ConsistencyPolicy{Mode: ConsistencyTolerance, Tolerance: 5}, total: "10.06", expectReject: true},
+		{name: "Warn flags mismatch", policy: ConsistencyPolicy{Mode: ConsistencyWarn}, total: "100.00", expectFlag: true},
+		{name: "Warn accepts match", policy: ConsistencyPolicy{Mode: ConsistencyWarn}, total: "10.00"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			flag, err := tc.policy.Check(Receipt{Total: money.MustParse(tc.total), Items: items})
+			var mismatch *TotalMismatchError
+			if tc.expectReject != errors.As(err, &mismatch) {
+				t.Fatalf("expected reject=%v, got error %v", tc.expectReject, err)
+			}
+			if tc.expectReject && mismatch.ItemsTotal != money.MustParse("10.00") {
+				t.Errorf("expected items total 10.00, got %s", mismatch.ItemsTotal)
+			}
+			if tc.expectFlag != (flag != "") {
+				t.Errorf("expected flag=%v, got %q", tc.expectFlag, flag)
+			}
+		})
+	}
+}
+
+func TestLoadConsistencyPolicy(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Reset()
+	policy, err := LoadConsistencyPolicy()
+	if err != nil || policy.Mode != ConsistencyOff {
+		t.Errorf("expected policy to default to off, got %+v, %v", policy, err)
+	}
+
+	viper.Set("consistency.policy", "tolerance")
+	viper.Set("consistency.tolerance", "0.10")
+	policy, err = LoadConsistencyPolicy()
+	if err != nil || policy.Mode != ConsistencyTolerance || policy.Tolerance != 10 {
+		t.Errorf("expected tolerance policy of 0.10, got %+v, %v", policy, err)
+	}
+
+	viper.Set("consistency.tolerance", "ten cents")
+	if _, err := LoadConsistencyPolicy(); err == nil {
+		t.Errorf("expected error for invalid tolerance, got nil")
+	}
+
+	viper.Set("consistency.policy", "sometimes")
+	if _, err := LoadConsistencyPolicy(); err == nil {
+		t.Errorf("expected error for unknown policy, got nil")
+	}
+}
+
+func TestProcessReceiptConsistency(t *testing.T) {
+	db := newTestDB(t, "consistency_test")
+	repo := repository.NewReceiptRepository(db)
+	ctx := context.Background()
+
+	receipt := ReceiptDTO{
+		Retailer:     "Mismatch Market",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "35.35",
+		Items:        []ItemDTO{{ShortDescription: "Item A", Price: "10.00"}},
+	}
+
+	// Strict mode rejects the receipt with a structured error.
+	strict := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{Mode: ConsistencyStrict})
+	_, err := strict.ProcessReceipt(ctx, receipt)
+	var mismatch *TotalMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected TotalMismatchError, got %v", err)
+	}
+	if mismatch.Difference != money.MustParse("25.35") {
+		t.Errorf("expected difference 25.35, got %s", mismatch.Difference)
+	}
+
+	// Warn mode saves the receipt and flags it for review.
+	warn := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{Mode: ConsistencyWarn})
+	id, err := warn.ProcessReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	stored, err := warn.GetReceipt(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if len(stored.Flags) != 1 || !strings.Contains(stored.Flags[0], "does not match") {
+		t.Errorf("expected receipt to be flagged, got %v", stored.Flags)
+	}
+
+	// Consistent receipts are not flagged.
+	consistent := receipt
+	consistent.Total = "10.00"
+	if _, err := warn.ProcessReceipt(ctx, consistent); err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	// Only the flagged receipt is listed for review.
+	page, err := warn.ListReceipts(ctx, ReceiptQuery{FlaggedOnly: true})
+	if err != nil {
+		t.Fatalf("failed to list receipts: %v", err)
+	}
+	if len(page.Receipts) != 1 || page.Receipts[0].ID != id {
+		t.Errorf("expected only %s to be listed as flagged, got %+v", id, page.Receipts)
+	}
+}
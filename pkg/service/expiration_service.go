@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"receipt_processor/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// ExpirationPolicy configures when earned points expire.
+type ExpirationPolicy struct {
+	// AfterMonths is the number of months after the purchase date of the receipt that earned
+	// them that points expire. Points never expire if it is 0.
+	AfterMonths int `mapstructure:"after_months"`
+	// SweepInterval is how often expired points are removed from the users' balances.
+	SweepInterval time.Duration `mapstructure:"sweep_interval"`
+}
+
+// LoadExpirationPolicy reads the policy from the "points.expiration" configuration key.
+// Without configuration, points never expire.
+func LoadExpirationPolicy() (ExpirationPolicy, error) {
+	policy := ExpirationPolicy{SweepInterval: time.Hour}
+	if err := viper.UnmarshalKey("points.expiration", &policy); err != nil {
+		return ExpirationPolicy{}, fmt.Errorf("invalid points expiration: %v", err)
+	}
+	if policy.AfterMonths < 0 {
+		return ExpirationPolicy{}, fmt.Errorf("invalid points expiration: after_months must not be negative")
+	}
+	if policy.AfterMonths > 0 && policy.SweepInterval <= 0 {
+		return ExpirationPolicy{}, fmt.Errorf("invalid points expiration: sweep_interval must be positive")
+	}
+	return policy, nil
+}
+
+// Enabled reports whether points expire.
+func (p ExpirationPolicy) Enabled() bool {
+	return p.AfterMonths > 0
+}
+
+// IExpirationService defines the interface for expiring earned points.
+type IExpirationService interface {
+	// Sweep expires the unspent points earned with receipts purchased at least the policy's
+	// number of months ago, and returns the number of receipts whose points expired.
+	Sweep(ctx context.Context) (int64, error)
+	// Start sweeps in a background goroutine now and every SweepInterval, if points expire.
+	// It stops when ctx is done.
+	Start(ctx context.Context)
+}
+
+// expirationService is the concrete implementation of IExpirationService.
+type expirationService struct {
+	expirationRepo repository.IExpirationRepository
+	policy         ExpirationPolicy
+	now            func() time.Time
+}
+
+// NewExpirationService creates a new instance of the expiration service.
+func NewExpirationService(expirationRepo repository.IExpirationRepository, policy ExpirationPolicy) IExpirationService {
+	return newExpirationService(expirationRepo, policy, time.Now)
+}
+
+func newExpirationService(expirationRepo repository.IExpirationRepository, policy ExpirationPolicy, now func() time.Time) *expirationService {
+	return &expirationService{
+		expirationRepo: expirationRepo,
+		policy:         policy,
+		now:            now,
+	}
+}
+
+// Sweep expires points earned on or before the date AfterMonths ago, so points earned on
+// 2024-03-15 expire on 2025-03-15 after 12 months. It does nothing if points never expire.
+func (s *expirationService) Sweep(ctx context.Context) (int64, error) {
+	if !s.policy.Enabled() {
+		return 0, nil
+	}
+	cutoff := s.now().UTC().AddDate(0, -s.policy.AfterMonths, 0).Format("2006-01-02")
+	return s.expirationRepo.Expire(ctx, cutoff)
+}
+
+// Start runs the sweeper, logging the outcome of every sweep.
+func (s *expirationService) Start(ctx context.Context) {
+	if !s.policy.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.policy.SweepInterval)
+		defer ticker.Stop()
+		for {
+			receipts, err := s.Sweep(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to expire points")
+			} else if receipts > 0 {
+				log.Info().Int64("receipts", receipts).Msg("Expired points")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
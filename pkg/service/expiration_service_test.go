@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"receipt_processor/pkg/repository"
+
+	"github.com/spf13/viper"
+)
+
+// fakeExpirationRepository records the cutoffs it is asked to expire points by.
+type fakeExpirationRepository struct {
+	cutoffs []string
+}
+
+func (f *fakeExpirationRepository) Expire(ctx context.Context, cutoff string) (int64, error) {
+	f.cutoffs = append(f.cutoffs, cutoff)
+	return 1, nil
+}
+
+var _ repository.IExpirationRepository = (*fakeExpirationRepository)(nil)
+
+func TestExpirationServiceSweep(t *testing.T) {
+	now := func() time.Time { return time.Date(2025, 3, 15, 1, 0, 0, 0, time.UTC) }
+
+	testCases := []struct {
+		name            string
+		policy          ExpirationPolicy
+		expectedCutoffs []string
+	}{
+		{name: "Twelve Months", policy: ExpirationPolicy{AfterMonths: 12}, expectedCutoffs: []string{"2024-03-15"}},
+		{name: "One Month", policy: ExpirationPolicy{AfterMonths: 1}, expectedCutoffs: []string{"2025-02-15"}},
+		{name: "Disabled", policy: ExpirationPolicy{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &fakeExpirationRepository{}
+			if _, err := newExpirationService(repo, tc.policy, now).Sweep(context.Background()); err != nil {
+				t.Fatalf("failed to sweep: %v", err)
+			}
+			if len(repo.cutoffs) != len(tc.expectedCutoffs) || (len(repo.cutoffs) > 0 && repo.cutoffs[0] != tc.expectedCutoffs[0]) {
+				t.Errorf("expected cutoffs %v, got %v", tc.expectedCutoffs, repo.cutoffs)
+			}
+		})
+	}
+}
+
+func TestLoadExpirationPolicy(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Reset()
+	policy, err := LoadExpirationPolicy()
+	if err != nil || policy.Enabled() {
+		t.Errorf("expected points not to expire without configuration, got %+v (err: %v)", policy, err)
+	}
+
+	viper.Set("points.expiration.after_months", 12)
+	viper.Set("points.expiration.sweep_interval", "30m")
+	policy, err = LoadExpirationPolicy()
+	if err != nil || policy.AfterMonths != 12 || policy.SweepInterval != 30*time.Minute {
+		t.Errorf("unexpected policy %+v (err: %v)", policy, err)
+	}
+
+	viper.Set("points.expiration.after_months", -1)
+	if _, err := LoadExpirationPolicy(); err == nil {
+		t.Errorf("expected error for negative months")
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"receipt_processor/pkg/repository"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMerchantNotFound is returned when a merchant does not exist in the tenant's catalog.
+	ErrMerchantNotFound = errors.New("merchant not found")
+	// ErrInvalidMerchant is returned when a merchant's name or alias is empty once normalized.
+	ErrInvalidMerchant = errors.New("invalid merchant")
+	// ErrAliasTaken is returned when an alias normalizes to a name of another merchant.
+	ErrAliasTaken = repository.ErrAliasTaken
+)
+
+// MerchantDTO is a canonical merchant of the catalog, with the retailer names mapped to it.
+type MerchantDTO struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"` // Including the name.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// IMerchantService defines the interface for managing the tenants' merchant catalogs.
+// Receipts are mapped to the merchant one of whose aliases normalizes to the same name as
+// their retailer when they are processed.
+type IMerchantService interface {
+	// Create adds a merchant to the tenant's catalog, with its name and aliases as the
+	// retailer names mapped to it. It returns ErrAliasTaken if one of them is already mapped
+	// to another merchant.
+	Create(ctx context.Context, tenantID, name string, aliases []string) (MerchantDTO, error)
+	// AddAliases maps more retailer names to one of the tenant's merchants. It returns
+	// ErrMerchantNotFound or ErrAliasTaken.
+	AddAliases(ctx context.Context, tenantID, id string, aliases []string) (MerchantDTO, error)
+	// List returns the tenant's merchants by name.
+	List(ctx context.Context, tenantID string) ([]MerchantDTO, error)
+	// MapReceipts maps the tenant's stored receipts that are not mapped to a merchant yet to
+	// the merchants of the catalog, and returns the number of receipts mapped.
+	MapReceipts(ctx context.Context, tenantID string) (int64, error)
+}
+
+// merchantService is the concrete implementation of IMerchantService.
+type merchantService struct {
+	merchantRepo repository.IMerchantRepository
+	receiptRepo  repository.IReceiptRepository
+}
+
+// NewMerchantService creates a new instance of the merchant service.
+func NewMerchantService(merchantRepo repository.IMerchantRepository, receiptRepo repository.IReceiptRepository) IMerchantService {
+	return &merchantService{
+		merchantRepo: merchantRepo,
+		receiptRepo:  receiptRepo,
+	}
+}
+
+// Create stores the merchant under a new ID, with an alias for its name and each alias.
+func (s *merchantService) Create(ctx context.Context, tenantID, name string, aliases []string) (MerchantDTO, error) {
+	name = strings.TrimSpace(name)
+	models, err := merchantAliases(append([]string{name}, aliases...))
+	if err != nil {
+		return MerchantDTO{}, err
+	}
+	merchant := repository.MerchantModel{
+		ID:       uuid.New().String(),
+		TenantID: tenantID,
+		Name:     name,
+		Aliases:  models,
+	}
+	if err := s.merchantRepo.Save(ctx, merchant); err != nil {
+		return MerchantDTO{}, err
+	}
+	return s.get(ctx, tenantID, merchant.ID)
+}
+
+// AddAliases stores the aliases whose normalized names the merchant does not have yet.
+func (s *merchantService) AddAliases(ctx context.Context, tenantID, id string, aliases []string) (MerchantDTO, error) {
+	models, err := merchantAliases(aliases)
+	if err != nil {
+		return MerchantDTO{}, err
+	}
+	err = s.merchantRepo.AddAliases(ctx, tenantID, id, models)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return MerchantDTO{}, ErrMerchantNotFound
+	}
+	if err != nil {
+		return MerchantDTO{}, err
+	}
+	return s.get(ctx, tenantID, id)
+}
+
+// List retrieves the merchants of the tenant's catalog.
+func (s *merchantService) List(ctx context.Context, tenantID string) ([]MerchantDTO, error) {
+	models, err := s.merchantRepo.List(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	merchants := make([]MerchantDTO, len(models))
+	for i, model := range models {
+		merchants[i] = convertMerchantModel(model)
+	}
+	return merchants, nil
+}
+
+// MapReceipts looks up the merchant of every retailer of the unmapped receipts, so receipts
+// stored before their merchant was added to the catalog are reported with it.
+func (s *merchantService) MapReceipts(ctx context.Context, tenantID string) (int64, error) {
+	retailers, err := s.receiptRepo.UnmappedRetailers(ctx, tenantID)
+	if err != nil {
+		return 0, err
+	}
+	byKey := make(map[string][]string)
+	var keys []string
+	for _, retailer := range retailers {
+		key := normalizeRetailer(retailer)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], retailer)
+	}
+	var mapped int64
+	for _, key := range keys {
+		merchant, err := s.merchantRepo.FindByKey(ctx, tenantID, key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return mapped, err
+		}
+		n, err := s.receiptRepo.MapMerchant(ctx, tenantID, merchant.ID, byKey[key])
+		mapped += n
+		if err != nil {
+			return mapped, err
+		}
+	}
+	return mapped, nil
+}
+
+// get retrieves a merchant of the tenant's catalog.
+func (s *merchantService) get(ctx context.Context, tenantID, id string) (MerchantDTO, error) {
+	model, err := s.merchantRepo.GetByID(ctx, tenantID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return MerchantDTO{}, ErrMerchantNotFound
+	}
+	if err != nil {
+		return MerchantDTO{}, err
+	}
+	return convertMerchantModel(model), nil
+}
+
+// storeNumberPattern matches a store number at the end of a normalized retailer name, such
+// as " store #123", " no. 42" or " #7".
+var storeNumberPattern = regexp.MustCompile(`\s*(?:\b(?:store|no\.?|number)\s*#?|#)\s*\d+$`)
+
+// normalizeRetailer returns the name receipts are matched to merchants and deduplicated by:
+// the folded retailer name with its whitespace collapsed and any store number stripped.
+// "Target", "TARGET " and "Target Store #123" all normalize to "target". A name that is
+// only a store number, such as "Store 24", keeps it.
+func normalizeRetailer(retailer string) string {
+	// Decompose the name, remove its accents and other combining marks, then recompose and
+	// case fold it, so that e.g. "Café", "CAFE" and the full-width "ＣＡＦＥ" fold to "cafe".
+	// Transformers keep state, so the chain is built for every call.
+	folding := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC, cases.Fold())
+	folded, _, err := transform.String(folding, retailer)
+	if err != nil {
+		folded = strings.ToLower(retailer)
+	}
+	folded = strings.Join(strings.Fields(folded), " ")
+	if stripped := storeNumberPattern.ReplaceAllString(folded, ""); stripped != "" {
+		return stripped
+	}
+	return folded
+}
+
+// merchantAliases converts retailer names into aliases, one for each normalized name.
+func merchantAliases(names []string) ([]repository.MerchantAliasModel, error) {
+	var aliases []repository.MerchantAliasModel
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := normalizeRetailer(name)
+		if key == "" {
+			return nil, fmt.Errorf("%w: name is required", ErrInvalidMerchant)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		aliases = append(aliases, repository.MerchantAliasModel{Alias: name, Key: key})
+	}
+	return aliases, nil
+}
+
+// convertMerchantModel transforms a repository.MerchantModel into a MerchantDTO.
+func convertMerchantModel(model repository.MerchantModel) MerchantDTO {
+	merchant := MerchantDTO{ID: model.ID, Name: model.Name, CreatedAt: model.CreatedAt}
+	for _, alias := range model.Aliases {
+		merchant.Aliases = append(merchant.Aliases, alias.Alias)
+	}
+	return merchant
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+)
+
+func TestNormalizeRetailer(t *testing.T) {
+	testCases := []struct {
+		retailer string
+		expected string
+	}{
+		{retailer: "Target", expected: "target"},
+		{retailer: "TARGET ", expected: "target"},
+		{retailer: "  Target   Store  ", expected: "target store"},
+		{retailer: "Target Store #123", expected: "target"},
+		{retailer: "Target #123", expected: "target"},
+		{retailer: "Target store 123", expected: "target"},
+		{retailer: "Walgreens No. 4521", expected: "walgreens"},
+		{retailer: "M&M Corner Market", expected: "m&m corner market"},
+		{retailer: "Café Olé", expected: "cafe ole"},
+		{retailer: "ＴＡＲＧＥＴ", expected: "target"},
+		{retailer: "Straße", expected: "strasse"},
+		// Numbers that are part of the name are kept.
+		{retailer: "7-Eleven", expected: "7-eleven"},
+		{retailer: "Pier 1", expected: "pier 1"},
+		{retailer: "Store 24", expected: "store 24"},
+	}
+	for _, tc := range testCases {
+		if got := normalizeRetailer(tc.retailer); got != tc.expected {
+			t.Errorf("normalizeRetailer(%q): expected %q, got %q", tc.retailer, tc.expected, got)
+		}
+	}
+}
+
+func TestMerchantCatalog(t *testing.T) {
+	db := newTestDB(t, "merchant_service_test")
+	receiptRepo := repository.NewReceiptRepository(db)
+	merchantRepo := repository.NewMerchantRepository(db)
+	merchants := NewMerchantService(merchantRepo, receiptRepo)
+	// Score the retailer's normalized name.
+	configs := DefaultRuleConfigs()
+	configs[0].Normalize = true
+	rules, err := NewRuleSet(configs)
+	if err != nil {
+		t.Fatalf("failed to build rules: %v", err)
+	}
+	receipts := NewReceiptService(receiptRepo, repository.NewCampaignRepository(db), merchantRepo, NewRuleVersions("v2", rules), ConsistencyPolicy{})
+	ctx := auth.NewContext(context.Background(), auth.Identity{ClientID: "key", TenantID: "tenant-a"})
+
+	receipt := func(retailer, purchaseTime string) ReceiptDTO {
+		return ReceiptDTO{
+			Retailer:     retailer,
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: purchaseTime,
+			Total:        "2.25",
+			Items:        []ItemDTO{{ShortDescription: "Gatorade", Price: "2.25"}},
+		}
+	}
+
+	// A receipt stored before its merchant is in the catalog is not mapped.
+	unmappedID, err := receipts.ProcessReceipt(ctx, receipt("Target Store #1", "09:00"))
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	target, err := merchants.Create(ctx, "tenant-a", " Target ", []string{"TARGET", "Tgt"})
+	if err != nil {
+		t.Fatalf("failed to create merchant: %v", err)
+	}
+	if target.Name != "Target" || len(target.Aliases) != 2 || target.Aliases[1] != "Tgt" {
+		t.Errorf("expected Target with the aliases Target and Tgt, got %+v", target)
+	}
+	if _, err := merchants.Create(ctx, "tenant-a", "Tgt Stores", []string{"tgt"}); !errors.Is(err, ErrAliasTaken) {
+		t.Errorf("expected ErrAliasTaken, got %v", err)
+	}
+	if _, err := merchants.Create(ctx, "tenant-a", "  ", nil); !errors.Is(err, ErrInvalidMerchant) {
+		t.Errorf("expected ErrInvalidMerchant, got %v", err)
+	}
+	if _, err := merchants.AddAliases(ctx, "tenant-a", "missing-id", []string{"Tgt"}); !errors.Is(err, ErrMerchantNotFound) {
+		t.Errorf("expected ErrMerchantNotFound, got %v", err)
+	}
+	target, err = merchants.AddAliases(ctx, "tenant-a", target.ID, []string{"Target.com"})
+	if err != nil {
+		t.Fatalf("failed to add aliases: %v", err)
+	}
+
+	// Mapping the stored receipts maps the receipt stored before.
+	mapped, err := merchants.MapReceipts(ctx, "tenant-a")
+	if err != nil {
+		t.Fatalf("failed to map receipts: %v", err)
+	}
+	if mapped != 1 {
+		t.Errorf("expected 1 receipt to be mapped, got %d", mapped)
+	}
+	if stored, err := receipts.GetReceipt(ctx, unmappedID); err != nil || stored.MerchantID != target.ID {
+		t.Errorf("expected the stored receipt to be mapped to Target, got %+v, %v", stored, err)
+	}
+
+	// New receipts are mapped to the merchant by their normalized retailer, and are scored
+	// and deduplicated by it.
+	id, err := receipts.ProcessReceipt(ctx, receipt("Target", "10:00"))
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	for _, retailer := range []string{"TARGET ", "Target Store #123", "ＴＡＲＧＥＴ #7"} {
+		duplicateID, err := receipts.ProcessReceipt(ctx, receipt(retailer, "10:00"))
+		if err != nil {
+			t.Fatalf("failed to process receipt: %v", err)
+		}
+		if duplicateID != id {
+			t.Errorf("%q: expected a duplicate of %s, got %s", retailer, id, duplicateID)
+		}
+	}
+	aliasID, err := receipts.ProcessReceipt(ctx, receipt("Tgt", "11:00"))
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	otherID, err := receipts.ProcessReceipt(ctx, receipt("Walmart", "10:00"))
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	for receiptID, expected := range map[string]string{id: target.ID, aliasID: target.ID, otherID: ""} {
+		stored, err := receipts.GetReceipt(ctx, receiptID)
+		if err != nil {
+			t.Fatalf("failed to get receipt: %v", err)
+		}
+		if stored.MerchantID != expected {
+			t.Errorf("%s: expected merchant %q, got %q", stored.Retailer, expected, stored.MerchantID)
+		}
+	}
+	page, err := receipts.ListReceipts(ctx, ReceiptQuery{MerchantID: target.ID})
+	if err != nil {
+		t.Fatalf("failed to list receipts: %v", err)
+	}
+	if len(page.Receipts) != 3 {
+		t.Errorf("expected the 3 receipts of Target, got %d", len(page.Receipts))
+	}
+	plain, err := receipts.GetPoints(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	numbered, err := receipts.GetPoints(ctx, unmappedID)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	if plain.Points != numbered.Points {
+		t.Errorf("expected Target and Target Store #1 to score the same, got %d and %d", plain.Points, numbered.Points)
+	}
+
+	// Another tenant's catalog is separate.
+	list, err := merchants.List(ctx, "tenant-b")
+	if err != nil {
+		t.Fatalf("failed to list merchants: %v", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("expected no merchants for tenant-b, got %+v", list)
+	}
+}
+
+func TestFindDuplicateLegacyHash(t *testing.T) {
+	db := newTestDB(t, "legacy_hash_test")
+	repo := repository.NewReceiptRepository(db)
+	svc := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	ctx := context.Background()
+
+	// A receipt stored before retailers were normalized is hashed with the retailer as given.
+	receipt := ReceiptDTO{Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "1.00", Items: []ItemDTO{{ShortDescription: "Soda", Price: "1.00"}}}
+	legacy := repository.ReceiptModel{ID: "legacy-id", TenantID: auth.DefaultTenant, Retailer: "Target", Hash: hashReceipt(auth.DefaultTenant, "Target", receipt)}
+	if err := repo.Save(ctx, legacy); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+	id, err := svc.ProcessReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	if id != "legacy-id" {
+		t.Errorf("expected the receipt to be a duplicate of the legacy receipt, got %s", id)
+	}
+}
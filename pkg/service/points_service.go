@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+)
+
+// recentLedgerEntries is the number of ledger entries returned with a user's balance.
+const recentLedgerEntries = 20
+
+var (
+	// ErrInsufficientPoints is returned when redeeming more points than the user's balance.
+	ErrInsufficientPoints = repository.ErrInsufficientPoints
+	// ErrIdempotencyKeyReused is returned when an idempotency key is used again for a
+	// different redemption.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different redemption")
+)
+
+// UserPointsDTO is a user's points balance, with the most recent changes to it.
+type UserPointsDTO struct {
+	UserID  string           `json:"userId"`
+	Points  int              `json:"points"`
+	Entries []LedgerEntryDTO `json:"entries"` // Newest first.
+}
+
+// LedgerEntryDTO is a single change to a user's points balance.
+type LedgerEntryDTO struct {
+	Kind      string    `json:"kind"`   // "earn", "redeem", "expire" or "reverse".
+	Points    int       `json:"points"` // Negative for debits.
+	ReceiptID string    `json:"receiptId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// RedemptionDTO describes points redeemed from a user's balance.
+type RedemptionDTO struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Points    int       `json:"points"`  // The points redeemed.
+	Balance   int       `json:"balance"` // The user's current balance.
+	CreatedAt time.Time `json:"createdAt"`
+	// Replayed is set if the redemption was made by an earlier request with the same idempotency key.
+	Replayed bool `json:"-"`
+}
+
+// IPointsService defines the interface for the users' points balances.
+type IPointsService interface {
+	// GetUserPoints returns the user's balance. Users without any points have a balance of 0.
+	GetUserPoints(ctx context.Context, userID string) (UserPointsDTO, error)
+	// Redeem debits points from the user's balance, or returns ErrInsufficientPoints if the
+	// balance is lower. The idempotency key identifies the request: retrying it returns the
+	// original redemption without debiting the points again, and using the key for another
+	// redemption returns ErrIdempotencyKeyReused.
+	Redeem(ctx context.Context, userID, idempotencyKey string, points int) (RedemptionDTO, error)
+}
+
+// pointsService is the concrete implementation of IPointsService.
+type pointsService struct {
+	ledgerRepo repository.ILedgerRepository
+}
+
+// NewPointsService creates a new instance of the points service.
+func NewPointsService(ledgerRepo repository.ILedgerRepository) IPointsService {
+	return &pointsService{
+		ledgerRepo: ledgerRepo,
+	}
+}
+
+// GetUserPoints sums the user's ledger entries within the tenant of the client.
+func (s *pointsService) GetUserPoints(ctx context.Context, userID string) (UserPointsDTO, error) {
+	tenantID := auth.TenantID(ctx)
+	balance, err := s.ledgerRepo.Balance(ctx, tenantID, userID)
+	if err != nil {
+		return UserPointsDTO{}, err
+	}
+	models, err := s.ledgerRepo.Entries(ctx, tenantID, userID, recentLedgerEntries)
+	if err != nil {
+		return UserPointsDTO{}, err
+	}
+	points := UserPointsDTO{UserID: userID, Points: balance, Entries: make([]LedgerEntryDTO, len(models))}
+	for i, model := range models {
+		points.Entries[i] = LedgerEntryDTO{
+			Kind:      model.Kind,
+			Points:    model.Points,
+			ReceiptID: model.ReceiptID,
+			CreatedAt: model.CreatedAt,
+		}
+	}
+	return points, nil
+}
+
+// Redeem debits the points within the tenant of the client, whose idempotency keys are
+// independent of other tenants'.
+func (s *pointsService) Redeem(ctx context.Context, userID, idempotencyKey string, points int) (RedemptionDTO, error) {
+	if points <= 0 {
+		return RedemptionDTO{}, errors.New("points to redeem must be positive")
+	}
+	tenantID := auth.TenantID(ctx)
+	entry, replayed, err := s.ledgerRepo.Redeem(ctx, tenantID, userID, idempotencyKey, points)
+	if err != nil {
+		return RedemptionDTO{}, err
+	}
+	if replayed && (entry.UserID != userID || entry.Kind != repository.LedgerKindRedeem || entry.Points != -points) {
+		return RedemptionDTO{}, ErrIdempotencyKeyReused
+	}
+	balance, err := s.ledgerRepo.Balance(ctx, tenantID, userID)
+	if err != nil {
+		return RedemptionDTO{}, err
+	}
+	return RedemptionDTO{
+		ID:        strconv.FormatUint(uint64(entry.ID), 10),
+		UserID:    userID,
+		Points:    points,
+		Balance:   balance,
+		CreatedAt: entry.CreatedAt,
+		Replayed:  replayed,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+)
+
+// userContext returns a copy of ctx for a request made with a bearer token for the user.
+func userContext(ctx context.Context, userID string) context.Context {
+	return auth.WithClaims(ctx, auth.Claims{Subject: userID})
+}
+
+func TestGetUserPoints(t *testing.T) {
+	db := newTestDB(t, "user_points_test")
+	receipts := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	points := NewPointsService(repository.NewLedgerRepository(db))
+	ctx := context.Background()
+
+	receipt := ReceiptDTO{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+
+	// Receipts can only be submitted for another user by admins.
+	for _, submitter := range []context.Context{ctx, userContext(ctx, "user-2")} {
+		withUser := receipt
+		withUser.UserID = "user-1"
+		if _, err := receipts.ProcessReceipt(submitter, withUser); !errors.Is(err, ErrUserMismatch) {
+			t.Errorf("expected ErrUserMismatch, got %v", err)
+		}
+		var itemErr *BatchItemError
+		if _, err := receipts.ProcessBatch(submitter, []ReceiptDTO{withUser}, true); !errors.As(err, &itemErr) || !errors.Is(err, ErrUserMismatch) {
+			t.Errorf("expected a BatchItemError with ErrUserMismatch, got %v", err)
+		}
+	}
+
+	// The receipt's points are earned by the authenticated user.
+	id, err := receipts.ProcessReceipt(userContext(ctx, "user-1"), receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+	stored, err := receipts.GetReceipt(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if stored.UserID != "user-1" {
+		t.Errorf("expected the receipt to belong to user-1, got %q", stored.UserID)
+	}
+
+	// Submitting the receipt again, even for another user, earns no more points.
+	if _, err := receipts.ProcessReceipt(userContext(ctx, "user-2"), receipt); err != nil {
+		t.Fatalf("failed to process duplicate receipt: %v", err)
+	}
+	admin := auth.NewContext(ctx, auth.Identity{ClientID: "admin-key", TenantID: auth.DefaultTenant, Admin: true})
+	receipt.UserID = "user-2"
+	if _, err := receipts.ProcessReceipt(admin, receipt); err != nil {
+		t.Fatalf("failed to process duplicate receipt for user-2: %v", err)
+	}
+
+	balance, err := points.GetUserPoints(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("failed to get user points: %v", err)
+	}
+	if balance.UserID != "user-1" || balance.Points != stored.Points {
+		t.Errorf("expected %d points for user-1, got %+v", stored.Points, balance)
+	}
+	if len(balance.Entries) != 1 || balance.Entries[0].ReceiptID != id || balance.Entries[0].Points != stored.Points {
+		t.Errorf("expected a single entry for %s, got %+v", id, balance.Entries)
+	}
+	if other, err := points.GetUserPoints(ctx, "user-2"); err != nil || other.Points != 0 || len(other.Entries) != 0 {
+		t.Errorf("expected no points for user-2, got %+v (err: %v)", other, err)
+	}
+
+	// Balances are kept per tenant.
+	acme := auth.NewContext(ctx, auth.Identity{ClientID: "client-a", TenantID: "acme"})
+	if other, err := points.GetUserPoints(acme, "user-1"); err != nil || other.Points != 0 {
+		t.Errorf("expected no points for user-1 of acme, got %+v (err: %v)", other, err)
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	db := newTestDB(t, "redeem_test")
+	receipts := repository.NewReceiptRepository(db)
+	points := NewPointsService(repository.NewLedgerRepository(db))
+	ctx := context.Background()
+	if err := receipts.Save(ctx, repository.ReceiptModel{ID: "redeem-1", TenantID: auth.DefaultTenant, UserID: "user-1", Points: 100, Hash: "redeem-hash-1"}); err != nil {
+		t.Fatalf("failed to save receipt: %v", err)
+	}
+
+	redemption, err := points.Redeem(ctx, "user-1", "key-1", 60)
+	if err != nil {
+		t.Fatalf("failed to redeem points: %v", err)
+	}
+	if redemption.Points != 60 || redemption.Balance != 40 || redemption.Replayed {
+		t.Errorf("unexpected redemption %+v", redemption)
+	}
+
+	// A retry returns the original redemption.
+	retry, err := points.Redeem(ctx, "user-1", "key-1", 60)
+	if err != nil || !retry.Replayed || retry.ID != redemption.ID || retry.Balance != 40 {
+		t.Errorf("expected a replay of %+v, got %+v (err: %v)", redemption, retry, err)
+	}
+
+	testCases := []struct {
+		name          string
+		userID        string
+		key           string
+		points        int
+		expectedError error
+	}{
+		{name: "Key Reused For Another Amount", userID: "user-1", key: "key-1", points: 10, expectedError: ErrIdempotencyKeyReused},
+		{name: "Key Reused For Another User", userID: "user-2", key: "key-1", points: 60, expectedError: ErrIdempotencyKeyReused},
+		{name: "Overdraft", userID: "user-1", key: "key-2", points: 41, expectedError: ErrInsufficientPoints},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := points.Redeem(ctx, tc.userID, tc.key, tc.points); !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+
+	balance, err := points.GetUserPoints(ctx, "user-1")
+	if err != nil || balance.Points != 40 || len(balance.Entries) != 2 || balance.Entries[0].Kind != repository.LedgerKindRedeem {
+		t.Errorf("expected a balance of 40 after one redemption, got %+v (err: %v)", balance, err)
+	}
+}
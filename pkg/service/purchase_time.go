@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	// Embed the timezone database so IANA names resolve in minimal containers.
+	_ "time/tzdata"
+)
+
+const (
+	// PurchaseDateLayout is the layout of ReceiptDTO.PurchaseDate.
+	PurchaseDateLayout = "2006-01-02"
+	// PurchaseTimeLayout is the layout of ReceiptDTO.PurchaseTime.
+	PurchaseTimeLayout = "15:04"
+)
+
+// utcOffsetRegex matches a UTC offset such as "-05:00" or "+05:30".
+var utcOffsetRegex = regexp.MustCompile(`^[+-]\d{2}:\d{2}$`)
+
+// ParseTimezone resolves a store timezone given either as an IANA name, such as
+// "America/Chicago", or as a UTC offset, such as "-05:00". An empty timezone is UTC.
+func ParseTimezone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+	if utcOffsetRegex.MatchString(tz) {
+		hours, _ := strconv.Atoi(tz[1:3])
+		minutes, _ := strconv.Atoi(tz[4:6])
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("invalid UTC offset %q", tz)
+		}
+		offset := hours*3600 + minutes*60
+		if tz[0] == '-' {
+			offset = -offset
+		}
+		return time.FixedZone(tz, offset), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", tz)
+	}
+	return loc, nil
+}
+
+// PurchaseTime returns the moment of purchase in the store's local time.
+// The date and time must be valid calendar values.
+func PurchaseTime(receipt ReceiptDTO) (time.Time, error) {
+	loc, err := ParseTimezone(receipt.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	purchasedAt, err := time.ParseInLocation(PurchaseDateLayout+" "+PurchaseTimeLayout,
+		receipt.PurchaseDate+" "+receipt.PurchaseTime, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid purchase date or time: %v", err)
+	}
+	return purchasedAt, nil
+}
@@ -2,24 +2,37 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
-	"strconv"
 	"strings"
 
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/money"
 	"receipt_processor/pkg/repository"
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
 // ReceiptDTO represents the structure of a receipt as received from the API.
 type ReceiptDTO struct {
-	Retailer     string    `json:"retailer"`
-	PurchaseDate string    `json:"purchaseDate"` // Format: YYYY-MM-DD
-	PurchaseTime string    `json:"purchaseTime"` // Format: HH:MM (24-hour)
-	Total        string    `json:"total"`        // E.g. "35.35"
-	Items        []ItemDTO `json:"items"`
+	Retailer     string `json:"retailer"`
+	PurchaseDate string `json:"purchaseDate"` // Format: YYYY-MM-DD
+	PurchaseTime string `json:"purchaseTime"` // Format: HH:MM (24-hour)
+	// Timezone is the store's IANA timezone, e.g. "America/Chicago", or UTC offset,
+	// e.g. "-05:00". The purchase date and time are local to it. Defaults to UTC.
+	Timezone string    `json:"timezone,omitempty"`
+	Total    string    `json:"total"` // E.g. "35.35"
+	Items    []ItemDTO `json:"items"`
+	// UserID is the user who earns the receipt's points, if any: the authenticated user,
+	// by default. Only admins may submit receipts for other users. It is not part of the
+	// receipt's hash, so a receipt submitted again by another user is still a duplicate
+	// and its points are only earned once.
+	UserID string `json:"userId,omitempty"`
 }
 
 // ItemDTO represents an individual item within a receipt.
@@ -28,178 +41,517 @@ type ItemDTO struct {
 	Price            string `json:"price"` // E.g. "12.25"
 }
 
+// BatchResult is the outcome of processing a single receipt in a batch.
+type BatchResult struct {
+	ID        string `json:"id,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"` // True if the receipt was already stored, or repeated in the batch.
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchItemError is returned when a receipt of an atomic batch fails, which fails the whole batch.
+type BatchItemError struct {
+	Index int // Index of the receipt in the batch.
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("receipt %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the receipt, such as a *TotalMismatchError.
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// StoredReceiptDTO represents a processed receipt as returned from the API,
+// including the ID it was stored under and the points it was awarded.
+type StoredReceiptDTO struct {
+	ID     string `json:"id"`
+	Points int    `json:"points"`
+	// RuleVersion is the version of the rules that awarded the points. It is empty for
+	// receipts scored before versions were recorded.
+	RuleVersion string `json:"ruleVersion,omitempty"`
+	// MerchantID is the catalog merchant the retailer was mapped to, if any.
+	MerchantID string `json:"merchantId,omitempty"`
+	ReceiptDTO
+	Flags []string `json:"flags,omitempty"` // Reasons the receipt was flagged for review.
+}
+
+// ReceiptQuery holds the filters and pagination parameters for listing receipts.
+// Empty fields are ignored.
+type ReceiptQuery struct {
+	Retailer         string
+	MerchantID       string
+	PurchaseDateFrom string       // Inclusive, YYYY-MM-DD.
+	PurchaseDateTo   string       // Inclusive, YYYY-MM-DD.
+	MinTotal         *money.Cents // Inclusive.
+	MaxTotal         *money.Cents // Inclusive.
+	MinPoints        *int
+	FlaggedOnly      bool   // Only receipts flagged for review.
+	Cursor           string // Opaque cursor returned as NextCursor by a previous page.
+	Limit            int    // Defaults to DefaultPageSize and is capped at MaxPageSize.
+}
+
+// ReceiptPage is a single page of listed receipts.
+type ReceiptPage struct {
+	Receipts   []StoredReceiptDTO `json:"receipts"`
+	NextCursor string             `json:"nextCursor,omitempty"` // Empty on the last page.
+}
+
+const (
+	// DefaultPageSize is the number of receipts listed when no limit is given.
+	DefaultPageSize = 20
+	// MaxPageSize is the maximum number of receipts listed per page.
+	MaxPageSize = 100
+)
+
+var (
+	// ErrInvalidCursor is returned when a listing cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrUserMismatch is returned when a receipt is submitted for a user other than the
+	// authenticated one, by a client that may not act for that user.
+	ErrUserMismatch = errors.New("receipt user does not match the authenticated user")
+)
+
 // IReceiptService defines the methods available in the service layer.
 type IReceiptService interface {
 	// ProcessReceipt validates and processes a receipt.
-	// It generates a receipt ID, calculates the points, and saves the receipt.
+	// It generates a receipt ID, calculates the points, and saves the receipt. It returns
+	// ErrUserMismatch if the client may not submit receipts for the receipt's user.
 	ProcessReceipt(ctx context.Context, receipt ReceiptDTO) (string, error)
-	// GetPoints retrieves the points awarded for a given receipt ID.
-	GetPoints(ctx context.Context, receiptID string) (int, error)
+	// ProcessBatch processes several receipts. In atomic mode the receipts are saved in
+	// a single transaction and any failure fails the whole batch, with a *BatchItemError
+	// for the receipt that failed; otherwise each receipt is processed independently and
+	// failures are reported per receipt.
+	// The returned results are in the same order as the receipts.
+	ProcessBatch(ctx context.Context, receipts []ReceiptDTO, atomic bool) ([]BatchResult, error)
+	// GetPoints retrieves the points of a given receipt ID: those awarded, less any clawed
+	// back by voids and refunds, which are listed.
+	GetPoints(ctx context.Context, receiptID string) (ReceiptPointsDTO, error)
+	// GetReceipt retrieves the stored receipt, with its items, for a given receipt ID.
+	GetReceipt(ctx context.Context, receiptID string) (StoredReceiptDTO, error)
+	// ListReceipts returns a page of stored receipts matching the query, newest purchase date first.
+	ListReceipts(ctx context.Context, query ReceiptQuery) (ReceiptPage, error)
+	// GetBreakdown retrieves the per-rule points breakdown recorded when the receipt was scored.
+	GetBreakdown(ctx context.Context, receiptID string) (PointsBreakdown, error)
+	// VoidReceipt reverses the whole receipt, clawing back its points from the user's balance.
+	// It returns ErrAlreadyReversed if the receipt was already voided or fully refunded,
+	// ErrNotReceiptUser if the client may not act for the receipt's user, and ErrReversalConflict
+	// if other reversals of the receipt kept being stored while it was voided.
+	VoidReceipt(ctx context.Context, receiptID string) (ReversalDTO, error)
+	// RefundItems reverses the items of the receipt at the given indexes, clawing back the
+	// points the receipt no longer earns without them. It returns ErrInvalidItem for an
+	// unknown index, ErrAlreadyReversed if any of the items was already reversed,
+	// ErrNotReceiptUser if the client may not act for the receipt's user, and
+	// ErrReversalConflict if other reversals of the receipt kept being stored meanwhile.
+	RefundItems(ctx context.Context, receiptID string, items []int) (ReversalDTO, error)
 }
 
 // receiptService is the concrete implementation of IReceiptService.
 type receiptService struct {
-	receiptRepo repository.IReceiptRepository
+	receiptRepo  repository.IReceiptRepository
+	campaignRepo repository.ICampaignRepository
+	merchantRepo repository.IMerchantRepository
+	rules        RuleVersions
+	consistency  ConsistencyPolicy
 }
 
 // NewReceiptService creates a new instance of the receipt service.
-func NewReceiptService(receiptRepo repository.IReceiptRepository) IReceiptService {
+// Points are calculated by evaluating the active version of the rules in order, then the
+// tenant's campaigns, and receipts are checked against the consistency policy before they
+// are saved. Receipts are mapped to the merchants of the tenant's catalog by their retailer.
+func NewReceiptService(receiptRepo repository.IReceiptRepository, campaignRepo repository.ICampaignRepository, merchantRepo repository.IMerchantRepository, rules RuleVersions, consistency ConsistencyPolicy) IReceiptService {
 	return &receiptService{
-		receiptRepo: receiptRepo,
+		receiptRepo:  receiptRepo,
+		campaignRepo: campaignRepo,
+		merchantRepo: merchantRepo,
+		rules:        rules,
+		consistency:  consistency,
 	}
 }
 
 // ProcessReceipt handles receipt processing: it calculates points, checks for duplicates,
 // saves the receipt (if not a duplicate), and returns the generated or existing receipt ID.
 func (s *receiptService) ProcessReceipt(ctx context.Context, receipt ReceiptDTO) (string, error) {
+	// Receipts are stored under the tenant of the client, and are only duplicates within it.
+	tenantID := auth.TenantID(ctx)
+
+	// The receipt's points are earned by the authenticated user.
+	owner, err := receiptOwner(ctx, receipt.UserID)
+	if err != nil {
+		return "", err
+	}
+	receipt.UserID = owner
+
 	// Compute a hash for the receipt to detect duplicates.
-	hash := computeReceiptHash(receipt)
+	hash := computeReceiptHash(tenantID, receipt)
 
 	// Check for a duplicate receipt using the hash.
-	existing, err := s.receiptRepo.FindByHash(ctx, hash)
+	existing, err := s.findDuplicate(ctx, tenantID, hash, receipt)
 	if err == nil {
 		// Duplicate found: return the existing receipt's ID.
 		return existing.ID, nil
 	}
 
+	// Build the receipt model with a new ID and the calculated points.
+	model, err := s.buildReceiptModel(ctx, tenantID, receipt, hash)
+	if err != nil {
+		return "", err
+	}
+
+	// Save the receipt using the repository.
+	if err := s.receiptRepo.Save(ctx, model); err != nil {
+		return "", err
+	}
+
+	return model.ID, nil
+}
+
+// ProcessBatch processes several receipts, either atomically or independently.
+func (s *receiptService) ProcessBatch(ctx context.Context, receipts []ReceiptDTO, atomic bool) ([]BatchResult, error) {
+	tenantID := auth.TenantID(ctx)
+	results := make([]BatchResult, len(receipts))
+	if !atomic {
+		for i, receipt := range receipts {
+			results[i] = s.processBatchItem(ctx, tenantID, receipt)
+		}
+		return results, nil
+	}
+
+	// Build every new receipt first, then save them together.
+	var models []repository.ReceiptModel
+	seen := make(map[string]string) // Hash to receipt ID, for duplicates within the batch.
+	for i, receipt := range receipts {
+		owner, err := receiptOwner(ctx, receipt.UserID)
+		if err != nil {
+			return nil, &BatchItemError{Index: i, Err: err}
+		}
+		receipt.UserID = owner
+		hash := computeReceiptHash(tenantID, receipt)
+		if id, ok := seen[hash]; ok {
+			results[i] = BatchResult{ID: id, Duplicate: true}
+			continue
+		}
+		if existing, err := s.findDuplicate(ctx, tenantID, hash, receipt); err == nil {
+			seen[hash] = existing.ID
+			results[i] = BatchResult{ID: existing.ID, Duplicate: true}
+			continue
+		}
+		model, err := s.buildReceiptModel(ctx, tenantID, receipt, hash)
+		if err != nil {
+			return nil, &BatchItemError{Index: i, Err: err}
+		}
+		seen[hash] = model.ID
+		models = append(models, model)
+		results[i] = BatchResult{ID: model.ID}
+	}
+	if err := s.receiptRepo.SaveAll(ctx, models); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// processBatchItem processes a single receipt of a non-atomic batch, reporting failures in the result.
+func (s *receiptService) processBatchItem(ctx context.Context, tenantID string, receipt ReceiptDTO) BatchResult {
+	owner, err := receiptOwner(ctx, receipt.UserID)
+	if err != nil {
+		return BatchResult{Error: err.Error()}
+	}
+	receipt.UserID = owner
+	hash := computeReceiptHash(tenantID, receipt)
+	if existing, err := s.findDuplicate(ctx, tenantID, hash, receipt); err == nil {
+		return BatchResult{ID: existing.ID, Duplicate: true}
+	}
+	model, err := s.buildReceiptModel(ctx, tenantID, receipt, hash)
+	if err != nil {
+		return BatchResult{Error: err.Error()}
+	}
+	if err := s.receiptRepo.Save(ctx, model); err != nil {
+		return BatchResult{Error: err.Error()}
+	}
+	return BatchResult{ID: model.ID}
+}
+
+// receiptOwner returns the user who earns the points of a receipt submitted for userID: the
+// authenticated user if userID is empty. It returns ErrUserMismatch if the client may not act
+// for userID.
+func receiptOwner(ctx context.Context, userID string) (string, error) {
+	if userID == "" {
+		owner, _ := auth.UserID(ctx)
+		return owner, nil
+	}
+	if !auth.CanActFor(ctx, userID) {
+		return "", ErrUserMismatch
+	}
+	return userID, nil
+}
+
+// findDuplicate retrieves the tenant's stored receipt with the receipt's hash. Receipts stored
+// before retailers were normalized were hashed with the retailer as given, so they are looked
+// up by that hash too.
+func (s *receiptService) findDuplicate(ctx context.Context, tenantID, hash string, receipt ReceiptDTO) (repository.ReceiptModel, error) {
+	existing, err := s.receiptRepo.FindByHash(ctx, tenantID, hash)
+	if err == nil {
+		return existing, nil
+	}
+	if legacy := hashReceipt(tenantID, receipt.Retailer, receipt); legacy != hash {
+		return s.receiptRepo.FindByHash(ctx, tenantID, legacy)
+	}
+	return existing, err
+}
+
+// buildReceiptModel generates a new receipt ID, checks the receipt against the consistency
+// policy, calculates the points and converts the ReceiptDTO to the repository's model,
+// including the tenant and computed hash. Receipts rejected by the policy return a *TotalMismatchError.
+func (s *receiptService) buildReceiptModel(ctx context.Context, tenantID string, dto ReceiptDTO, hash string) (repository.ReceiptModel, error) {
 	// Generate a new unique receipt ID.
 	receiptID := uuid.New().String()
 
-	// Calculate points based on the receipt's data using the defined rules.
-	points, err := calculatePoints(receipt)
+	// Parse the receipt's amounts.
+	receipt, err := parseReceipt(dto)
 	if err != nil {
-		return "", err
+		return repository.ReceiptModel{}, err
+	}
+
+	// Check that the items add up to the total.
+	flag, err := s.consistency.Check(receipt)
+	if err != nil {
+		return repository.ReceiptModel{}, err
+	}
+	var flags []repository.ReceiptFlagModel
+	if flag != "" {
+		log.Ctx(ctx).Warn().Str("receipt_id", receiptID).Str("reason", flag).Msg("Receipt flagged for review")
+		flags = append(flags, repository.ReceiptFlagModel{Reason: flag})
+	}
+
+	// Calculate points based on the receipt's data using the active version of the rules.
+	version, rules := s.rules.Active()
+	breakdown, err := evaluateRules(receipt, rules)
+	if err != nil {
+		return repository.ReceiptModel{}, err
 	}
 
-	// Convert the ReceiptDTO to the repository's model, including the computed hash.
-	model := repository.ReceiptModel{
+	// Add the points of the tenant's campaigns running on the purchase date.
+	campaigns, err := s.campaignRepo.Running(ctx, tenantID, receipt.PurchaseDate)
+	if err != nil {
+		return repository.ReceiptModel{}, err
+	}
+	applyCampaigns(&breakdown, receipt, campaigns)
+
+	// Map the retailer to the merchant of the catalog, if there is one.
+	merchantID, err := s.merchantID(ctx, tenantID, receipt.Retailer)
+	if err != nil {
+		return repository.ReceiptModel{}, err
+	}
+
+	return repository.ReceiptModel{
 		ID:           receiptID,
+		TenantID:     tenantID,
+		UserID:       dto.UserID,
 		Retailer:     receipt.Retailer,
+		MerchantID:   merchantID,
 		PurchaseDate: receipt.PurchaseDate,
 		PurchaseTime: receipt.PurchaseTime,
+		Timezone:     dto.Timezone,
+		PurchasedAt:  receipt.PurchasedAt.UTC(),
 		Total:        receipt.Total,
 		Hash:         hash,
 		Items:        convertItems(receipt.Items),
-		Points:       points,
-	}
+		Points:       breakdown.Points,
+		RuleVersion:  version,
+		Breakdown:    convertBreakdown(breakdown),
+		Flags:        flags,
+	}, nil
+}
 
-	// Save the receipt using the repository.
-	if err := s.receiptRepo.Save(ctx, model); err != nil {
-		return "", err
+// merchantID returns the ID of the tenant's merchant with an alias that normalizes to the
+// same name as the retailer, or an empty ID if the catalog has none.
+func (s *receiptService) merchantID(ctx context.Context, tenantID, retailer string) (string, error) {
+	merchant, err := s.merchantRepo.FindByKey(ctx, tenantID, normalizeRetailer(retailer))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
 	}
-
-	return receiptID, nil
+	return merchant.ID, err
 }
 
-// GetPoints retrieves the points associated with a receipt by its ID.
-func (s *receiptService) GetPoints(ctx context.Context, receiptID string) (int, error) {
-	model, err := s.receiptRepo.GetByID(ctx, receiptID)
+// GetPoints retrieves the points associated with a receipt by its ID, and its reversals.
+func (s *receiptService) GetPoints(ctx context.Context, receiptID string) (ReceiptPointsDTO, error) {
+	model, err := s.receiptRepo.GetByID(ctx, auth.TenantID(ctx), receiptID)
 	if err != nil {
-		return 0, err
+		return ReceiptPointsDTO{}, err
 	}
-	return model.Points, nil
+	points := ReceiptPointsDTO{Points: netPoints(model), AwardedPoints: model.Points, Campaigns: campaignContributions(model)}
+	for _, reversal := range model.Reversals {
+		points.Reversals = append(points.Reversals, convertReversalModel(reversal))
+	}
+	return points, nil
 }
 
-// computeReceiptHash computes a hash for the receipt based on its content.
-// It concatenates key fields and uses xxhash to generate a hash string.
-func computeReceiptHash(receipt ReceiptDTO) string {
-	var sb strings.Builder
-	sb.WriteString(receipt.Retailer)
-	sb.WriteString(receipt.PurchaseDate)
-	sb.WriteString(receipt.PurchaseTime)
-	sb.WriteString(receipt.Total)
-	for _, item := range receipt.Items {
-		// Use the trimmed description.
-		sb.WriteString(strings.TrimSpace(item.ShortDescription))
-		sb.WriteString(item.Price)
+// GetReceipt retrieves a stored receipt and its items by its ID.
+func (s *receiptService) GetReceipt(ctx context.Context, receiptID string) (StoredReceiptDTO, error) {
+	model, err := s.receiptRepo.GetByID(ctx, auth.TenantID(ctx), receiptID)
+	if err != nil {
+		return StoredReceiptDTO{}, err
 	}
-	// Compute the hash using xxhash.
-	hashValue := xxhash.Sum64String(sb.String())
-	return fmt.Sprintf("%x", hashValue)
+	return convertReceiptModel(model), nil
 }
 
-// calculatePoints computes the total points for a receipt based on the following rules:
-//  1. One point for every alphanumeric character in the retailer name.
-//  2. 50 points if the total is a round dollar amount with no cents.
-//  3. 25 points if the total is a multiple of 0.25.
-//  4. 5 points for every two items on the receipt.
-//  5. For each item, if the trimmed description length is a multiple of 3,
-//     add ceil(price * 0.2) to the points.
-//  6. 5 points if the total is greater than 10.00.
-//  7. 6 points if the day in the purchase date is odd.
-//  8. 10 points if the time of purchase is after 2:00pm and before 4:00pm.
-func calculatePoints(receipt ReceiptDTO) (int, error) {
-	var points int
-
-	// Rule 1: One point for every alphanumeric character in the retailer name.
-	for _, ch := range receipt.Retailer {
-		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') {
-			points++
+// ListReceipts returns a page of stored receipts matching the query.
+func (s *receiptService) ListReceipts(ctx context.Context, query ReceiptQuery) (ReceiptPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	var after *repository.ReceiptCursor
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return ReceiptPage{}, err
 		}
+		after = &cursor
 	}
 
-	// Parse the total amount.
-	total, err := strconv.ParseFloat(receipt.Total, 64)
+	filter := repository.ReceiptFilter{
+		Retailer:         query.Retailer,
+		MerchantID:       query.MerchantID,
+		PurchaseDateFrom: query.PurchaseDateFrom,
+		PurchaseDateTo:   query.PurchaseDateTo,
+		MinTotal:         query.MinTotal,
+		MaxTotal:         query.MaxTotal,
+		MinPoints:        query.MinPoints,
+		FlaggedOnly:      query.FlaggedOnly,
+	}
+	// Fetch one extra receipt to find out whether there is a next page.
+	models, err := s.receiptRepo.List(ctx, auth.TenantID(ctx), filter, after, limit+1)
 	if err != nil {
-		return 0, fmt.Errorf("invalid total amount: %v", err)
+		return ReceiptPage{}, err
 	}
 
-	// Rule 2: 50 points if the total is a round dollar amount (no cents).
-	if total == float64(int(total)) {
-		points += 50
+	page := ReceiptPage{Receipts: []StoredReceiptDTO{}}
+	if len(models) > limit {
+		models = models[:limit]
+		last := models[limit-1]
+		page.NextCursor = encodeCursor(repository.ReceiptCursor{PurchaseDate: last.PurchaseDate, ID: last.ID})
 	}
+	for _, model := range models {
+		page.Receipts = append(page.Receipts, convertReceiptModel(model))
+	}
+	return page, nil
+}
 
-	// Rule 3: 25 points if the total is a multiple of 0.25.
-	if math.Mod(total, 0.25) == 0 {
-		points += 25
+// GetBreakdown retrieves the points breakdown stored with a receipt by its ID.
+func (s *receiptService) GetBreakdown(ctx context.Context, receiptID string) (PointsBreakdown, error) {
+	model, err := s.receiptRepo.GetByID(ctx, auth.TenantID(ctx), receiptID)
+	if err != nil {
+		return PointsBreakdown{}, err
+	}
+	breakdown := PointsBreakdown{Points: model.Points, RuleVersion: model.RuleVersion, Rules: []RuleContribution{}}
+	for _, c := range model.Breakdown {
+		contribution := RuleContribution{Rule: c.Rule, Campaign: c.CampaignID, Points: c.Points}
+		for _, item := range c.Items {
+			contribution.Items = append(contribution.Items, ItemContribution{
+				Index:            item.ItemIndex,
+				ShortDescription: item.ShortDescription,
+				Price:            item.Price,
+				Points:           item.Points,
+			})
+		}
+		breakdown.Rules = append(breakdown.Rules, contribution)
 	}
+	return breakdown, nil
+}
 
-	// Rule 4: 5 points for every two items on the receipt.
-	itemCount := len(receipt.Items)
-	points += (itemCount / 2) * 5
+// encodeCursor encodes a repository cursor as an opaque URL-safe string.
+func encodeCursor(cursor repository.ReceiptCursor) string {
+	data, _ := json.Marshal([]string{cursor.PurchaseDate, cursor.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
 
-	// Rule 5: For each item, if the trimmed description length is a multiple of 3,
-	// add ceil(price * 0.2) to the points.
-	for _, item := range receipt.Items {
-		trimmed := strings.TrimSpace(item.ShortDescription)
-		if len(trimmed)%3 == 0 {
-			price, err := strconv.ParseFloat(item.Price, 64)
-			if err != nil {
-				return 0, fmt.Errorf("invalid item price: %v", err)
-			}
-			extra := math.Ceil(price * 0.2)
-			points += int(extra)
-		}
+// decodeCursor decodes a cursor produced by encodeCursor.
+func decodeCursor(s string) (repository.ReceiptCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return repository.ReceiptCursor{}, ErrInvalidCursor
+	}
+	var parts []string
+	if err := json.Unmarshal(data, &parts); err != nil || len(parts) != 2 {
+		return repository.ReceiptCursor{}, ErrInvalidCursor
 	}
+	return repository.ReceiptCursor{PurchaseDate: parts[0], ID: parts[1]}, nil
+}
+
+// computeReceiptHash computes a hash for the tenant's receipt based on its content.
+// The retailer is normalized, so e.g. "Target" and "TARGET Store #123" receipts are duplicates.
+func computeReceiptHash(tenantID string, receipt ReceiptDTO) string {
+	return hashReceipt(tenantID, normalizeRetailer(receipt.Retailer), receipt)
+}
 
-	// Rule 6: 5 points if the total is greater than 10.00.
-	if total > 10.00 {
-		points += 5
+// hashReceipt concatenates the key fields of the tenant's receipt, with the given retailer
+// name, and uses xxhash to generate a hash string.
+func hashReceipt(tenantID, retailer string, receipt ReceiptDTO) string {
+	var sb strings.Builder
+	// Only include other tenants than the default, so hashes stored before tenants are unchanged.
+	if tenantID != auth.DefaultTenant {
+		sb.WriteString(tenantID)
+		sb.WriteString("\x00")
+	}
+	sb.WriteString(retailer)
+	sb.WriteString(receipt.PurchaseDate)
+	sb.WriteString(receipt.PurchaseTime)
+	// Only include the timezone when present, so hashes of receipts without one are unchanged.
+	if receipt.Timezone != "" {
+		sb.WriteString(receipt.Timezone)
 	}
+	sb.WriteString(receipt.Total)
+	for _, item := range receipt.Items {
+		// Use the trimmed description.
+		sb.WriteString(strings.TrimSpace(item.ShortDescription))
+		sb.WriteString(item.Price)
+	}
+	// Compute the hash using xxhash.
+	hashValue := xxhash.Sum64String(sb.String())
+	return fmt.Sprintf("%x", hashValue)
+}
 
-	// Rule 7: 6 points if the day in the purchase date is odd.
-	parts := strings.Split(receipt.PurchaseDate, "-")
-	if len(parts) == 3 {
-		day, err := strconv.Atoi(parts[2])
-		if err == nil && day%2 == 1 {
-			points += 6
-		}
+// calculatePoints computes the total points for a receipt by evaluating each
+// rule in the rule set in order and summing their points. The returned breakdown
+// records every rule that awarded points, along with the items that triggered it.
+func calculatePoints(dto ReceiptDTO, rules RuleSet) (PointsBreakdown, error) {
+	receipt, err := parseReceipt(dto)
+	if err != nil {
+		return PointsBreakdown{}, err
 	}
+	return evaluateRules(receipt, rules)
+}
 
-	// Rule 8: 10 points if the time of purchase is after 2:00pm and before 4:00pm.
-	timeParts := strings.Split(receipt.PurchaseTime, ":")
-	if len(timeParts) == 2 {
-		hour, err := strconv.Atoi(timeParts[0])
-		if err == nil && hour >= 14 && hour < 16 {
-			points += 10
+// evaluateRules evaluates each rule in order against a parsed receipt.
+func evaluateRules(receipt Receipt, rules RuleSet) (PointsBreakdown, error) {
+	breakdown := PointsBreakdown{Rules: []RuleContribution{}}
+	for _, rule := range rules {
+		result, err := rule.Evaluate(receipt)
+		if err != nil {
+			return PointsBreakdown{}, err
+		}
+		if result.Points == 0 {
+			continue
 		}
+		breakdown.Points += result.Points
+		breakdown.Rules = append(breakdown.Rules, RuleContribution{
+			Rule:   rule.Name(),
+			Points: result.Points,
+			Items:  result.Items,
+		})
 	}
-
-	return points, nil
+	return breakdown, nil
 }
 
-// convertItems transforms a slice of ItemDTO into a slice of repository.ItemModel.
-func convertItems(items []ItemDTO) []repository.ItemModel {
+// convertItems transforms a slice of Item into a slice of repository.ItemModel.
+func convertItems(items []Item) []repository.ItemModel {
 	var models []repository.ItemModel
 	for _, item := range items {
 		models = append(models, repository.ItemModel{
@@ -209,3 +561,57 @@ func convertItems(items []ItemDTO) []repository.ItemModel {
 	}
 	return models
 }
+
+// convertReceiptModel transforms a repository.ReceiptModel into a StoredReceiptDTO.
+func convertReceiptModel(model repository.ReceiptModel) StoredReceiptDTO {
+	items := make([]ItemDTO, 0, len(model.Items))
+	for _, item := range model.Items {
+		items = append(items, ItemDTO{
+			ShortDescription: item.ShortDescription,
+			Price:            item.Price.String(),
+		})
+	}
+	var flags []string
+	for _, flag := range model.Flags {
+		flags = append(flags, flag.Reason)
+	}
+	return StoredReceiptDTO{
+		ID:          model.ID,
+		Points:      model.Points,
+		RuleVersion: model.RuleVersion,
+		MerchantID:  model.MerchantID,
+		Flags:       flags,
+		ReceiptDTO: ReceiptDTO{
+			Retailer:     model.Retailer,
+			PurchaseDate: model.PurchaseDate,
+			PurchaseTime: model.PurchaseTime,
+			Timezone:     model.Timezone,
+			Total:        model.Total.String(),
+			Items:        items,
+			UserID:       model.UserID,
+		},
+	}
+}
+
+// convertBreakdown transforms a PointsBreakdown into a slice of repository.RuleContributionModel.
+func convertBreakdown(breakdown PointsBreakdown) []repository.RuleContributionModel {
+	var models []repository.RuleContributionModel
+	for _, c := range breakdown.Rules {
+		model := repository.RuleContributionModel{
+			Rule:       c.Rule,
+			CampaignID: c.Campaign,
+			Multiplier: c.Multiplier,
+			Points:     c.Points,
+		}
+		for _, item := range c.Items {
+			model.Items = append(model.Items, repository.ItemContributionModel{
+				ItemIndex:        item.Index,
+				ShortDescription: item.ShortDescription,
+				Price:            item.Price,
+				Points:           item.Points,
+			})
+		}
+		models = append(models, model)
+	}
+	return models
+}
@@ -2,12 +2,29 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 
+	"receipt_processor/pkg/auth"
 	"receipt_processor/pkg/database"
 	"receipt_processor/pkg/repository"
+
+	"github.com/cespare/xxhash/v2"
+	"gorm.io/gorm"
 )
 
+// newTestDB returns a dedicated in-memory database of the given name, so the data of other
+// tests does not interfere.
+func newTestDB(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	db, err := database.New("file:" + name + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+	return db
+}
+
 func TestProcessReceiptDuplicatePrevention(t *testing.T) {
 	// Set up an in-memory SQLite database.
 	db, err := database.New("file::memory:?cache=shared")
@@ -15,7 +32,7 @@ func TestProcessReceiptDuplicatePrevention(t *testing.T) {
 		t.Fatalf("failed to create in-memory db: %v", err)
 	}
 	repo := repository.NewReceiptRepository(db)
-	svc := NewReceiptService(repo)
+	svc := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
 	ctx := context.Background()
 
 	// Define a base receipt.
@@ -78,3 +95,315 @@ func TestProcessReceiptDuplicatePrevention(t *testing.T) {
 		})
 	}
 }
+
+func TestGetBreakdownPersisted(t *testing.T) {
+	// Set up an in-memory SQLite database.
+	db, err := database.New("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+	repo := repository.NewReceiptRepository(db)
+	ctx := context.Background()
+
+	receipt := ReceiptDTO{
+		Retailer:     "Breakdown Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	}
+	id, err := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{}).ProcessReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	// Change the rules: the stored breakdown must not be affected.
+	rules, err := NewRuleSet([]RuleConfig{{Name: "round_total", Points: 1000}})
+	if err != nil {
+		t.Fatalf("failed to build rules: %v", err)
+	}
+	breakdown, err := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), NewRuleVersions("v2", rules), ConsistencyPolicy{}).GetBreakdown(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get breakdown: %v", err)
+	}
+
+	expected, err := calculatePoints(receipt, DefaultRuleSet())
+	if err != nil {
+		t.Fatalf("failed to calculate points: %v", err)
+	}
+	if breakdown.Points != expected.Points || breakdown.RuleVersion != DefaultRuleVersion {
+		t.Errorf("expected %d points scored by %s, got %d by %q", expected.Points, DefaultRuleVersion, breakdown.Points, breakdown.RuleVersion)
+	}
+	if len(breakdown.Rules) != len(expected.Rules) {
+		t.Fatalf("expected %d rules, got %d", len(expected.Rules), len(breakdown.Rules))
+	}
+	for i := range expected.Rules {
+		if breakdown.Rules[i].Rule != expected.Rules[i].Rule || breakdown.Rules[i].Points != expected.Rules[i].Points {
+			t.Errorf("rule %d: expected %+v, got %+v", i, expected.Rules[i], breakdown.Rules[i])
+		}
+		if len(breakdown.Rules[i].Items) != len(expected.Rules[i].Items) {
+			t.Errorf("rule %d: expected %d items, got %d", i, len(expected.Rules[i].Items), len(breakdown.Rules[i].Items))
+		}
+	}
+}
+
+func TestGetReceipt(t *testing.T) {
+	// Set up an in-memory SQLite database.
+	db, err := database.New("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+	svc := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	ctx := context.Background()
+
+	receipt := ReceiptDTO{
+		Retailer:     "Retrieval Market",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "08:13",
+		Timezone:     "America/Chicago",
+		Total:        "2.65",
+		Items: []ItemDTO{
+			{ShortDescription: "Pepsi - 12-oz", Price: "1.25"},
+			{ShortDescription: "Dasani", Price: "1.40"},
+		},
+	}
+	id, err := svc.ProcessReceipt(ctx, receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	stored, err := svc.GetReceipt(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if stored.ID != id {
+		t.Errorf("expected ID %s, got %s", id, stored.ID)
+	}
+	if stored.Retailer != receipt.Retailer || stored.PurchaseDate != receipt.PurchaseDate ||
+		stored.PurchaseTime != receipt.PurchaseTime || stored.Timezone != receipt.Timezone ||
+		stored.Total != receipt.Total {
+		t.Errorf("stored receipt %+v does not match %+v", stored.ReceiptDTO, receipt)
+	}
+	if len(stored.Items) != len(receipt.Items) {
+		t.Fatalf("expected %d items, got %d", len(receipt.Items), len(stored.Items))
+	}
+	for i, item := range receipt.Items {
+		if stored.Items[i] != item {
+			t.Errorf("item %d: expected %+v, got %+v", i, item, stored.Items[i])
+		}
+	}
+
+	// Unknown IDs return an error.
+	if _, err := svc.GetReceipt(ctx, "missing-id"); err == nil {
+		t.Errorf("expected error for unknown receipt ID, got nil")
+	}
+}
+
+func TestListReceiptsPagination(t *testing.T) {
+	db := newTestDB(t, "list_receipts_test")
+	svc := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	ctx := context.Background()
+
+	// Store five receipts with distinct purchase dates.
+	for _, date := range []string{"2022-01-01", "2022-01-02", "2022-01-03", "2022-01-04", "2022-01-05"} {
+		_, err := svc.ProcessReceipt(ctx, ReceiptDTO{
+			Retailer:     "Paging Market",
+			PurchaseDate: date,
+			PurchaseTime: "10:00",
+			Total:        "1.00",
+			Items:        []ItemDTO{{ShortDescription: "Item", Price: "1.00"}},
+		})
+		if err != nil {
+			t.Fatalf("failed to process receipt: %v", err)
+		}
+	}
+
+	// Walk every page and collect the purchase dates.
+	var dates []string
+	query := ReceiptQuery{Retailer: "Paging Market", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("pagination did not terminate")
+		}
+		page, err := svc.ListReceipts(ctx, query)
+		if err != nil {
+			t.Fatalf("failed to list receipts: %v", err)
+		}
+		for _, receipt := range page.Receipts {
+			dates = append(dates, receipt.PurchaseDate)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	expected := []string{"2022-01-05", "2022-01-04", "2022-01-03", "2022-01-02", "2022-01-01"}
+	if len(dates) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, dates)
+	}
+	for i := range expected {
+		if dates[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, dates)
+			break
+		}
+	}
+
+	// A malformed cursor is rejected.
+	if _, err := svc.ListReceipts(ctx, ReceiptQuery{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestProcessBatch(t *testing.T) {
+	// Set up an in-memory SQLite database.
+	db, err := database.New("file::memory:?cache=shared")
+	if err != nil {
+		t.Fatalf("failed to create in-memory db: %v", err)
+	}
+	svc := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	ctx := context.Background()
+
+	newReceipt := func(retailer string) ReceiptDTO {
+		return ReceiptDTO{
+			Retailer:     retailer,
+			PurchaseDate: "2022-01-01",
+			PurchaseTime: "13:01",
+			Total:        "1.00",
+			Items:        []ItemDTO{{ShortDescription: "Item", Price: "1.00"}},
+		}
+	}
+
+	// A receipt stored before the batch is reported as a duplicate.
+	existingID, err := svc.ProcessReceipt(ctx, newReceipt("Batch Existing"))
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	for _, atomic := range []bool{true, false} {
+		suffix := "PerItem"
+		if atomic {
+			suffix = "Atomic"
+		}
+		t.Run(suffix, func(t *testing.T) {
+			receipts := []ReceiptDTO{
+				newReceipt("Batch New " + suffix),
+				newReceipt("Batch Existing"),
+				newReceipt("Batch New " + suffix), // Repeated within the batch.
+			}
+			results, err := svc.ProcessBatch(ctx, receipts, atomic)
+			if err != nil {
+				t.Fatalf("failed to process batch: %v", err)
+			}
+			if len(results) != len(receipts) {
+				t.Fatalf("expected %d results, got %d", len(receipts), len(results))
+			}
+			if results[0].ID == "" || results[0].Duplicate || results[0].Error != "" {
+				t.Errorf("expected a new receipt, got %+v", results[0])
+			}
+			if results[1].ID != existingID || !results[1].Duplicate {
+				t.Errorf("expected duplicate of %s, got %+v", existingID, results[1])
+			}
+			if results[2].ID != results[0].ID || !results[2].Duplicate {
+				t.Errorf("expected duplicate of %s, got %+v", results[0].ID, results[2])
+			}
+			if _, err := svc.GetPoints(ctx, results[0].ID); err != nil {
+				t.Errorf("expected new receipt to be stored: %v", err)
+			}
+		})
+	}
+
+	// In atomic mode, a receipt that cannot be scored fails the whole batch.
+	invalid := newReceipt("Batch Invalid")
+	invalid.Total = "abc"
+	if _, err := svc.ProcessBatch(ctx, []ReceiptDTO{newReceipt("Batch Rolled Back"), invalid}, true); err == nil {
+		t.Fatalf("expected error for atomic batch with an invalid receipt, got nil")
+	}
+	page, err := svc.ListReceipts(ctx, ReceiptQuery{Retailer: "Batch Rolled Back"})
+	if err != nil {
+		t.Fatalf("failed to list receipts: %v", err)
+	}
+	if len(page.Receipts) != 0 {
+		t.Errorf("expected no receipts to be saved from a failed atomic batch, got %d", len(page.Receipts))
+	}
+
+	// In per-item mode, the failure is reported for that receipt only.
+	results, err := svc.ProcessBatch(ctx, []ReceiptDTO{newReceipt("Batch Kept"), invalid}, false)
+	if err != nil {
+		t.Fatalf("failed to process batch: %v", err)
+	}
+	if results[0].ID == "" || results[1].Error == "" {
+		t.Errorf("expected first receipt saved and second failed, got %+v", results)
+	}
+}
+
+func TestProcessReceiptTenantIsolation(t *testing.T) {
+	db := newTestDB(t, "tenant_isolation_test")
+	svc := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	acme := auth.NewContext(context.Background(), auth.Identity{ClientID: "client-a", TenantID: "acme"})
+	globex := auth.NewContext(context.Background(), auth.Identity{ClientID: "client-b", TenantID: "globex"})
+
+	receipt := ReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "6.49",
+		Items:        []ItemDTO{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+	}
+
+	// Identical receipts of different tenants are not duplicates of each other.
+	acmeID, err := svc.ProcessReceipt(acme, receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt for acme: %v", err)
+	}
+	globexID, err := svc.ProcessReceipt(globex, receipt)
+	if err != nil {
+		t.Fatalf("failed to process receipt for globex: %v", err)
+	}
+	if acmeID == globexID {
+		t.Fatalf("expected different ids for different tenants, but both returned %s", acmeID)
+	}
+	if again, err := svc.ProcessReceipt(acme, receipt); err != nil || again != acmeID {
+		t.Errorf("expected duplicate of %s within acme, got %s (err: %v)", acmeID, again, err)
+	}
+
+	// A tenant cannot read another tenant's receipt.
+	if _, err := svc.GetReceipt(acme, acmeID); err != nil {
+		t.Errorf("failed to get own receipt: %v", err)
+	}
+	if _, err := svc.GetReceipt(globex, acmeID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected gorm.ErrRecordNotFound for another tenant's receipt, got %v", err)
+	}
+	if _, err := svc.GetPoints(context.Background(), acmeID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected the default tenant not to see acme's receipt, got %v", err)
+	}
+	page, err := svc.ListReceipts(globex, ReceiptQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to list receipts: %v", err)
+	}
+	if len(page.Receipts) != 1 || page.Receipts[0].ID != globexID {
+		t.Errorf("expected only %s for globex, got %+v", globexID, page.Receipts)
+	}
+}
+
+func TestComputeReceiptHashTenant(t *testing.T) {
+	receipt := ReceiptDTO{Retailer: "Target", PurchaseDate: "2022-01-01", PurchaseTime: "13:01", Total: "1.00"}
+
+	// The default tenant's hash is the one stored before tenants were introduced, with the
+	// retailer normalized. The hash with the retailer as given is the one stored before
+	// retailers were normalized.
+	if got, want := computeReceiptHash(auth.DefaultTenant, receipt), fmt.Sprintf("%x", xxhash.Sum64String("target2022-01-0113:011.00")); got != want {
+		t.Errorf("expected default tenant hash %s, got %s", want, got)
+	}
+	if got, want := hashReceipt(auth.DefaultTenant, receipt.Retailer, receipt), fmt.Sprintf("%x", xxhash.Sum64String("Target2022-01-0113:011.00")); got != want {
+		t.Errorf("expected legacy default tenant hash %s, got %s", want, got)
+	}
+	if computeReceiptHash("acme", receipt) == computeReceiptHash("globex", receipt) {
+		t.Errorf("expected different hashes for different tenants")
+	}
+}
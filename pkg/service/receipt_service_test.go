@@ -83,7 +83,7 @@ func TestCalculatePoints(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			points, err := calculatePoints(tc.receipt)
+			breakdown, err := calculatePoints(tc.receipt, DefaultRuleSet())
 			if tc.expectError {
 				if err == nil {
 					t.Errorf("expected error but got nil")
@@ -92,10 +92,55 @@ func TestCalculatePoints(t *testing.T) {
 				if err != nil {
 					t.Errorf("did not expect error but got: %v", err)
 				}
-				if points != tc.expectedPoints {
-					t.Errorf("expected %d points, got %d", tc.expectedPoints, points)
+				if breakdown.Points != tc.expectedPoints {
+					t.Errorf("expected %d points, got %d", tc.expectedPoints, breakdown.Points)
 				}
 			}
 		})
 	}
 }
+
+func TestCalculatePointsBreakdown(t *testing.T) {
+	receipt := ReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "35.35",
+		Items: []ItemDTO{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+	}
+
+	breakdown, err := calculatePoints(receipt, DefaultRuleSet())
+	if err != nil {
+		t.Fatalf("did not expect error but got: %v", err)
+	}
+
+	// Only rules that awarded points are recorded, in evaluation order.
+	expectedRules := []RuleContribution{
+		{Rule: "retailer_alphanumeric", Points: 6},
+		{Rule: "item_pairs", Points: 10},
+		{Rule: "item_description_length", Points: 6},
+		{Rule: "total_above", Points: 5},
+		{Rule: "odd_purchase_day", Points: 6},
+	}
+	if len(breakdown.Rules) != len(expectedRules) {
+		t.Fatalf("expected %d rules, got %d: %+v", len(expectedRules), len(breakdown.Rules), breakdown.Rules)
+	}
+	for i, expected := range expectedRules {
+		got := breakdown.Rules[i]
+		if got.Rule != expected.Rule || got.Points != expected.Points {
+			t.Errorf("rule %d: expected %s=%d, got %s=%d", i, expected.Rule, expected.Points, got.Rule, got.Points)
+		}
+	}
+
+	// The item description rule records the items that triggered it.
+	items := breakdown.Rules[2].Items
+	if len(items) != 2 || items[0].Index != 1 || items[0].Points != 3 || items[1].Index != 4 || items[1].Points != 3 {
+		t.Errorf("unexpected item contributions: %+v", items)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"receipt_processor/pkg/repository"
+)
+
+// RescoreDiff is a stored receipt that a version of the rules scores differently.
+type RescoreDiff struct {
+	ReceiptID    string
+	PurchaseDate string
+	// RuleVersion is the version that scored the receipt. It is empty for receipts scored
+	// before versions were recorded.
+	RuleVersion string
+	Points      int // The points the rules awarded the receipt, without those of campaigns.
+	NewPoints   int // The points the rescoring version awards.
+}
+
+// RescoreReport is the outcome of rescoring receipts with a version of the rules.
+type RescoreReport struct {
+	RuleVersion string
+	Receipts    int           // The number of receipts rescored.
+	Diffs       []RescoreDiff // Newest purchase date first.
+}
+
+// IRescoreService defines the interface for comparing stored receipts against other
+// versions of the rules.
+type IRescoreService interface {
+	// Rescore scores the tenant's receipts purchased from from to to (inclusive, YYYY-MM-DD;
+	// empty for no bound) with the rule version, and reports those whose points differ. The
+	// stored points, breakdowns and rule versions are not changed.
+	Rescore(ctx context.Context, tenantID, ruleVersion, from, to string) (RescoreReport, error)
+}
+
+// rescoreService is the concrete implementation of IRescoreService.
+type rescoreService struct {
+	receiptRepo repository.IReceiptRepository
+	rules       RuleVersions
+}
+
+// NewRescoreService creates a new instance of the rescore service for the loaded rule versions.
+func NewRescoreService(receiptRepo repository.IReceiptRepository, rules RuleVersions) IRescoreService {
+	return &rescoreService{
+		receiptRepo: receiptRepo,
+		rules:       rules,
+	}
+}
+
+// Rescore pages through the receipts in the date range, MaxPageSize at a time. Only the
+// points of the rules are compared, as campaigns do not depend on the rule version.
+func (s *rescoreService) Rescore(ctx context.Context, tenantID, ruleVersion, from, to string) (RescoreReport, error) {
+	rules, ok := s.rules.Get(ruleVersion)
+	if !ok {
+		return RescoreReport{}, fmt.Errorf("unknown rule version %q", ruleVersion)
+	}
+	report := RescoreReport{RuleVersion: ruleVersion}
+	filter := repository.ReceiptFilter{PurchaseDateFrom: from, PurchaseDateTo: to}
+	var after *repository.ReceiptCursor
+	for {
+		models, err := s.receiptRepo.List(ctx, tenantID, filter, after, MaxPageSize)
+		if err != nil {
+			return RescoreReport{}, err
+		}
+		for _, model := range models {
+			receipt, err := parseReceipt(convertReceiptModel(model).ReceiptDTO)
+			if err != nil {
+				return RescoreReport{}, fmt.Errorf("receipt %s: %w", model.ID, err)
+			}
+			breakdown, err := evaluateRules(receipt, rules)
+			if err != nil {
+				return RescoreReport{}, fmt.Errorf("receipt %s: %w", model.ID, err)
+			}
+			points := model.Points
+			for _, campaign := range campaignContributions(model) {
+				points -= campaign.Points
+			}
+			report.Receipts++
+			if breakdown.Points != points {
+				report.Diffs = append(report.Diffs, RescoreDiff{
+					ReceiptID:    model.ID,
+					PurchaseDate: model.PurchaseDate,
+					RuleVersion:  model.RuleVersion,
+					Points:       points,
+					NewPoints:    breakdown.Points,
+				})
+			}
+		}
+		if len(models) < MaxPageSize {
+			return report, nil
+		}
+		last := models[len(models)-1]
+		after = &repository.ReceiptCursor{PurchaseDate: last.PurchaseDate, ID: last.ID}
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+)
+
+func TestRescore(t *testing.T) {
+	db := newTestDB(t, "rescore_test")
+	repo := repository.NewReceiptRepository(db)
+	versions := DefaultRuleVersions()
+	// Version v2 doubles the round total bonus.
+	configs := DefaultRuleConfigs()
+	configs[1].Points = 100
+	v2, err := NewRuleSet(configs)
+	if err != nil {
+		t.Fatalf("failed to build rules: %v", err)
+	}
+	if err := versions.Add("v2", v2); err != nil {
+		t.Fatalf("failed to add rule version: %v", err)
+	}
+	if err := versions.Add("v2", v2); err == nil {
+		t.Errorf("expected an error adding a version twice")
+	}
+	receipts := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), versions, ConsistencyPolicy{})
+	ctx := context.Background()
+
+	// Only the round totals score differently under v2.
+	ids := make(map[string]string)
+	for _, receipt := range []ReceiptDTO{
+		{Retailer: "Target", PurchaseDate: "2024-01-05", PurchaseTime: "10:00", Total: "2.00", Items: []ItemDTO{{ShortDescription: "Soda", Price: "2.00"}}},
+		{Retailer: "Target", PurchaseDate: "2024-02-05", PurchaseTime: "10:00", Total: "2.50", Items: []ItemDTO{{ShortDescription: "Soda", Price: "2.50"}}},
+		{Retailer: "Target", PurchaseDate: "2024-03-05", PurchaseTime: "10:00", Total: "3.00", Items: []ItemDTO{{ShortDescription: "Soda", Price: "3.00"}}},
+	} {
+		id, err := receipts.ProcessReceipt(ctx, receipt)
+		if err != nil {
+			t.Fatalf("failed to process receipt: %v", err)
+		}
+		ids[receipt.PurchaseDate] = id
+	}
+	stored, err := receipts.GetReceipt(ctx, ids["2024-01-05"])
+	if err != nil {
+		t.Fatalf("failed to get receipt: %v", err)
+	}
+	if stored.RuleVersion != DefaultRuleVersion {
+		t.Errorf("expected the receipt to be scored by %s, got %q", DefaultRuleVersion, stored.RuleVersion)
+	}
+
+	rescore := NewRescoreService(repo, versions)
+	report, err := rescore.Rescore(ctx, auth.DefaultTenant, "v2", "2024-01-01", "2024-02-29")
+	if err != nil {
+		t.Fatalf("failed to rescore: %v", err)
+	}
+	if report.RuleVersion != "v2" || report.Receipts != 2 || len(report.Diffs) != 1 {
+		t.Fatalf("expected 1 of 2 receipts to differ under v2, got %+v", report)
+	}
+	diff := report.Diffs[0]
+	if diff.ReceiptID != ids["2024-01-05"] || diff.RuleVersion != DefaultRuleVersion || diff.NewPoints-diff.Points != 50 {
+		t.Errorf("expected the 2024-01-05 receipt to gain 50 points, got %+v", diff)
+	}
+
+	// The stored points are not changed.
+	if again, err := receipts.GetReceipt(ctx, ids["2024-01-05"]); err != nil || again.Points != stored.Points {
+		t.Errorf("expected %d stored points, got %d (err: %v)", stored.Points, again.Points, err)
+	}
+
+	// Without bounds every receipt is rescored; receipts of other tenants are not.
+	if report, err := rescore.Rescore(ctx, auth.DefaultTenant, "v2", "", ""); err != nil || report.Receipts != 3 || len(report.Diffs) != 2 {
+		t.Errorf("expected 2 of 3 receipts to differ, got %+v (err: %v)", report, err)
+	}
+	if report, err := rescore.Rescore(ctx, "acme", "v2", "", ""); err != nil || report.Receipts != 0 {
+		t.Errorf("expected no receipts of acme, got %+v (err: %v)", report, err)
+	}
+	if _, err := rescore.Rescore(ctx, auth.DefaultTenant, "v3", "", ""); err == nil {
+		t.Errorf("expected an error for an unknown rule version")
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"receipt_processor/pkg/auth"
+	"receipt_processor/pkg/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrReceiptNotFound is returned when voiding or refunding a receipt that does not exist.
+	ErrReceiptNotFound = errors.New("receipt not found")
+	// ErrAlreadyReversed is returned when voiding or refunding an item that was already
+	// refunded, or a receipt that was voided.
+	ErrAlreadyReversed = repository.ErrAlreadyReversed
+	// ErrInvalidItem is returned when refunding an item that the receipt does not have, or
+	// listing an item twice.
+	ErrInvalidItem = errors.New("receipt has no such item")
+	// ErrNotReceiptUser is returned when voiding or refunding a receipt for a client that may
+	// not act for the receipt's user.
+	ErrNotReceiptUser = errors.New("client may not act for the receipt's user")
+	// ErrReversalConflict is returned when voiding or refunding a receipt that other reversals
+	// kept being stored for while its points were computed.
+	ErrReversalConflict = repository.ErrReversalConflict
+)
+
+// maxReversalAttempts is the number of times the points of a void or refund are computed from
+// the receipt's reversals before it fails with ErrReversalConflict.
+const maxReversalAttempts = 3
+
+// ReversalDTO describes a void or partial refund of a receipt.
+type ReversalDTO struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`   // "void" or "refund".
+	Items     []int     `json:"items"`  // Indexes of the reversed items in the receipt.
+	Points    int       `json:"points"` // The points clawed back.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReceiptPointsDTO holds the points of a receipt: those awarded when it was scored, less
+// the points clawed back by its reversals.
+type ReceiptPointsDTO struct {
+	Points        int `json:"points"`
+	AwardedPoints int `json:"awardedPoints"`
+	// Campaigns lists the campaigns that awarded part of AwardedPoints.
+	Campaigns []CampaignPointsDTO `json:"campaigns,omitempty"`
+	Reversals []ReversalDTO       `json:"reversals,omitempty"`
+}
+
+// VoidReceipt reverses every item of the receipt that has not been refunded, clawing back
+// all of its remaining points.
+func (s *receiptService) VoidReceipt(ctx context.Context, receiptID string) (ReversalDTO, error) {
+	return s.reverse(ctx, receiptID, repository.ReversalVoid, func(model repository.ReceiptModel) ([]int, int, error) {
+		reversed := reversedItems(model)
+		var items []int
+		for i := range model.Items {
+			if !reversed[i] {
+				items = append(items, i)
+			}
+		}
+		if len(items) == 0 {
+			return nil, 0, ErrAlreadyReversed
+		}
+		return items, netPoints(model), nil
+	})
+}
+
+// RefundItems reverses the given items of the receipt. The points clawed back are the
+// difference between the receipt's remaining points and the points the rules award the
+// receipt without any of its refunded items, with the total reduced by their prices. The
+// receipt is rescored with the version of the rules that scored it, if it is still loaded,
+// and the campaigns that awarded it points, as recorded in its breakdown.
+// A refund never awards points, even where the rules would award more for the reduced receipt.
+func (s *receiptService) RefundItems(ctx context.Context, receiptID string, items []int) (ReversalDTO, error) {
+	return s.reverse(ctx, receiptID, repository.ReversalRefund, func(model repository.ReceiptModel) ([]int, int, error) {
+		clawback, err := s.refundClawback(model, items)
+		return items, clawback, err
+	})
+}
+
+// refundClawback returns the points clawed back by refunding the items of the receipt, given
+// its stored reversals.
+func (s *receiptService) refundClawback(model repository.ReceiptModel, items []int) (int, error) {
+	if len(items) == 0 {
+		return 0, fmt.Errorf("%w: no items given", ErrInvalidItem)
+	}
+	reversed := reversedItems(model)
+	refunded := make(map[int]bool)
+	for _, index := range items {
+		if index < 0 || index >= len(model.Items) || refunded[index] {
+			return 0, fmt.Errorf("%w: %d", ErrInvalidItem, index)
+		}
+		if reversed[index] {
+			return 0, ErrAlreadyReversed
+		}
+		reversed[index] = true
+		refunded[index] = true
+	}
+
+	// Rescore what is left of the receipt.
+	remaining, err := parseReceipt(convertReceiptModel(model).ReceiptDTO)
+	if err != nil {
+		return 0, err
+	}
+	remaining.Items = nil
+	for i, item := range model.Items {
+		if reversed[i] {
+			remaining.Total -= item.Price
+			continue
+		}
+		remaining.Items = append(remaining.Items, Item{ShortDescription: item.ShortDescription, Price: item.Price})
+	}
+	if remaining.Total < 0 {
+		remaining.Total = 0
+	}
+	points := 0
+	if len(remaining.Items) > 0 {
+		rules, ok := s.rules.Get(model.RuleVersion)
+		if !ok {
+			_, rules = s.rules.Active()
+		}
+		breakdown, err := evaluateRules(remaining, rules)
+		if err != nil {
+			return 0, err
+		}
+		points = breakdown.Points + remainingCampaignPoints(model, breakdown.Points, reversed)
+	}
+	return max(netPoints(model)-points, 0), nil
+}
+
+// reversibleReceipt retrieves the receipt, with its reversals, within the tenant of the client.
+// It returns ErrNotReceiptUser unless the client may act for the receipt's user; only admins
+// may reverse the receipts that belong to no user.
+func (s *receiptService) reversibleReceipt(ctx context.Context, receiptID string) (repository.ReceiptModel, error) {
+	model, err := s.receiptRepo.GetByID(ctx, auth.TenantID(ctx), receiptID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model, ErrReceiptNotFound
+	}
+	if err != nil {
+		return model, err
+	}
+	if !auth.CanActFor(ctx, model.UserID) {
+		return model, ErrNotReceiptUser
+	}
+	return model, nil
+}
+
+// reverse stores a reversal of the receipt of the given kind, with the items and points that
+// plan computes from the receipt and its reversals. If another reversal of the receipt is stored
+// first, the receipt is retrieved again and the reversal recomputed, up to maxReversalAttempts
+// times, so the points clawed back never count the same points twice.
+func (s *receiptService) reverse(ctx context.Context, receiptID, kind string, plan func(model repository.ReceiptModel) ([]int, int, error)) (ReversalDTO, error) {
+	for attempt := 1; ; attempt++ {
+		model, err := s.reversibleReceipt(ctx, receiptID)
+		if err != nil {
+			return ReversalDTO{}, err
+		}
+		items, points, err := plan(model)
+		if err != nil {
+			return ReversalDTO{}, err
+		}
+		reversal, err := s.saveReversal(ctx, model, kind, items, points)
+		if errors.Is(err, ErrReversalConflict) && attempt < maxReversalAttempts {
+			continue
+		}
+		return reversal, err
+	}
+}
+
+// saveReversal stores a reversal of the items of the receipt, following its stored reversals.
+func (s *receiptService) saveReversal(ctx context.Context, receipt repository.ReceiptModel, kind string, items []int, points int) (ReversalDTO, error) {
+	reversal := repository.ReversalModel{
+		ID:        uuid.New().String(),
+		TenantID:  receipt.TenantID,
+		ReceiptID: receipt.ID,
+		Sequence:  len(receipt.Reversals),
+		Kind:      kind,
+		Points:    points,
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, index := range items {
+		reversal.Items = append(reversal.Items, repository.ReversalItemModel{ItemIndex: index})
+	}
+	if err := s.receiptRepo.SaveReversal(ctx, reversal); err != nil {
+		return ReversalDTO{}, err
+	}
+	return convertReversalModel(reversal), nil
+}
+
+// reversedItems returns the indexes of the receipt's items that have been reversed.
+func reversedItems(receipt repository.ReceiptModel) map[int]bool {
+	reversed := make(map[int]bool)
+	for _, reversal := range receipt.Reversals {
+		for _, item := range reversal.Items {
+			reversed[item.ItemIndex] = true
+		}
+	}
+	return reversed
+}
+
+// netPoints returns the points awarded to the receipt less those clawed back by its reversals.
+func netPoints(receipt repository.ReceiptModel) int {
+	points := receipt.Points
+	for _, reversal := range receipt.Reversals {
+		points -= reversal.Points
+	}
+	return points
+}
+
+// convertReversalModel transforms a repository.ReversalModel into a ReversalDTO.
+func convertReversalModel(model repository.ReversalModel) ReversalDTO {
+	items := make([]int, len(model.Items))
+	for i, item := range model.Items {
+		items[i] = item.ItemIndex
+	}
+	return ReversalDTO{
+		ID:        model.ID,
+		Kind:      model.Kind,
+		Items:     items,
+		Points:    model.Points,
+		CreatedAt: model.CreatedAt,
+	}
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"receipt_processor/pkg/repository"
+)
+
+func TestReverseReceipt(t *testing.T) {
+	db := newTestDB(t, "reversal_service_test")
+	receipts := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	points := NewPointsService(repository.NewLedgerRepository(db))
+	ctx := userContext(context.Background(), "user-1")
+
+	id, err := receipts.ProcessReceipt(ctx, ReceiptDTO{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	// Only the receipt's user may reverse it.
+	other := userContext(context.Background(), "user-2")
+	if _, err := receipts.RefundItems(other, id, []int{0}); !errors.Is(err, ErrNotReceiptUser) {
+		t.Errorf("expected ErrNotReceiptUser refunding another user's receipt, got %v", err)
+	}
+	if _, err := receipts.VoidReceipt(context.Background(), id); !errors.Is(err, ErrNotReceiptUser) {
+		t.Errorf("expected ErrNotReceiptUser voiding anonymously, got %v", err)
+	}
+
+	// Refunding an item rescores the receipt as three items totalling 6.75: it loses the
+	// round dollar bonus and one item pair.
+	refund, err := receipts.RefundItems(ctx, id, []int{0})
+	if err != nil {
+		t.Fatalf("failed to refund item: %v", err)
+	}
+	if refund.Kind != repository.ReversalRefund || refund.Points != 55 || len(refund.Items) != 1 || refund.Items[0] != 0 {
+		t.Errorf("expected a refund of item 0 clawing back 55 points, got %+v", refund)
+	}
+
+	// Refunds of unknown, repeated or refunded items are rejected.
+	for _, items := range [][]int{{4}, {1, 1}} {
+		if _, err := receipts.RefundItems(ctx, id, items); !errors.Is(err, ErrInvalidItem) {
+			t.Errorf("refunding %v: expected ErrInvalidItem, got %v", items, err)
+		}
+	}
+	if _, err := receipts.RefundItems(ctx, id, []int{1, 0}); !errors.Is(err, ErrAlreadyReversed) {
+		t.Errorf("expected ErrAlreadyReversed, got %v", err)
+	}
+
+	// Voiding the receipt claws back the rest of its points.
+	void, err := receipts.VoidReceipt(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to void receipt: %v", err)
+	}
+	if void.Kind != repository.ReversalVoid || void.Points != 54 || len(void.Items) != 3 {
+		t.Errorf("expected a void of the 3 remaining items clawing back 54 points, got %+v", void)
+	}
+	if _, err := receipts.VoidReceipt(ctx, id); !errors.Is(err, ErrAlreadyReversed) {
+		t.Errorf("expected ErrAlreadyReversed when voiding again, got %v", err)
+	}
+	if _, err := receipts.VoidReceipt(ctx, "missing-id"); !errors.Is(err, ErrReceiptNotFound) {
+		t.Errorf("expected ErrReceiptNotFound, got %v", err)
+	}
+
+	// The receipt keeps the points it was awarded, and lists its reversals.
+	receiptPoints, err := receipts.GetPoints(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	if receiptPoints.Points != 0 || receiptPoints.AwardedPoints != 109 || len(receiptPoints.Reversals) != 2 ||
+		receiptPoints.Reversals[0].ID != refund.ID || receiptPoints.Reversals[1].ID != void.ID {
+		t.Errorf("expected 0 of 109 points with the refund and void, got %+v", receiptPoints)
+	}
+	balance, err := points.GetUserPoints(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("failed to get user points: %v", err)
+	}
+	if balance.Points != 0 || len(balance.Entries) != 3 || balance.Entries[0].Kind != repository.LedgerKindReverse {
+		t.Errorf("expected the clawbacks to cancel the earned points, got %+v", balance)
+	}
+}
+
+// racingReceiptRepository runs race before the next reversal is saved, as if another request
+// had reversed the receipt after the reversal's points were computed.
+type racingReceiptRepository struct {
+	repository.IReceiptRepository
+	race func()
+}
+
+func (r *racingReceiptRepository) SaveReversal(ctx context.Context, reversal repository.ReversalModel) error {
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+	return r.IReceiptRepository.SaveReversal(ctx, reversal)
+}
+
+func TestReverseReceiptRace(t *testing.T) {
+	db := newTestDB(t, "reversal_race_service_test")
+	repo := &racingReceiptRepository{IReceiptRepository: repository.NewReceiptRepository(db)}
+	receipts := NewReceiptService(repo, repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	ctx := userContext(context.Background(), "user-1")
+
+	id, err := receipts.ProcessReceipt(ctx, ReceiptDTO{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	// Item 0 is refunded while item 1 is: refunding item 0 claws back 55 of the 109 points, and
+	// the receipt without items 0 and 1 scores the same 54 points, so item 1 claws back nothing.
+	var raced ReversalDTO
+	repo.race = func() {
+		if raced, err = receipts.RefundItems(ctx, id, []int{0}); err != nil {
+			t.Errorf("failed to refund item 0: %v", err)
+		}
+	}
+	refund, err := receipts.RefundItems(ctx, id, []int{1})
+	if err != nil {
+		t.Fatalf("failed to refund item 1: %v", err)
+	}
+	if raced.Points != 55 || refund.Points != 0 {
+		t.Errorf("expected the refunds to claw back 55 and 0 points, got %d and %d", raced.Points, refund.Points)
+	}
+
+	// Item 2 is refunded while the receipt is voided: the refund claws back 5 points, and the
+	// void the 49 left.
+	repo.race = func() {
+		if raced, err = receipts.RefundItems(ctx, id, []int{2}); err != nil {
+			t.Errorf("failed to refund item 2: %v", err)
+		}
+	}
+	void, err := receipts.VoidReceipt(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to void receipt: %v", err)
+	}
+	if raced.Points != 5 || void.Points != 49 || len(void.Items) != 1 || void.Items[0] != 3 {
+		t.Errorf("expected the refund to claw back 5 points and the void 49 of item 3, got %+v and %+v", raced, void)
+	}
+	receiptPoints, err := receipts.GetPoints(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get points: %v", err)
+	}
+	if receiptPoints.Points != 0 || len(receiptPoints.Reversals) != 4 {
+		t.Errorf("expected no points left after 4 reversals, got %+v", receiptPoints)
+	}
+}
+
+func TestVoidExpiredReceipt(t *testing.T) {
+	db := newTestDB(t, "reversal_expired_test")
+	receipts := NewReceiptService(repository.NewReceiptRepository(db), repository.NewCampaignRepository(db), repository.NewMerchantRepository(db), DefaultRuleVersions(), ConsistencyPolicy{})
+	points := NewPointsService(repository.NewLedgerRepository(db))
+	expirations := repository.NewExpirationRepository(db)
+	ctx := userContext(context.Background(), "user-1")
+
+	id, err := receipts.ProcessReceipt(ctx, ReceiptDTO{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to process receipt: %v", err)
+	}
+
+	// Refunding an item claws back 55 of the 109 points, and the other 54 expire.
+	if _, err := receipts.RefundItems(ctx, id, []int{0}); err != nil {
+		t.Fatalf("failed to refund item: %v", err)
+	}
+	if _, err := expirations.Expire(ctx, "2023-03-20"); err != nil {
+		t.Fatalf("failed to expire points: %v", err)
+	}
+
+	// Voiding the receipt reverses its remaining points, but does not debit the expired
+	// points from the user again.
+	void, err := receipts.VoidReceipt(ctx, id)
+	if err != nil {
+		t.Fatalf("failed to void receipt: %v", err)
+	}
+	if void.Points != 54 {
+		t.Errorf("expected the void to reverse the receipt's 54 remaining points, got %+v", void)
+	}
+	balance, err := points.GetUserPoints(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("failed to get user points: %v", err)
+	}
+	if balance.Points != 0 || len(balance.Entries) != 3 || balance.Entries[0].Kind != repository.LedgerKindExpire {
+		t.Errorf("expected the expiration to be the last entry, leaving a balance of 0, got %+v", balance)
+	}
+}
@@ -0,0 +1,495 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"receipt_processor/pkg/money"
+
+	"github.com/spf13/viper"
+)
+
+// Receipt is a parsed receipt as evaluated by the points rules.
+type Receipt struct {
+	Retailer     string
+	PurchaseDate string
+	PurchaseTime string
+	PurchasedAt  time.Time // In the store's local time.
+	Total        money.Cents
+	Items        []Item
+}
+
+// Item is a parsed line item of a Receipt.
+type Item struct {
+	ShortDescription string
+	Price            money.Cents
+}
+
+// parseReceipt converts a ReceiptDTO into a Receipt, parsing its amounts and purchase time.
+func parseReceipt(dto ReceiptDTO) (Receipt, error) {
+	purchasedAt, err := PurchaseTime(dto)
+	if err != nil {
+		return Receipt{}, err
+	}
+	total, err := money.Parse(dto.Total)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("invalid total amount: %v", err)
+	}
+	receipt := Receipt{
+		Retailer:     dto.Retailer,
+		PurchaseDate: dto.PurchaseDate,
+		PurchaseTime: dto.PurchaseTime,
+		PurchasedAt:  purchasedAt,
+		Total:        total,
+	}
+	for _, item := range dto.Items {
+		price, err := money.Parse(item.Price)
+		if err != nil {
+			return Receipt{}, fmt.Errorf("invalid item price: %v", err)
+		}
+		receipt.Items = append(receipt.Items, Item{ShortDescription: item.ShortDescription, Price: price})
+	}
+	return receipt, nil
+}
+
+// Rule is a single points rule evaluated against a receipt.
+type Rule interface {
+	// Name returns the identifier of the rule as used in configuration.
+	Name() string
+	// Evaluate returns the points the rule awards for the given receipt,
+	// along with the line items that triggered it, if any.
+	Evaluate(receipt Receipt) (RuleResult, error)
+}
+
+// RuleResult is the outcome of evaluating a single rule against a receipt.
+type RuleResult struct {
+	Points int
+	Items  []ItemContribution
+}
+
+// PointsBreakdown explains how a receipt's points were awarded.
+type PointsBreakdown struct {
+	Points int `json:"points"`
+	// RuleVersion is the version of the rules that scored the receipt. It is empty for
+	// receipts scored before versions were recorded.
+	RuleVersion string             `json:"ruleVersion,omitempty"`
+	Rules       []RuleContribution `json:"rules"`
+}
+
+// RuleContribution records the points a rule, or a campaign, contributed to a receipt.
+type RuleContribution struct {
+	Rule     string             `json:"rule"`               // The name of the rule or campaign.
+	Campaign string             `json:"campaign,omitempty"` // The ID of the campaign, if any.
+	Points   int                `json:"points"`
+	Items    []ItemContribution `json:"items,omitempty"`
+	// Multiplier is the campaign's multiplier of the rules' points, recorded so refunds can
+	// rescore the campaign as it was when the receipt was scored.
+	Multiplier float64 `json:"-"`
+}
+
+// ItemContribution records a line item that triggered a rule.
+type ItemContribution struct {
+	Index            int         `json:"index"` // Zero-based position of the item on the receipt.
+	ShortDescription string      `json:"shortDescription"`
+	Price            money.Cents `json:"price"`
+	Points           int         `json:"points"`
+}
+
+// RuleSet is an ordered list of rules. Rules are evaluated in order and their
+// points are summed.
+type RuleSet []Rule
+
+// RuleConfig describes a single rule entry loaded from configuration.
+// Only the fields relevant to the named rule are used.
+type RuleConfig struct {
+	Name       string  `mapstructure:"name"`
+	Enabled    *bool   `mapstructure:"enabled"` // Defaults to true when omitted.
+	Points     int     `mapstructure:"points"`
+	Multiplier float64 `mapstructure:"multiplier"` // Precise to four decimal places.
+	Divisor    int     `mapstructure:"divisor"`
+	Threshold  string  `mapstructure:"threshold"` // E.g. "10.00"
+	StartHour  int     `mapstructure:"start_hour"`
+	EndHour    int     `mapstructure:"end_hour"`
+	Normalize  bool    `mapstructure:"normalize"` // Evaluate the normalized retailer name.
+}
+
+// RuleFactory builds a rule from its configuration.
+type RuleFactory func(cfg RuleConfig) (Rule, error)
+
+// ruleFactories maps rule names to the factories that build them.
+var ruleFactories = map[string]RuleFactory{
+	"retailer_alphanumeric":   newRetailerAlphanumericRule,
+	"round_total":             newRoundTotalRule,
+	"quarter_multiple_total":  newQuarterMultipleTotalRule,
+	"item_pairs":              newItemPairsRule,
+	"item_description_length": newItemDescriptionLengthRule,
+	"total_above":             newTotalAboveRule,
+	"odd_purchase_day":        newOddPurchaseDayRule,
+	"afternoon_purchase":      newAfternoonPurchaseRule,
+}
+
+// RegisterRule makes a rule type available to configuration under the given name.
+// Registering a name twice replaces the previous factory.
+func RegisterRule(name string, factory RuleFactory) {
+	ruleFactories[name] = factory
+}
+
+// DefaultRuleConfigs returns the configuration of the eight standard rules.
+func DefaultRuleConfigs() []RuleConfig {
+	return []RuleConfig{
+		{Name: "retailer_alphanumeric", Points: 1},
+		{Name: "round_total", Points: 50},
+		{Name: "quarter_multiple_total", Points: 25},
+		{Name: "item_pairs", Points: 5},
+		{Name: "item_description_length", Divisor: 3, Multiplier: 0.2},
+		{Name: "total_above", Points: 5, Threshold: "10.00"},
+		{Name: "odd_purchase_day", Points: 6},
+		{Name: "afternoon_purchase", Points: 10, StartHour: 14, EndHour: 16},
+	}
+}
+
+// DefaultRuleSet returns the rule set built from DefaultRuleConfigs.
+func DefaultRuleSet() RuleSet {
+	rules, err := NewRuleSet(DefaultRuleConfigs())
+	if err != nil {
+		// The defaults are static, so a failure here is a programming error.
+		panic(err)
+	}
+	return rules
+}
+
+// NewRuleSet builds a rule set from the given configurations, skipping disabled rules.
+func NewRuleSet(configs []RuleConfig) (RuleSet, error) {
+	var rules RuleSet
+	for _, cfg := range configs {
+		if cfg.Enabled != nil && !*cfg.Enabled {
+			continue
+		}
+		factory, ok := ruleFactories[cfg.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown points rule %q", cfg.Name)
+		}
+		rule, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid points rule %q: %w", cfg.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// LoadRuleSet builds the rule set from the "points.rules" configuration key.
+// If no rules are configured, the default rule set is returned.
+func LoadRuleSet() (RuleSet, error) {
+	if !viper.IsSet("points.rules") {
+		return DefaultRuleSet(), nil
+	}
+	var configs []RuleConfig
+	if err := viper.UnmarshalKey("points.rules", &configs); err != nil {
+		return nil, fmt.Errorf("failed to read points rules: %w", err)
+	}
+	return NewRuleSet(configs)
+}
+
+// DefaultRuleVersion is the version ID of the rules under "points.rules" when
+// "points.rule_version" is not set.
+const DefaultRuleVersion = "v1"
+
+// RuleVersions holds versions of the rules side by side, by ID. New receipts are scored
+// with the active version and record its ID; the other versions are used to rescore them.
+type RuleVersions struct {
+	active string
+	sets   map[string]RuleSet
+}
+
+// NewRuleVersions creates rule versions with the given active version.
+func NewRuleVersions(active string, rules RuleSet) RuleVersions {
+	return RuleVersions{active: active, sets: map[string]RuleSet{active: rules}}
+}
+
+// DefaultRuleVersions returns the default rule set as DefaultRuleVersion.
+func DefaultRuleVersions() RuleVersions {
+	return NewRuleVersions(DefaultRuleVersion, DefaultRuleSet())
+}
+
+// Add loads another version of the rules. It returns an error if the ID is already loaded.
+func (v RuleVersions) Add(id string, rules RuleSet) error {
+	if _, ok := v.sets[id]; ok {
+		return fmt.Errorf("rule version %q is defined twice", id)
+	}
+	v.sets[id] = rules
+	return nil
+}
+
+// Active returns the ID and rules of the version that scores new receipts.
+func (v RuleVersions) Active() (string, RuleSet) {
+	return v.active, v.sets[v.active]
+}
+
+// Get returns the rules of the version with the given ID, if it is loaded.
+func (v RuleVersions) Get(id string) (RuleSet, bool) {
+	rules, ok := v.sets[id]
+	return rules, ok
+}
+
+// IDs returns the IDs of the loaded versions in order.
+func (v RuleVersions) IDs() []string {
+	ids := make([]string, 0, len(v.sets))
+	for id := range v.sets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// RuleVersionConfig describes a version of the rules loaded from configuration.
+type RuleVersionConfig struct {
+	ID    string       `mapstructure:"id"`
+	Rules []RuleConfig `mapstructure:"rules"`
+}
+
+// LoadRuleVersions loads the rules under "points.rules" as the active version, named by
+// "points.rule_version", and the other versions listed under "points.rule_versions".
+func LoadRuleVersions() (RuleVersions, error) {
+	rules, err := LoadRuleSet()
+	if err != nil {
+		return RuleVersions{}, err
+	}
+	active := DefaultRuleVersion
+	if viper.IsSet("points.rule_version") {
+		active = viper.GetString("points.rule_version")
+	}
+	if active == "" {
+		return RuleVersions{}, fmt.Errorf("points.rule_version must not be empty")
+	}
+	versions := NewRuleVersions(active, rules)
+
+	var configs []RuleVersionConfig
+	if err := viper.UnmarshalKey("points.rule_versions", &configs); err != nil {
+		return RuleVersions{}, fmt.Errorf("failed to read rule versions: %w", err)
+	}
+	for _, cfg := range configs {
+		if cfg.ID == "" {
+			return RuleVersions{}, fmt.Errorf("rule versions must have an id")
+		}
+		if len(cfg.Rules) == 0 {
+			return RuleVersions{}, fmt.Errorf("rule version %q has no rules", cfg.ID)
+		}
+		rules, err := NewRuleSet(cfg.Rules)
+		if err != nil {
+			return RuleVersions{}, fmt.Errorf("rule version %q: %w", cfg.ID, err)
+		}
+		if err := versions.Add(cfg.ID, rules); err != nil {
+			return RuleVersions{}, err
+		}
+	}
+	return versions, nil
+}
+
+// retailerAlphanumericRule awards points for every alphanumeric character in the retailer
+// name. With normalize set, the normalized name is counted, so e.g. "Target" and
+// "TARGET Store #123" score the same.
+type retailerAlphanumericRule struct {
+	points    int
+	normalize bool
+}
+
+func newRetailerAlphanumericRule(cfg RuleConfig) (Rule, error) {
+	return &retailerAlphanumericRule{points: cfg.Points, normalize: cfg.Normalize}, nil
+}
+
+func (r *retailerAlphanumericRule) Name() string { return "retailer_alphanumeric" }
+
+func (r *retailerAlphanumericRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	retailer := receipt.Retailer
+	if r.normalize {
+		retailer = normalizeRetailer(retailer)
+	}
+	var count int
+	for _, ch := range retailer {
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') {
+			count++
+		}
+	}
+	return RuleResult{Points: count * r.points}, nil
+}
+
+// roundTotalRule awards points if the total is a round dollar amount with no cents.
+type roundTotalRule struct {
+	points int
+}
+
+func newRoundTotalRule(cfg RuleConfig) (Rule, error) {
+	return &roundTotalRule{points: cfg.Points}, nil
+}
+
+func (r *roundTotalRule) Name() string { return "round_total" }
+
+func (r *roundTotalRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	if receipt.Total.IsWholeDollar() {
+		return RuleResult{Points: r.points}, nil
+	}
+	return RuleResult{}, nil
+}
+
+// quarter is the amount the quarterMultipleTotalRule checks the total against.
+const quarter = money.Cents(25)
+
+// quarterMultipleTotalRule awards points if the total is a multiple of 0.25.
+type quarterMultipleTotalRule struct {
+	points int
+}
+
+func newQuarterMultipleTotalRule(cfg RuleConfig) (Rule, error) {
+	return &quarterMultipleTotalRule{points: cfg.Points}, nil
+}
+
+func (r *quarterMultipleTotalRule) Name() string { return "quarter_multiple_total" }
+
+func (r *quarterMultipleTotalRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	if receipt.Total.IsMultipleOf(quarter) {
+		return RuleResult{Points: r.points}, nil
+	}
+	return RuleResult{}, nil
+}
+
+// itemPairsRule awards points for every two items on the receipt.
+type itemPairsRule struct {
+	points int
+}
+
+func newItemPairsRule(cfg RuleConfig) (Rule, error) {
+	return &itemPairsRule{points: cfg.Points}, nil
+}
+
+func (r *itemPairsRule) Name() string { return "item_pairs" }
+
+func (r *itemPairsRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	pairs := len(receipt.Items) / 2
+	result := RuleResult{Points: pairs * r.points}
+	// List every paired item, attributing each pair's points to the item that completes it.
+	for i, item := range receipt.Items[:pairs*2] {
+		var points int
+		if i%2 == 1 {
+			points = r.points
+		}
+		result.Items = append(result.Items, ItemContribution{
+			Index:            i,
+			ShortDescription: item.ShortDescription,
+			Price:            item.Price,
+			Points:           points,
+		})
+	}
+	return result, nil
+}
+
+// multiplierScale is the denominator used to hold rule multipliers as exact integers.
+const multiplierScale = 10000
+
+// itemDescriptionLengthRule awards ceil(price * multiplier) points for each item
+// whose trimmed description length is a multiple of the divisor.
+type itemDescriptionLengthRule struct {
+	divisor int
+	// multiplier is scaled by multiplierScale, so 0.2 is held as 2000.
+	multiplier int64
+}
+
+func newItemDescriptionLengthRule(cfg RuleConfig) (Rule, error) {
+	if cfg.Divisor <= 0 {
+		return nil, fmt.Errorf("divisor must be positive")
+	}
+	return &itemDescriptionLengthRule{
+		divisor:    cfg.Divisor,
+		multiplier: int64(math.Round(cfg.Multiplier * multiplierScale)),
+	}, nil
+}
+
+func (r *itemDescriptionLengthRule) Name() string { return "item_description_length" }
+
+func (r *itemDescriptionLengthRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	var result RuleResult
+	for i, item := range receipt.Items {
+		trimmed := strings.TrimSpace(item.ShortDescription)
+		if len(trimmed)%r.divisor != 0 {
+			continue
+		}
+		points := int(item.Price.MulCeil(r.multiplier, multiplierScale))
+		result.Points += points
+		result.Items = append(result.Items, ItemContribution{
+			Index:            i,
+			ShortDescription: item.ShortDescription,
+			Price:            item.Price,
+			Points:           points,
+		})
+	}
+	return result, nil
+}
+
+// totalAboveRule awards points if the total is greater than the threshold.
+type totalAboveRule struct {
+	points    int
+	threshold money.Cents
+}
+
+func newTotalAboveRule(cfg RuleConfig) (Rule, error) {
+	threshold, err := money.Parse(cfg.Threshold)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold: %v", err)
+	}
+	return &totalAboveRule{points: cfg.Points, threshold: threshold}, nil
+}
+
+func (r *totalAboveRule) Name() string { return "total_above" }
+
+func (r *totalAboveRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	if receipt.Total > r.threshold {
+		return RuleResult{Points: r.points}, nil
+	}
+	return RuleResult{}, nil
+}
+
+// oddPurchaseDayRule awards points if the day in the purchase date is odd.
+type oddPurchaseDayRule struct {
+	points int
+}
+
+func newOddPurchaseDayRule(cfg RuleConfig) (Rule, error) {
+	return &oddPurchaseDayRule{points: cfg.Points}, nil
+}
+
+func (r *oddPurchaseDayRule) Name() string { return "odd_purchase_day" }
+
+func (r *oddPurchaseDayRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	if receipt.PurchasedAt.Day()%2 == 1 {
+		return RuleResult{Points: r.points}, nil
+	}
+	return RuleResult{}, nil
+}
+
+// afternoonPurchaseRule awards points if the purchase hour, in the store's local time,
+// is within [startHour, endHour).
+type afternoonPurchaseRule struct {
+	points    int
+	startHour int
+	endHour   int
+}
+
+func newAfternoonPurchaseRule(cfg RuleConfig) (Rule, error) {
+	if cfg.StartHour >= cfg.EndHour {
+		return nil, fmt.Errorf("start_hour must be before end_hour")
+	}
+	return &afternoonPurchaseRule{points: cfg.Points, startHour: cfg.StartHour, endHour: cfg.EndHour}, nil
+}
+
+func (r *afternoonPurchaseRule) Name() string { return "afternoon_purchase" }
+
+func (r *afternoonPurchaseRule) Evaluate(receipt Receipt) (RuleResult, error) {
+	hour := receipt.PurchasedAt.Hour()
+	if hour >= r.startHour && hour < r.endHour {
+		return RuleResult{Points: r.points}, nil
+	}
+	return RuleResult{}, nil
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestNewRuleSet(t *testing.T) {
+	disabled := false
+	receipt := ReceiptDTO{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "35.00",
+		Items: []ItemDTO{
+			{ShortDescription: "Item A", Price: "10.00"},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		configs        []RuleConfig
+		expectedPoints int
+		expectError    bool
+	}{
+		{
+			name:           "Single rule",
+			configs:        []RuleConfig{{Name: "round_total", Points: 50}},
+			expectedPoints: 50,
+		},
+		{
+			name:           "Changed weight",
+			configs:        []RuleConfig{{Name: "retailer_alphanumeric", Points: 2}},
+			expectedPoints: 12,
+		},
+		{
+			name: "Disabled rule is skipped",
+			configs: []RuleConfig{
+				{Name: "round_total", Points: 50, Enabled: &disabled},
+				{Name: "odd_purchase_day", Points: 6},
+			},
+			expectedPoints: 6,
+		},
+		{
+			name:        "Unknown rule",
+			configs:     []RuleConfig{{Name: "no_such_rule"}},
+			expectError: true,
+		},
+		{
+			name:        "Invalid parameters",
+			configs:     []RuleConfig{{Name: "afternoon_purchase", StartHour: 16, EndHour: 14}},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := NewRuleSet(tc.configs)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("did not expect error but got: %v", err)
+			}
+			breakdown, err := calculatePoints(receipt, rules)
+			if err != nil {
+				t.Fatalf("failed to calculate points: %v", err)
+			}
+			if breakdown.Points != tc.expectedPoints {
+				t.Errorf("expected %d points, got %d", tc.expectedPoints, breakdown.Points)
+			}
+		})
+	}
+}
+
+func TestRetailerAlphanumericNormalize(t *testing.T) {
+	receipt := ReceiptDTO{
+		Retailer:     "TARGET Store #123",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "10:00",
+		Total:        "1.01",
+		Items:        []ItemDTO{{ShortDescription: "Item A", Price: "1.01"}},
+	}
+	testCases := []struct {
+		normalize      bool
+		expectedPoints int
+	}{
+		{normalize: false, expectedPoints: 14}, // "TARGETStore123"
+		{normalize: true, expectedPoints: 6},   // "target"
+	}
+	for _, tc := range testCases {
+		rules, err := NewRuleSet([]RuleConfig{{Name: "retailer_alphanumeric", Points: 1, Normalize: tc.normalize}})
+		if err != nil {
+			t.Fatalf("failed to build rules: %v", err)
+		}
+		breakdown, err := calculatePoints(receipt, rules)
+		if err != nil {
+			t.Fatalf("failed to calculate points: %v", err)
+		}
+		if breakdown.Points != tc.expectedPoints {
+			t.Errorf("normalize %v: expected %d points, got %d", tc.normalize, tc.expectedPoints, breakdown.Points)
+		}
+	}
+}
+
+func TestRulesUseExactAmounts(t *testing.T) {
+	// With float64 arithmetic, 100.00 * 0.55 is 55.00000000000001 and rounds up to 56.
+	rules, err := NewRuleSet([]RuleConfig{
+		{Name: "item_description_length", Divisor: 3, Multiplier: 0.55},
+		{Name: "total_above", Points: 5, Threshold: "100.00"},
+	})
+	if err != nil {
+		t.Fatalf("failed to build rules: %v", err)
+	}
+	breakdown, err := calculatePoints(ReceiptDTO{
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "10:00",
+		Total:        "100.00", // Not above the threshold.
+		Items:        []ItemDTO{{ShortDescription: "Abc", Price: "100.00"}},
+	}, rules)
+	if err != nil {
+		t.Fatalf("failed to calculate points: %v", err)
+	}
+	if breakdown.Points != 55 {
+		t.Errorf("expected 55 points, got %d", breakdown.Points)
+	}
+}
+
+func TestLoadRuleSet(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	// Without configuration, the default rules are used.
+	viper.Reset()
+	rules, err := LoadRuleSet()
+	if err != nil {
+		t.Fatalf("failed to load default rules: %v", err)
+	}
+	if len(rules) != len(DefaultRuleConfigs()) {
+		t.Errorf("expected %d default rules, got %d", len(DefaultRuleConfigs()), len(rules))
+	}
+
+	// Rules are read from YAML in order, honoring the enabled flag.
+	viper.SetConfigType("yaml")
+	config := `
+points:
+  rules:
+    - name: item_pairs
+      points: 10
+    - name: round_total
+      enabled: false
+      points: 50
+    - name: total_above
+      points: 7
+      threshold: "20.00"
+`
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	rules, err = LoadRuleSet()
+	if err != nil {
+		t.Fatalf("failed to load configured rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Name() != "item_pairs" || rules[1].Name() != "total_above" {
+		t.Fatalf("unexpected rules loaded: %v", rules)
+	}
+	breakdown, err := calculatePoints(ReceiptDTO{
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "10:00",
+		Total:        "25.00",
+		Items:        []ItemDTO{{Price: "1.00"}, {Price: "2.00"}},
+	}, rules)
+	if err != nil {
+		t.Fatalf("failed to calculate points: %v", err)
+	}
+	if breakdown.Points != 17 {
+		t.Errorf("expected 17 points, got %d", breakdown.Points)
+	}
+}
+
+func TestLoadRuleVersions(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	// Without configuration, the default rules are the only version.
+	viper.Reset()
+	versions, err := LoadRuleVersions()
+	if err != nil {
+		t.Fatalf("failed to load default rule versions: %v", err)
+	}
+	if active, rules := versions.Active(); active != DefaultRuleVersion || len(rules) != len(DefaultRuleConfigs()) {
+		t.Errorf("expected the default rules as %s, got %d rules as %q", DefaultRuleVersion, len(rules), active)
+	}
+
+	// Other versions are loaded side by side with the active one.
+	viper.SetConfigType("yaml")
+	config := `
+points:
+  rule_version: "2024"
+  rules:
+    - name: round_total
+      points: 50
+  rule_versions:
+    - id: "2025"
+      rules:
+        - name: round_total
+          points: 75
+        - name: item_pairs
+          points: 5
+`
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	versions, err = LoadRuleVersions()
+	if err != nil {
+		t.Fatalf("failed to load rule versions: %v", err)
+	}
+	if active, rules := versions.Active(); active != "2024" || len(rules) != 1 {
+		t.Errorf("expected 1 rule as 2024, got %d rules as %q", len(rules), active)
+	}
+	if rules, ok := versions.Get("2025"); !ok || len(rules) != 2 {
+		t.Errorf("expected version 2025 with 2 rules, got %v", rules)
+	}
+	if ids := versions.IDs(); strings.Join(ids, ",") != "2024,2025" {
+		t.Errorf("expected versions 2024 and 2025, got %v", ids)
+	}
+
+	// Versions must have unique IDs and valid rules.
+	invalid := []string{
+		"points:\n  rule_version: \"\"\n",
+		"points:\n  rule_versions:\n    - id: v1\n      rules:\n        - name: round_total\n",
+		"points:\n  rule_versions:\n    - rules:\n        - name: round_total\n",
+		"points:\n  rule_versions:\n    - id: v2\n",
+		"points:\n  rule_versions:\n    - id: v2\n      rules:\n        - name: unknown\n",
+	}
+	for _, config := range invalid {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+		if _, err := LoadRuleVersions(); err == nil {
+			t.Errorf("expected an error loading %q", config)
+		}
+	}
+}
+
+func TestRulesUseStoreLocalTime(t *testing.T) {
+	rules, err := NewRuleSet([]RuleConfig{
+		{Name: "odd_purchase_day", Points: 6},
+		{Name: "afternoon_purchase", Points: 10, StartHour: 14, EndHour: 16},
+	})
+	if err != nil {
+		t.Fatalf("failed to build rules: %v", err)
+	}
+
+	testCases := []struct {
+		name           string
+		date, time, tz string
+		expectedPoints int
+	}{
+		{name: "UTC by default", date: "2022-01-02", time: "14:30", expectedPoints: 10},
+		{name: "IANA timezone", date: "2022-01-02", time: "14:30", tz: "America/Chicago", expectedPoints: 10},
+		{name: "UTC offset", date: "2022-01-01", time: "15:59", tz: "-05:00", expectedPoints: 16},
+		{name: "Outside window", date: "2022-01-02", time: "16:00", tz: "Asia/Tokyo", expectedPoints: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			breakdown, err := calculatePoints(ReceiptDTO{
+				PurchaseDate: tc.date,
+				PurchaseTime: tc.time,
+				Timezone:     tc.tz,
+				Total:        "1.00",
+				Items:        []ItemDTO{{Price: "1.00"}},
+			}, rules)
+			if err != nil {
+				t.Fatalf("failed to calculate points: %v", err)
+			}
+			if breakdown.Points != tc.expectedPoints {
+				t.Errorf("expected %d points, got %d", tc.expectedPoints, breakdown.Points)
+			}
+		})
+	}
+}
+
+func TestPurchaseTime(t *testing.T) {
+	purchasedAt, err := PurchaseTime(ReceiptDTO{PurchaseDate: "2022-03-20", PurchaseTime: "14:33", Timezone: "America/New_York"})
+	if err != nil {
+		t.Fatalf("failed to parse purchase time: %v", err)
+	}
+	if got := purchasedAt.UTC().Format(time.RFC3339); got != "2022-03-20T18:33:00Z" {
+		t.Errorf("expected 2022-03-20T18:33:00Z, got %s", got)
+	}
+
+	for _, dto := range []ReceiptDTO{
+		{PurchaseDate: "2022-13-45", PurchaseTime: "14:33"},
+		{PurchaseDate: "2022-03-20", PurchaseTime: "99:99"},
+		{PurchaseDate: "2022-03-20", PurchaseTime: "14:33", Timezone: "Nowhere"},
+	} {
+		if _, err := PurchaseTime(dto); err == nil {
+			t.Errorf("expected error for %+v, got nil", dto)
+		}
+	}
+}